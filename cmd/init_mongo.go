@@ -29,11 +29,40 @@ func main() {
 		"type": "config",
 		"server": bson.M{
 			"port": 8082,
+			"tls": bson.M{
+				"port":              0,
+				"certdir":           "certs",
+				"minversion":        "1.2",
+				"expirywarningdays": 30,
+			},
+			"timeouts": bson.M{
+				"readheader": 10,
+				"read":       60,
+				"write":      0,
+				"idle":       120,
+				"dial":       10,
+				"upstream":   60,
+			},
 		},
 		"firewall": bson.M{
-			"mode":          "main",
-			"rulesfile":     "pkg/rules/rules.yaml",
-			"targetaddress": "localhost:80",
+			"mode":               "main",
+			"rulesfile":          "pkg/rules/rules.yaml",
+			"rulessource":        "database",
+			"reloadpollinterval": 10,
+			"targetaddress":      "localhost:80",
+			"blockpagedir":       "blockpages",
+			"anomalythreshold":   0,
+			"bodymaxdepth":       32,
+			"bodymaxfields":      1000,
+			"maxbodysize":        10 << 20,
+			"inspectsize":        128 << 10,
+			"oversizeaction":     "block",
+			"ratelimitbackend":   "memory",
+			"banthreshold":       20,
+			"banwindow":          60,
+			"banduration":        600,
+			"banmaxduration":     86400,
+			"banhistory":         604800,
 		},
 		"secrets": bson.M{
 			"sessionSecret": "YourSessionSecretHere",
@@ -47,21 +76,60 @@ func main() {
 		return
 	}
 
+	// 插入站点路由文档，示例路由将 api.example.com 的请求按最少连接转发到两个上游，并定期检查上游健康状态
+	sitesDoc := bson.M{
+		"type": "sites",
+		"routes": []bson.M{
+			{
+				"name":        "API",
+				"host":        "api.example.com",
+				"pathprefix":  "/",
+				"upstreams":   []string{"localhost:8001", "localhost:8002"},
+				"balance":     "least_conn",
+				"healthcheck": bson.M{"path": "/health", "interval": 10},
+				"rules":       bson.M{"exclude": []string{"Admin Access"}},
+			},
+		},
+	}
+
+	_, err = configCollection.InsertOne(context.Background(), sitesDoc)
+	if err != nil {
+		fmt.Printf("插入站点路由文档失败: %v\n", err)
+		return
+	}
+
 	// 插入规则文档
 	interceptionRulesDoc := bson.M{
 		"type": "interception",
 		"rules": []bson.M{
-			{"name": "Admin Access", "regex": "/admin", "method": "GET"},
-			{"name": "Login Access", "regex": "/login", "method": "POST"},
-			{"name": "SQL Injection - Drop", "regex": "DROP TABLE", "method": "POST"},
-			{"name": "SQL Injection - Select", "regex": "SELECT \\* FROM", "method": "GET"},
+			{"name": "Admin Access", "regex": "/admin", "method": "GET", "targets": []string{"path"}, "transforms": []string{"url_decode_recursive", "normalize_path", "lowercase"}},
+			{"name": "Login Access", "regex": "/login", "method": "POST", "targets": []string{"path"}},
+			{"name": "SQL Injection - Drop", "regex": "drop table", "method": "POST", "targets": []string{"body", "form", "json"}, "transforms": []string{"url_decode", "html_entity_decode", "lowercase", "compress_whitespace"}},
+			{"name": "SQL Injection - Select", "regex": "SELECT \\* FROM", "method": "GET", "targets": []string{"args", "cookies"}},
+			{"name": "SQL Injection - Detector", "operator": "sqli", "targets": []string{"args", "form", "json", "cookies"}, "transforms": []string{"url_decode_recursive", "html_entity_decode"}},
+			{"name": "Upload - Executable Files", "regex": "(?i)\\.(php\\d?|phtml|jsp|aspx?|exe|sh)$", "method": "POST", "targets": []string{"files"}},
+			{"name": "XSS - Detector", "operator": "xss", "targets": []string{"args", "form", "json"}, "transforms": []string{"url_decode_recursive"}},
 		},
 	}
 
 	ipControlRulesDoc := bson.M{
-		"type":      "ip_control",
-		"whitelist": []string{"192.168.1.100", "10.0.0.1"},
-		"blacklist": []string{"192.168.1.200", "10.0.0.2"},
+		"type": "ip_control",
+		"whitelist": []bson.M{
+			{"ip": "192.168.1.100", "comment": "内网管理主机", "created_by": "init", "created_at": time.Now()},
+			{"ip": "10.0.0.1", "comment": "内网网关", "created_by": "init", "created_at": time.Now()},
+		},
+		"blacklist": []bson.M{
+			{"ip": "192.168.1.200", "comment": "示例条目", "created_by": "init", "created_at": time.Now()},
+			{"ip": "10.0.0.2", "comment": "示例条目（30天后过期）", "created_by": "init", "created_at": time.Now(), "expires_at": time.Now().AddDate(0, 0, 30)},
+		},
+	}
+
+	rateLimitRulesDoc := bson.M{
+		"type": "rate_limit",
+		"rules": []bson.M{
+			{"name": "Login - Per IP", "key": "ip", "pathprefix": "/login", "method": "POST", "algorithm": "sliding_window", "limit": 10, "window": 60},
+			{"name": "API - Per Session", "key": "cookie:session", "pathprefix": "/api/", "algorithm": "token_bucket", "limit": 100, "window": 10},
+		},
 	}
 
 	_, err = rulesCollection.InsertOne(context.Background(), interceptionRulesDoc)
@@ -76,6 +144,12 @@ func main() {
 		return
 	}
 
+	_, err = rulesCollection.InsertOne(context.Background(), rateLimitRulesDoc)
+	if err != nil {
+		fmt.Printf("插入限流规则文档失败: %v\n", err)
+		return
+	}
+
 	// 初始化一个空的日志集合
 	_, err = logsCollection.InsertOne(context.Background(), bson.M{"initialized": true})
 	if err != nil {
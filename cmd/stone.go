@@ -3,20 +3,38 @@ package main
 import (
 	"Stone/pkg/api"
 	"Stone/pkg/api/handlers"
+	"Stone/pkg/bans"
 	"Stone/pkg/capture"
+	"Stone/pkg/certs"
+	"Stone/pkg/cli"
 	"Stone/pkg/config"
 	"Stone/pkg/logging"
 	"Stone/pkg/monitoring"
+	"Stone/pkg/processing"
+	"Stone/pkg/ratelimit"
+	"Stone/pkg/reload"
+	"Stone/pkg/routing"
 	"Stone/pkg/rules"
 	"context"
 	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"os"
 	"time"
 )
 
 func main() {
+	// 批量导入导出等子命令
+	if cli.IsCommand(os.Args[1:]) {
+		if err := cli.Run(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	monitoring.StartTime = time.Now()
 	logging.LogInfo("启动Stone防火墙")
 
@@ -40,10 +58,18 @@ func main() {
 	totpCollection := client.Database("stoneDB").Collection("totp")
 	userCollection := client.Database("stoneDB").Collection("users") // 新增的用户集合
 	metricsCollection := client.Database("stoneDB").Collection("metrics")
+	bansCollection := client.Database("stoneDB").Collection("bans")
+	ruleVersionsCollection := client.Database("stoneDB").Collection("rule_versions")
+	certificatesCollection := client.Database("stoneDB").Collection("certificates")
 
 	// 设置集合
 	config.SetMongoCollection(configCollection)
 	rules.SetMongoCollection(rulesCollection)
+	rules.SetHistoryCollection(ruleVersionsCollection)
+	ratelimit.SetMongoCollection(rulesCollection)
+	routing.SetMongoCollection(configCollection)
+	certs.SetMongoCollection(certificatesCollection)
+	bans.SetMongoCollection(bansCollection)
 	monitoring.SetMongoCollection(metricsCollection)
 	handlers.SetTOTPCollection(totpCollection)
 	handlers.SetUserCollection(userCollection) // 设置用户集合
@@ -56,22 +82,129 @@ func main() {
 		return
 	}
 
-	// 从MongoDB加载规则
-	_, err = rules.LoadInterceptionRules(context.Background())
+	// 设置代理超时和上游连接池，需要在加载站点路由之前设置
+	processing.Configure(processing.Timeouts{
+		ReadHeader: time.Duration(cfg.Server.Timeouts.ReadHeader) * time.Second,
+		Read:       time.Duration(cfg.Server.Timeouts.Read) * time.Second,
+		Write:      time.Duration(cfg.Server.Timeouts.Write) * time.Second,
+		Idle:       time.Duration(cfg.Server.Timeouts.Idle) * time.Second,
+		Dial:       time.Duration(cfg.Server.Timeouts.Dial) * time.Second,
+		Upstream:   time.Duration(cfg.Server.Timeouts.Upstream) * time.Second,
+	})
+
+	// 应用可以热加载的配置
+	bans.SetRedisClient(logging.RedisClient())
+	applyConfig(cfg)
+
+	// 按规则来源加载规则：file 只从规则文件加载，其余模式先从MongoDB加载
+	rules.SetRulesSource(cfg.Firewall.RulesSource)
+	if rules.RulesSource() != rules.SourceFile {
+		_, err = rules.LoadInterceptionRules(context.Background())
+		if err != nil {
+			logging.LogError(fmt.Errorf("加载拦截规则失败: %v", err))
+			return
+		}
+
+		_, err = rules.LoadIPControlRules(context.Background())
+		if err != nil {
+			logging.LogError(fmt.Errorf("加载IP控制规则失败: %v", err))
+			return
+		}
+	}
+
+	// file 和 file_seeds_database 模式下加载规则文件，并在文件变化时重新加载
+	if rules.RulesSource() != rules.SourceDatabase {
+		if err := rules.LoadRulesFile(cfg.Firewall.RulesFile); err != nil {
+			logging.LogError(fmt.Errorf("加载规则文件失败: %v", err))
+			return
+		}
+		if err := rules.WatchRulesFile(context.Background(), cfg.Firewall.RulesFile); err != nil {
+			logging.LogError(err)
+		}
+	}
+
+	_, err = ratelimit.LoadRules(context.Background())
 	if err != nil {
-		logging.LogError(fmt.Errorf("加载拦截规则失败: %v", err))
+		logging.LogError(fmt.Errorf("加载限流规则失败: %v", err))
 		return
 	}
 
-	_, err = rules.LoadIPControlRules(context.Background())
-	if err != nil {
-		logging.LogError(fmt.Errorf("加载IP控制规则失败: %v", err))
+	// 站点路由与配置保存在同一集合中，没有路由时全部请求转发到目标地址
+	routing.SetBlockPageDirectory(cfg.Firewall.BlockPageDir)
+	if _, err := routing.LoadSites(context.Background()); err != nil {
+		logging.LogError(fmt.Errorf("加载站点路由失败: %v", err))
 		return
 	}
 
+	// 从证书目录和MongoDB加载HTTPS证书，证书目录变化时重新加载
+	certs.SetDirectory(cfg.Server.TLS.CertDir)
+	if err := certs.Load(context.Background()); err != nil {
+		logging.LogError(fmt.Errorf("加载证书失败: %v", err))
+		return
+	}
+	certs.StartExpiryMonitor(context.Background(), time.Hour)
+	if cfg.Server.TLS.Port != 0 && cfg.Server.TLS.CertDir != "" {
+		if err := certs.WatchDirectory(context.Background(), cfg.Server.TLS.CertDir); err != nil {
+			logging.LogError(err)
+		}
+	}
+
+	// 规则版本历史为空时将当前规则记录为第一个版本
+	if err := rules.LoadVersionHistory(context.Background()); err != nil {
+		logging.LogError(fmt.Errorf("加载规则版本历史失败: %v", err))
+		return
+	}
+
+	// 加载自动封禁记录，并定期同步和清理过期的封禁
+	if err := bans.Load(context.Background()); err != nil {
+		logging.LogError(fmt.Errorf("加载封禁记录失败: %v", err))
+		return
+	}
+	bans.StartSweeper(context.Background(), 30*time.Second)
+
+	// 定期删除过期的IP控制规则
+	rules.StartIPSweeper(context.Background(), 30*time.Second)
+
+	// 监视其他实例或直接修改数据库产生的变化，重新加载规则和配置
+	pollInterval := time.Duration(cfg.Firewall.ReloadPollInterval) * time.Second
+	reload.Watch(context.Background(), reload.Source{
+		Name:       "rules",
+		Collection: rulesCollection,
+		Reload:     reloadRules,
+	}, pollInterval)
+	reload.Watch(context.Background(), reload.Source{
+		Name:       "config",
+		Collection: configCollection,
+		Filter:     bson.M{"type": "config"},
+		Reload: func(ctx context.Context) error {
+			cfg, err := config.LoadConfig(ctx)
+			if err != nil {
+				return err
+			}
+			applyConfig(cfg)
+			return nil
+		},
+	}, pollInterval)
+	reload.Watch(context.Background(), reload.Source{
+		Name:       "sites",
+		Collection: configCollection,
+		Filter:     bson.M{"type": "sites"},
+		Reload: func(ctx context.Context) error {
+			_, err := routing.LoadSites(ctx)
+			return err
+		},
+	}, pollInterval)
+
+	reload.Watch(context.Background(), reload.Source{
+		Name:       "certificates",
+		Collection: certificatesCollection,
+		Reload:     certs.Load,
+	}, pollInterval)
+
 	logging.LogInfo(fmt.Sprintf("服务器将在端口 %d 上运行", cfg.Server.Port))
 	logging.LogInfo(fmt.Sprintf("防火墙模式: %s", cfg.Firewall.Mode))
-	logging.LogInfo(fmt.Sprintf("规则文件: %s", cfg.Firewall.RulesFile))
+	logging.LogInfo(fmt.Sprintf("规则文件: %s（规则来源: %s）", cfg.Firewall.RulesFile, rules.RulesSource()))
+	logging.LogInfo(fmt.Sprintf("异常评分阈值: %d", cfg.Firewall.AnomalyThreshold))
 
 	go func() {
 		if err := capture.StartCapture(cfg.Server.Port, cfg.Firewall.TargetAddress); err != nil {
@@ -79,8 +212,61 @@ func main() {
 		}
 	}()
 
+	if cfg.Server.TLS.Port != 0 {
+		logging.LogInfo(fmt.Sprintf("HTTPS将在端口 %d 上运行", cfg.Server.TLS.Port))
+		go func() {
+			if err := capture.StartTLSCapture(cfg.Server.TLS.Port, cfg.Firewall.TargetAddress, certs.ServerConfig()); err != nil {
+				logging.LogError(fmt.Errorf("启动HTTPS流量捕获失败: %v", err))
+			}
+		}()
+	}
+
 	router := api.SetupRouter(configCollection, userCollection) // 传递用户集合
 	if err := router.Run(":8081"); err != nil {
 		log.Fatalf("启动API服务失败: %v", err)
 	}
 }
+
+// applyConfig 应用可以在运行时修改的配置，端口、超时、目标地址、证书目录和规则来源需要重启后生效
+func applyConfig(cfg *config.Config) {
+	// 设置防火墙模式（detect 为仅检测模式）
+	rules.SetMode(cfg.Firewall.Mode)
+	rules.SetAnomalyThreshold(cfg.Firewall.AnomalyThreshold)
+	rules.SetBodyLimits(rules.BodyLimits{MaxDepth: cfg.Firewall.BodyMaxDepth, MaxFields: cfg.Firewall.BodyMaxFields})
+	bodySizeLimits := make([]rules.BodySizeLimit, len(cfg.Firewall.BodySizeLimits))
+	for i, limit := range cfg.Firewall.BodySizeLimits {
+		bodySizeLimits[i] = rules.BodySizeLimit(limit)
+	}
+	rules.SetBodySizeLimits(rules.BodySizeLimit{
+		MaxBodySize: cfg.Firewall.MaxBodySize,
+		InspectSize: cfg.Firewall.InspectSize,
+		Action:      cfg.Firewall.OversizeAction,
+	}, bodySizeLimits)
+	ratelimit.SetBackend(cfg.Firewall.RateLimitBackend, logging.RedisClient())
+	certs.SetDefaultHost(cfg.Server.TLS.DefaultHost)
+	certs.SetExpiryWarning(cfg.Server.TLS.ExpiryWarningDays, cfg.Server.TLS.AlertWebhook)
+	if err := certs.SetOptions(cfg.Server.TLS.MinVersion, cfg.Server.TLS.CipherSuites); err != nil {
+		logging.LogError(fmt.Errorf("TLS配置无效，继续使用当前配置: %w", err))
+	}
+	bans.SetPolicy(bans.Policy{
+		Threshold:   cfg.Firewall.BanThreshold,
+		Window:      time.Duration(cfg.Firewall.BanWindow) * time.Second,
+		Duration:    time.Duration(cfg.Firewall.BanDuration) * time.Second,
+		MaxDuration: time.Duration(cfg.Firewall.BanMaxDuration) * time.Second,
+		History:     time.Duration(cfg.Firewall.BanHistory) * time.Second,
+	})
+}
+
+// reloadRules 从MongoDB重新加载规则，规则来源为 file 时拦截规则和IP控制规则只跟随规则文件
+func reloadRules(ctx context.Context) error {
+	if rules.RulesSource() != rules.SourceFile {
+		if _, err := rules.LoadInterceptionRules(ctx); err != nil {
+			return err
+		}
+		if _, err := rules.LoadIPControlRules(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := ratelimit.LoadRules(ctx)
+	return err
+}
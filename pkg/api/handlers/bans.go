@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"Stone/pkg/bans"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+// HandleBans 处理自动封禁记录的查询和解除
+func HandleBans(c *gin.Context) {
+	ip := c.Param("ip")
+	if ip != "" {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid IP address"})
+			return
+		}
+		ip = addr.Unmap().String()
+	}
+
+	switch c.Request.Method {
+	case http.MethodGet:
+		if ip == "" {
+			// 默认只返回有效的封禁，all=true 时包括已解除的记录
+			list, err := bans.List(c.Request.Context(), c.Query("all") == "true")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve bans"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"bans": list})
+			return
+		}
+		ban, err := bans.Get(c.Request.Context(), ip)
+		if err != nil {
+			if errors.Is(err, bans.ErrBanNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve ban"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ban": ban, "active": ban.Active(time.Now())})
+	case http.MethodDelete:
+		if ip == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "IP address cannot be empty"})
+			return
+		}
+		if err := bans.Lift(c.Request.Context(), ip); err != nil {
+			if errors.Is(err, bans.ErrBanNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Ban not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lift ban"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Ban lifted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// maxImportSize 单次批量导入的最大字节数
+const maxImportSize = 32 << 20
+
+// readImport 读取批量导入的内容：multipart 请求取 file 字段，否则取整个请求体
+// 格式优先取查询参数 format，其次取上传文件的扩展名，都没有时使用 defaultFormat
+func readImport(c *gin.Context, defaultFormat string) (io.Reader, string, error) {
+	format := strings.ToLower(c.Query("format"))
+
+	if file, header, err := c.Request.FormFile("file"); err == nil {
+		defer file.Close()
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxImportSize))
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewReader(data), normalizeFormat(format, defaultFormat), nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxImportSize))
+	if err != nil {
+		return nil, "", err
+	}
+	return bytes.NewReader(data), normalizeFormat(format, defaultFormat), nil
+}
+
+// normalizeFormat 统一格式名称，例如 yml 视为 yaml、text 视为 txt
+func normalizeFormat(format, defaultFormat string) string {
+	switch format {
+	case "":
+		return defaultFormat
+	case "yml":
+		return "yaml"
+	case "text":
+		return "txt"
+	}
+	return format
+}
+
+// exportContentType 导出内容的Content-Type
+func exportContentType(format string) string {
+	switch format {
+	case "csv":
+		return "text/csv; charset=utf-8"
+	case "json":
+		return "application/json; charset=utf-8"
+	case "yaml":
+		return "application/yaml; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"Stone/pkg/certs"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+// HandleCertificates 处理证书的查询、上传和删除，列表和详情不包含私钥
+func HandleCertificates(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		name := c.Param("name")
+		if name == "" {
+			// 获取所有证书
+			c.JSON(http.StatusOK, gin.H{"certificates": certs.List(), "expiring": certs.Expiring()})
+		} else {
+			// 获取特定名称的证书
+			info, found := certs.Get(name)
+			if !found {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+				return
+			}
+			c.JSON(http.StatusOK, info)
+		}
+	case http.MethodPost:
+		var cert certs.Certificate
+		if err := c.ShouldBindJSON(&cert); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if cert.CertPEM == "" || cert.KeyPEM == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certificate and key cannot be empty"})
+			return
+		}
+		// 创建者和创建时间由服务端记录，忽略请求体中的值
+		cert.CreatedBy = currentAccount(c)
+		cert.CreatedAt = time.Time{}
+		info, err := certs.Save(c.Request.Context(), cert)
+		if err != nil {
+			writeCertificateError(c, err, "Failed to save certificate")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Certificate saved", "certificate": info})
+	case http.MethodDelete:
+		name := c.Param("name")
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certificate name cannot be empty"})
+			return
+		}
+		if err := certs.Delete(c.Request.Context(), name); err != nil {
+			writeCertificateError(c, err, "Failed to delete certificate")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Certificate deleted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
+
+// GenerateCertificate 为内部主机生成自签名或由本地CA签发的证书
+func GenerateCertificate(c *gin.Context) {
+	var req certs.GenerateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	info, err := certs.Generate(c.Request.Context(), req, currentAccount(c))
+	if err != nil {
+		writeCertificateError(c, err, "Failed to generate certificate")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "Certificate generated", "certificate": info})
+}
+
+// GetLocalCA 下载本地CA证书，客户端或上游TLS设置信任该CA后即可校验本地CA签发的证书
+func GetLocalCA(c *gin.Context) {
+	caPEM, err := certs.LocalCACertificate(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load local CA"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=stone-ca.crt")
+	c.Data(http.StatusOK, "application/x-pem-file", caPEM)
+}
+
+// writeCertificateError 将证书操作的错误映射为HTTP状态码
+func writeCertificateError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, certs.ErrCertificateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, certs.ErrFileCertificate):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, certs.ErrInvalidCertificate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
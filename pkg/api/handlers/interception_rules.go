@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"Stone/pkg/rules"
+	"bytes"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"strconv"
@@ -31,6 +33,7 @@ func HandleInterceptionRules(c *gin.Context) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
 				return
 			}
+			c.Header("ETag", rules.ETag(rule))
 			c.JSON(http.StatusOK, rule)
 		}
 	case http.MethodPost:
@@ -40,30 +43,74 @@ func HandleInterceptionRules(c *gin.Context) {
 			return
 		}
 		// 检查规则的必要字段是否为空
-		if newRule.Name == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule name cannot be empty"})
-			return
-		}
-		if newRule.Regex == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule regex cannot be empty"})
+		if msg := checkRequiredFields(newRule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 			return
 		}
-		if newRule.Method == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule method cannot be empty"})
+		// 正则表达式在添加时编译，无效的规则直接拒绝
+		if err := rules.ValidatePattern(newRule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if err := rules.AddInterceptionRule(newRule); err != nil {
+		if err := rules.AddInterceptionRule(newRule, currentAccount(c)); err != nil {
+			if errors.Is(err, rules.ErrRulesReadOnly) || errors.Is(err, rules.ErrRuleExists) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, rules.ErrInvalidRule) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add interception rule"})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"status": "Interception rule added"})
+	case http.MethodPut, http.MethodPatch:
+		// PUT 提交完整的规则，PATCH 只提交需要修改的字段，例如 {"disabled": true}
+		name := c.Param("name")
+		current, found := rules.GetInterceptionRule(name)
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+			return
+		}
+		var rule rules.Pattern
+		ifMatch := c.GetHeader("If-Match")
+		if c.Request.Method == http.MethodPatch {
+			// 未带 If-Match 时以读取到的版本为准，读取后被他人修改则拒绝，保证合并结果不覆盖他人的修改
+			rule = current
+			if ifMatch == "" {
+				ifMatch = rules.ETag(current)
+			}
+		}
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if rule.Name == "" {
+			rule.Name = name
+		}
+		if msg := checkRequiredFields(rule); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updated, err := rules.UpdateInterceptionRule(name, rule, ifMatch, currentAccount(c))
+		if err != nil {
+			writeUpdateError(c, err, "Failed to update interception rule")
+			return
+		}
+		c.Header("ETag", rules.ETag(updated))
+		c.JSON(http.StatusOK, gin.H{"status": "Interception rule updated", "rule": updated})
 	case http.MethodDelete:
 		name := c.Param("name")
 		if name == "" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule name cannot be empty"})
 			return
 		}
-		if err := rules.DeleteInterceptionRule(name); err != nil {
+		if err := rules.DeleteInterceptionRule(name, currentAccount(c)); err != nil {
+			if errors.Is(err, rules.ErrRulesReadOnly) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete interception rule"})
 			return
 		}
@@ -72,3 +119,82 @@ func HandleInterceptionRules(c *gin.Context) {
 		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
 	}
 }
+
+// checkRequiredFields 检查规则的必要字段，返回错误信息，字段齐全时返回空字符串
+func checkRequiredFields(rule rules.Pattern) string {
+	if rule.Name == "" {
+		return "Rule name cannot be empty"
+	}
+	// sqli、xss 运算符使用内置检测器，不需要正则表达式
+	if rule.Regex == "" && (rule.Operator == "" || rule.Operator == rules.OperatorRegex) {
+		return "Rule regex cannot be empty"
+	}
+	// 方法为空时规则对全部请求方法生效
+	return ""
+}
+
+// writeUpdateError 将修改规则时的错误转换为对应的状态码
+func writeUpdateError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, rules.ErrRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, rules.ErrRuleExists), errors.Is(err, rules.ErrRulesReadOnly):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, rules.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+	case errors.Is(err, rules.ErrInvalidRule), errors.Is(err, rules.ErrInvalidIPEntry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// ExportInterceptionRules 导出全部拦截规则，格式为 yaml 或 json
+func ExportInterceptionRules(c *gin.Context) {
+	format := normalizeFormat(c.Query("format"), rules.FormatYAML)
+
+	var buf bytes.Buffer
+	if err := rules.FormatInterceptionRules(format, rules.GetInterceptionRules().Rules, &buf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=interception-rules."+format)
+	c.Data(http.StatusOK, exportContentType(format), buf.Bytes())
+}
+
+// ImportInterceptionRules 用导入的规则整体替换拦截规则，dry_run=true 时只返回差异
+// 导入空规则集会清空全部规则，需要同时指定 allow_empty=true
+func ImportInterceptionRules(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	allowEmpty := c.Query("allow_empty") == "true"
+
+	reader, format, err := readImport(c, rules.FormatYAML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	patterns, err := rules.ParseInterceptionRules(format, reader)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := rules.ReplaceInterceptionRules(patterns, currentAccount(c), dryRun, allowEmpty)
+	if err != nil {
+		if errors.Is(err, rules.ErrRulesReadOnly) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, rules.ErrInvalidRule) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import interception rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run": dryRun,
+		"total":   len(patterns),
+		"diff":    diff,
+	})
+}
@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"Stone/pkg/rules"
+	"bytes"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -22,6 +24,7 @@ func HandleIPControlRules(c *gin.Context) {
 				c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
 				return
 			}
+			c.Header("ETag", rules.ETag(rule))
 			c.JSON(http.StatusOK, rule)
 		}
 	case http.MethodPost:
@@ -35,11 +38,57 @@ func HandleIPControlRules(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "IP address cannot be empty"})
 			return
 		}
+		// 支持单个IP、CIDR（如 10.0.0.0/8、2001:db8::/32）和地址段（如 1.2.3.4-1.2.3.99）
+		if err := rules.ValidateIPEntry(newRule.IP); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 创建者取自JWT中的账号，忽略请求体中的值
+		newRule.CreatedBy = currentAccount(c)
 		if err := rules.AddIPRule(newRule); err != nil {
+			if errors.Is(err, rules.ErrRulesReadOnly) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, rules.ErrInvalidIPEntry) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add IP rule"})
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"status": "IP rule added"})
+	case http.MethodPut, http.MethodPatch:
+		// 只能修改名单中已有的条目，不能修改被某个条目覆盖的单个地址
+		entry, _, err := rules.ParseIPEntry(c.Param("ip"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		current, found := rules.GetIPRule(entry)
+		if !found || current.IP != entry {
+			c.JSON(http.StatusNotFound, gin.H{"error": "IP not found"})
+			return
+		}
+		var rule rules.IPControlRule
+		ifMatch := c.GetHeader("If-Match")
+		if c.Request.Method == http.MethodPatch {
+			rule = current
+			if ifMatch == "" {
+				ifMatch = rules.ETag(current)
+			}
+		}
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		updated, err := rules.UpdateIPRule(entry, rule, ifMatch, currentAccount(c))
+		if err != nil {
+			writeUpdateError(c, err, "Failed to update IP rule")
+			return
+		}
+		c.Header("ETag", rules.ETag(updated))
+		c.JSON(http.StatusOK, gin.H{"status": "IP rule updated", "rule": updated})
 	case http.MethodDelete:
 		ip := c.Param("ip")
 		// 检查 IP 是否为空
@@ -47,7 +96,11 @@ func HandleIPControlRules(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "IP address cannot be empty"})
 			return
 		}
-		if err := rules.DeleteIPRule(ip); err != nil {
+		if err := rules.DeleteIPRule(ip, currentAccount(c)); err != nil {
+			if errors.Is(err, rules.ErrRulesReadOnly) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete IP rule"})
 			return
 		}
@@ -56,3 +109,62 @@ func HandleIPControlRules(c *gin.Context) {
 		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
 	}
 }
+
+// ExportIPControlRules 导出白名单或黑名单，格式为 txt 或 csv
+func ExportIPControlRules(c *gin.Context) {
+	listType := c.DefaultQuery("type", "blacklist")
+	format := normalizeFormat(c.Query("format"), rules.FormatText)
+
+	current := rules.GetIPControlRules()
+	entries := current.Blacklist
+	if listType == "whitelist" {
+		entries = current.Whitelist
+	} else if listType != "blacklist" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid list type"})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := rules.FormatIPList(format, entries, &buf); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+listType+"."+format)
+	c.Data(http.StatusOK, exportContentType(format), buf.Bytes())
+}
+
+// ImportIPControlRules 用导入的条目整体替换白名单或黑名单，dry_run=true 时只返回差异
+func ImportIPControlRules(c *gin.Context) {
+	listType := c.DefaultQuery("type", "blacklist")
+	dryRun := c.Query("dry_run") == "true"
+
+	reader, format, err := readImport(c, rules.FormatText)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := rules.ParseIPList(format, reader)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff, err := rules.ReplaceIPList(listType, entries, currentAccount(c), dryRun)
+	if err != nil {
+		if errors.Is(err, rules.ErrRulesReadOnly) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, rules.ErrInvalidIPEntry) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import IP rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"dry_run": dryRun,
+		"total":   len(entries),
+		"diff":    diff,
+	})
+}
@@ -16,6 +16,7 @@ func GetLogs(c *gin.Context) {
 	endDateTimeStr := c.Query("endDateTime")
 	ip := c.Query("ip")
 	status := c.Query("status")
+	rule := c.Query("rule")
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
 
@@ -37,14 +38,14 @@ func GetLogs(c *gin.Context) {
 		}
 	}
 
-	// 验证状态参数
-	if status != "" && status != "blocked" && status != "passed" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的状态参数，必须为 'blocked' 或 'passed'"})
+	// 验证状态参数，detected 表示命中规则但仅记录的请求
+	if status != "" && status != "blocked" && status != "passed" && status != "detected" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的状态参数，必须为 'blocked'、'passed' 或 'detected'"})
 		return
 	}
 
 	// 获取日志
-	logs, totalCount, err := logging.FetchLogsFromMongoWithFilters(context.Background(), page, pageSize, startDateTime, endDateTime, ip, status)
+	logs, totalCount, err := logging.FetchLogsFromMongoWithFilters(context.Background(), page, pageSize, startDateTime, endDateTime, ip, status, rule)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "无法获取日志"})
 		return
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"Stone/pkg/ratelimit"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// HandleRateLimitRules 处理限流规则的操作
+func HandleRateLimitRules(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		name := c.Param("name")
+		if name == "" {
+			// 获取所有限流规则
+			c.JSON(http.StatusOK, ratelimit.GetRules())
+		} else {
+			// 获取特定名称的规则
+			rule, found := ratelimit.GetRule(name)
+			if !found {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+				return
+			}
+			c.JSON(http.StatusOK, rule)
+		}
+	case http.MethodPost:
+		var newRule ratelimit.Rule
+		if err := c.ShouldBindJSON(&newRule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if newRule.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule name cannot be empty"})
+			return
+		}
+		if err := ratelimit.AddRule(newRule); err != nil {
+			if errors.Is(err, ratelimit.ErrInvalidRule) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add rate limit rule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Rate limit rule added"})
+	case http.MethodDelete:
+		name := c.Param("name")
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rule name cannot be empty"})
+			return
+		}
+		if err := ratelimit.DeleteRule(name); err != nil {
+			if errors.Is(err, ratelimit.ErrRuleNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rate limit rule"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Rate limit rule deleted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"Stone/pkg/routing"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// HandleRoutes 处理站点路由的操作
+func HandleRoutes(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet:
+		name := c.Param("name")
+		if name == "" {
+			// 获取所有站点路由
+			c.JSON(http.StatusOK, routing.GetSites())
+		} else {
+			// 获取特定名称的路由
+			route, found := routing.GetRoute(name)
+			if !found {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+				return
+			}
+			c.JSON(http.StatusOK, route)
+		}
+	case http.MethodPost:
+		var newRoute routing.Route
+		if err := c.ShouldBindJSON(&newRoute); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if newRoute.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Route name cannot be empty"})
+			return
+		}
+		if err := routing.AddRoute(newRoute); err != nil {
+			if errors.Is(err, routing.ErrInvalidRoute) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add route"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Route added"})
+	case http.MethodDelete:
+		name := c.Param("name")
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Route name cannot be empty"})
+			return
+		}
+		if err := routing.DeleteRoute(name); err != nil {
+			if errors.Is(err, routing.ErrRouteNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete route"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Route deleted"})
+	default:
+		c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "Method not allowed"})
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"Stone/pkg/rules"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ruleTestRequest 规则测试的请求体
+type ruleTestRequest struct {
+	Request  string          `json:"request"`  // 原始HTTP请求，可以包含多个请求
+	Requests []string        `json:"requests"` // 多个原始HTTP请求
+	Rule     *rules.Pattern  `json:"rule"`     // 候选规则，与当前规则一起检查，同名规则被替换
+	Rules    []rules.Pattern `json:"rules"`    // 候选规则集，只使用这些规则检查
+}
+
+// TestRules 在沙盒中用当前规则或候选规则检查原始HTTP请求，返回全部命中的规则、位置和片段
+// 请求体为JSON时按 ruleTestRequest 解析，否则整个请求体作为原始HTTP请求用当前规则检查
+func TestRules(c *gin.Context) {
+	var body ruleTestRequest
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxImportSize))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body.Request = string(data)
+	}
+
+	raw := body.Requests
+	if body.Request != "" {
+		raw = append([]string{body.Request}, raw...)
+	}
+	var requests []*http.Request
+	for _, r := range raw {
+		parsed, err := rules.ParseRawRequests(strings.NewReader(r))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		requests = append(requests, parsed...)
+	}
+	if len(requests) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No requests to test"})
+		return
+	}
+
+	ruleset, err := rules.CandidateRuleset(body.Rule, body.Rules)
+	if err != nil {
+		if errors.Is(err, rules.ErrInvalidRule) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compile rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": rules.TestRequests(requests, ruleset)})
+}
+
+// backtestRequest 回放的请求体
+type backtestRequest struct {
+	Start time.Time       `json:"start"` // RFC3339格式
+	End   time.Time       `json:"end"`
+	Rule  *rules.Pattern  `json:"rule"`  // 候选规则，与当前规则一起回放，同名规则被替换
+	Rules []rules.Pattern `json:"rules"` // 候选规则集，只使用这些规则回放
+	Limit int             `json:"limit"` // 最多回放的日志条数
+	Top   int             `json:"top"`   // 排行榜的条数
+}
+
+// BacktestRules 用候选规则回放一段时间内的流量日志，报告新拦截和新放行的请求
+func BacktestRules(c *gin.Context) {
+	var body backtestRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ruleset, err := rules.CandidateRuleset(body.Rule, body.Rules)
+	if err != nil {
+		if errors.Is(err, rules.ErrInvalidRule) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compile rules"})
+		return
+	}
+
+	report, err := rules.Backtest(c.Request.Context(), ruleset, rules.BacktestOptions{
+		Start: body.Start,
+		End:   body.End,
+		Limit: body.Limit,
+		Top:   body.Top,
+	})
+	if err != nil {
+		if errors.Is(err, rules.ErrInvalidBacktest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay traffic logs"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
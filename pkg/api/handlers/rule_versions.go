@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"Stone/pkg/rules"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// HandleRuleVersions 查询规则版本历史，不带版本号时分页列出全部版本
+func HandleRuleVersions(c *gin.Context) {
+	if c.Param("version") == "" {
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "20"))
+
+		versions, totalCount, err := rules.ListVersions(c.Request.Context(), page, pageSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rule versions"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"versions":   versions,
+			"totalCount": totalCount,
+			"page":       page,
+			"pageSize":   pageSize,
+		})
+		return
+	}
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+	result, err := rules.GetVersion(c.Request.Context(), version)
+	if err != nil {
+		if errors.Is(err, rules.ErrVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve rule version"})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// DiffRuleVersions 比较两个版本，返回从 from 变为 to 的差异，未指定 to 时与当前规则比较
+func DiffRuleVersions(c *gin.Context) {
+	from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from version"})
+		return
+	}
+
+	var diff rules.VersionDiff
+	if c.Query("to") == "" {
+		diff, err = rules.DiffWithCurrent(c.Request.Context(), from)
+	} else {
+		to, parseErr := strconv.ParseInt(c.Query("to"), 10, 64)
+		if parseErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to version"})
+			return
+		}
+		diff, err = rules.DiffVersions(c.Request.Context(), from, to)
+	}
+	if err != nil {
+		if errors.Is(err, rules.ErrVersionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to diff rule versions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"diff": diff})
+}
+
+// RollbackRuleVersion 将规则恢复为指定版本，立即在本实例生效，其他实例通过热加载同步
+func RollbackRuleVersion(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	result, err := rules.Rollback(c.Request.Context(), version, currentAccount(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, rules.ErrVersionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		case errors.Is(err, rules.ErrRulesReadOnly):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, rules.ErrInvalidRule):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back rules"})
+		}
+		return
+	}
+	if result == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "Rules already match this version"})
+		return
+	}
+	result.Snapshot = nil
+	c.JSON(http.StatusOK, gin.H{"status": "Rules rolled back", "version": result})
+}
+
+// currentAccount 返回JWT中的账号，用于记录规则的创建者和修改者
+func currentAccount(c *gin.Context) string {
+	account, _ := c.Get("account")
+	name, _ := account.(string)
+	return name
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"Stone/pkg/certs"
 	"Stone/pkg/monitoring"
+	"Stone/pkg/reload"
+	"Stone/pkg/routing"
+	"Stone/pkg/rules"
 	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/shirou/gopsutil/cpu"
@@ -77,6 +81,10 @@ func GetStatus(c *gin.Context) {
 		"open_file_desc":    numFDs,
 		"threads":           numThreads,
 		"processes":         numProcesses,
+		"ruleset":           rules.CurrentVersion(), // 规则版本和摘要，用于确认多个实例的规则是否一致
+		"sync":              reload.Modes(),         // 规则和配置变化的监视方式
+		"upstreams":         routing.Pools(),        // 站点路由上游池的健康状态
+		"certificates":      certs.Expiring(),       // 已过期或即将过期的证书
 	})
 }
 
@@ -88,10 +96,17 @@ func SetMetricsCollection(collection *mongo.Collection) {
 }
 
 type DailyMetrics struct {
-	Date                    time.Time `bson:"date"`
-	WebsiteRequestsTotal    int       `bson:"websiteRequestsTotal"`
-	BlockedByBlacklistTotal int       `bson:"blockedByBlacklistTotal"`
-	BlockedByRulesTotal     int       `bson:"blockedByRulesTotal"`
+	Date                    time.Time      `bson:"date"`
+	WebsiteRequestsTotal    int            `bson:"websiteRequestsTotal"`
+	BlockedByBlacklistTotal int            `bson:"blockedByBlacklistTotal"`
+	BlockedByRulesTotal     int            `bson:"blockedByRulesTotal"`
+	WouldBlockByRulesTotal  int            `bson:"wouldBlockByRulesTotal"`
+	BlockedByBodySizeTotal  int            `bson:"blockedByBodySizeTotal"`
+	RateLimitedTotal        int            `bson:"rateLimitedTotal"`
+	BlockedByBanTotal       int            `bson:"blockedByBanTotal"`
+	NoUpstreamTotal         int            `bson:"noUpstreamTotal"`
+	RuleHits                map[string]int `bson:"ruleHits"`
+	RuleDetections          map[string]int `bson:"ruleDetections"`
 }
 
 func GetFirewallMetrics(c *gin.Context) {
@@ -177,17 +192,31 @@ func GetFirewallMetrics(c *gin.Context) {
 
 		if m, exists := metricsMap[dateStr]; exists {
 			response[i] = gin.H{
-				"date":               dateStr,
-				"success_requests":   m.WebsiteRequestsTotal,
-				"blacklist_requests": m.BlockedByBlacklistTotal,
-				"rules_requests":     m.BlockedByRulesTotal,
+				"date":                  dateStr,
+				"success_requests":      m.WebsiteRequestsTotal,
+				"blacklist_requests":    m.BlockedByBlacklistTotal,
+				"rules_requests":        m.BlockedByRulesTotal,
+				"would_block_requests":  m.WouldBlockByRulesTotal,
+				"body_size_requests":    m.BlockedByBodySizeTotal,
+				"rate_limited_requests": m.RateLimitedTotal,
+				"ban_requests":          m.BlockedByBanTotal,
+				"no_upstream_requests":  m.NoUpstreamTotal,
+				"rule_hits":             m.RuleHits,
+				"rule_detections":       m.RuleDetections,
 			}
 		} else {
 			response[i] = gin.H{
-				"date":               dateStr,
-				"success_requests":   0,
-				"blacklist_requests": 0,
-				"rules_requests":     0,
+				"date":                  dateStr,
+				"success_requests":      0,
+				"blacklist_requests":    0,
+				"rules_requests":        0,
+				"would_block_requests":  0,
+				"body_size_requests":    0,
+				"rate_limited_requests": 0,
+				"ban_requests":          0,
+				"no_upstream_requests":  0,
+				"rule_hits":             map[string]int{},
+				"rule_detections":       map[string]int{},
 			}
 		}
 	}
@@ -17,13 +17,16 @@ import (
 // SetupRouter 设置API路由
 func SetupRouter(configCollection *mongo.Collection, userCollection *mongo.Collection) *gin.Engine {
 	router := gin.Default()
+	// 允许路径参数中出现编码后的斜杠，例如 /ip-control-rules/10.0.0.0%2F8
+	router.UseRawPath = true
+	router.UnescapePathValues = true
 
 	// 配置CORS中间件
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:8080"}, // 允许的前端域名
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true, // 允许跨域请求携带认证信息
 		MaxAge:           12 * time.Hour,
 	}))
@@ -63,13 +66,56 @@ func SetupRouter(configCollection *mongo.Collection, userCollection *mongo.Colle
 		authenticated.GET("/ip-control-rules", handlers.HandleIPControlRules)
 		authenticated.GET("/ip-control-rules/:ip", handlers.HandleIPControlRules)
 		authenticated.POST("/ip-control-rules", handlers.HandleIPControlRules)
+		authenticated.PUT("/ip-control-rules/:ip", handlers.HandleIPControlRules)
+		authenticated.PATCH("/ip-control-rules/:ip", handlers.HandleIPControlRules)
 		authenticated.DELETE("/ip-control-rules/:ip", handlers.HandleIPControlRules)
+		authenticated.GET("/ip-control-rules/export", handlers.ExportIPControlRules)
+		authenticated.POST("/ip-control-rules/import", handlers.ImportIPControlRules)
 
 		// 拦截规则管理API
 		authenticated.GET("/interception-rules", handlers.HandleInterceptionRules)
 		authenticated.GET("/interception-rules/:name", handlers.HandleInterceptionRules)
 		authenticated.POST("/interception-rules", handlers.HandleInterceptionRules)
+		authenticated.PUT("/interception-rules/:name", handlers.HandleInterceptionRules)
+		authenticated.PATCH("/interception-rules/:name", handlers.HandleInterceptionRules)
 		authenticated.DELETE("/interception-rules/:name", handlers.HandleInterceptionRules)
+		authenticated.GET("/interception-rules/export", handlers.ExportInterceptionRules)
+		authenticated.POST("/interception-rules/import", handlers.ImportInterceptionRules)
+
+		// 规则测试沙盒和流量回放，不影响流量和指标
+		authenticated.POST("/rules/test", handlers.TestRules)
+		authenticated.POST("/rules/backtest", handlers.BacktestRules)
+
+		// 规则版本历史API
+		authenticated.GET("/rule-versions", handlers.HandleRuleVersions)
+		authenticated.GET("/rule-versions/:version", handlers.HandleRuleVersions)
+		authenticated.GET("/rule-versions/diff", handlers.DiffRuleVersions)
+		authenticated.POST("/rule-versions/:version/rollback", handlers.RollbackRuleVersion)
+
+		// 限流规则管理API
+		authenticated.GET("/rate-limit-rules", handlers.HandleRateLimitRules)
+		authenticated.GET("/rate-limit-rules/:name", handlers.HandleRateLimitRules)
+		authenticated.POST("/rate-limit-rules", handlers.HandleRateLimitRules)
+		authenticated.DELETE("/rate-limit-rules/:name", handlers.HandleRateLimitRules)
+
+		// 站点路由管理API，按Host和路径前缀选择上游、规则和IP策略
+		authenticated.GET("/routes", handlers.HandleRoutes)
+		authenticated.GET("/routes/:name", handlers.HandleRoutes)
+		authenticated.POST("/routes", handlers.HandleRoutes)
+		authenticated.DELETE("/routes/:name", handlers.HandleRoutes)
+
+		// 证书管理API，/certificates/ca 下载本地CA证书
+		authenticated.GET("/certificates", handlers.HandleCertificates)
+		authenticated.GET("/certificates/ca", handlers.GetLocalCA)
+		authenticated.GET("/certificates/:name", handlers.HandleCertificates)
+		authenticated.POST("/certificates", handlers.HandleCertificates)
+		authenticated.POST("/certificates/generate", handlers.GenerateCertificate)
+		authenticated.DELETE("/certificates/:name", handlers.HandleCertificates)
+
+		// 自动封禁API
+		authenticated.GET("/bans", handlers.HandleBans)
+		authenticated.GET("/bans/:ip", handlers.HandleBans)
+		authenticated.DELETE("/bans/:ip", handlers.HandleBans)
 
 		// 日志查看API
 		authenticated.GET("/logs", handlers.GetLogs)
@@ -0,0 +1,335 @@
+// pkg/bans/bans.go
+
+package bans
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrBanNotFound IP当前未被封禁
+var ErrBanNotFound = errors.New("IP未被封禁")
+
+// Policy 自动封禁策略：Window 时间内被拦截 Threshold 次的IP封禁 Duration，
+// 在 History 时间内再次被封禁时封禁时长逐次翻倍，最长不超过 MaxDuration
+type Policy struct {
+	Threshold   int
+	Window      time.Duration
+	Duration    time.Duration
+	MaxDuration time.Duration
+	History     time.Duration
+}
+
+// DefaultPolicy 未配置时使用的封禁时长，Threshold 为0表示不自动封禁
+var DefaultPolicy = Policy{
+	Window:      time.Minute,
+	Duration:    10 * time.Minute,
+	MaxDuration: 24 * time.Hour,
+	History:     7 * 24 * time.Hour,
+}
+
+// Ban IP的封禁记录，每个IP一条，封禁解除后保留到 PurgeAt 用于计算累犯次数
+type Ban struct {
+	IP        string    `bson:"ip" json:"ip"`
+	Reason    string    `bson:"reason" json:"reason"`     // 最后一次触发封禁的原因
+	Offenses  int       `bson:"offenses" json:"offenses"` // 累计封禁次数
+	BannedAt  time.Time `bson:"banned_at" json:"banned_at"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	PurgeAt   time.Time `bson:"purge_at" json:"-"` // 记录的删除时间，由MongoDB的TTL索引自动删除
+}
+
+// Active 封禁是否仍然有效
+func (b Ban) Active(now time.Time) bool {
+	return now.Before(b.ExpiresAt)
+}
+
+var (
+	policy          = DefaultPolicy
+	mongoCollection *mongo.Collection
+	redisClient     *redis.Client
+
+	mutex  sync.RWMutex
+	active = make(map[string]Ban) // 当前有效的封禁
+	hits   = make(map[string]*hitCounter)
+)
+
+// hitCounter 未使用Redis时的内存拦截计数
+type hitCounter struct {
+	count int
+	start time.Time
+}
+
+// SetMongoCollection 设置MongoDB集合
+func SetMongoCollection(collection *mongo.Collection) {
+	mongoCollection = collection
+}
+
+// SetRedisClient 设置Redis客户端，多个实例通过Redis共享拦截计数，未设置时使用内存计数
+func SetRedisClient(client *redis.Client) {
+	redisClient = client
+}
+
+// SetPolicy 设置自动封禁策略，未设置（0）的时长使用默认值
+func SetPolicy(p Policy) {
+	if p.Window <= 0 {
+		p.Window = DefaultPolicy.Window
+	}
+	if p.Duration <= 0 {
+		p.Duration = DefaultPolicy.Duration
+	}
+	if p.MaxDuration < p.Duration {
+		p.MaxDuration = max(DefaultPolicy.MaxDuration, p.Duration)
+	}
+	if p.History <= 0 {
+		p.History = DefaultPolicy.History
+	}
+
+	mutex.Lock()
+	policy = p
+	mutex.Unlock()
+}
+
+// Load 从MongoDB加载当前有效的封禁，并确保封禁记录的TTL索引存在
+func Load(ctx context.Context) error {
+	_, err := mongoCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "ip", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return fmt.Errorf("创建封禁记录索引失败: %w", err)
+	}
+	return reload(ctx)
+}
+
+// reload 用MongoDB中的有效封禁替换内存缓存，其他实例产生的封禁和提前解除的封禁由此同步
+func reload(ctx context.Context) error {
+	cursor, err := mongoCollection.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		return fmt.Errorf("从MongoDB读取封禁记录失败: %w", err)
+	}
+	var list []Ban
+	if err := cursor.All(ctx, &list); err != nil {
+		return fmt.Errorf("解析封禁记录失败: %w", err)
+	}
+
+	current := make(map[string]Ban, len(list))
+	for _, ban := range list {
+		current[ban.IP] = ban
+	}
+
+	mutex.Lock()
+	active = current
+	mutex.Unlock()
+	return nil
+}
+
+// StartSweeper 定期同步封禁记录并清理过期的封禁和内存计数，ctx 取消时停止
+func StartSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := reload(ctx); err != nil {
+					logging.LogError(err)
+				}
+				sweepHits(time.Now())
+			}
+		}
+	}()
+}
+
+// sweepHits 删除已经超出统计窗口的内存计数
+func sweepHits(now time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for ip, counter := range hits {
+		if now.Sub(counter.start) > policy.Window {
+			delete(hits, ip)
+		}
+	}
+}
+
+// IsBanned 检查IP是否处于封禁中
+func IsBanned(ip string) (Ban, bool) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	ban, found := active[ip]
+	if !found || !ban.Active(time.Now()) {
+		return Ban{}, false
+	}
+	return ban, true
+}
+
+// RecordBlock 记录一次对该IP的拦截，达到阈值时封禁该IP，返回新产生的封禁
+func RecordBlock(ip, reason string) (*Ban, error) {
+	mutex.RLock()
+	p := policy
+	mutex.RUnlock()
+
+	if p.Threshold <= 0 {
+		return nil, nil
+	}
+	if _, banned := IsBanned(ip); banned {
+		return nil, nil
+	}
+
+	count, err := incrementHits(ip, p.Window)
+	if err != nil {
+		return nil, fmt.Errorf("记录IP %s 的拦截次数失败: %w", ip, err)
+	}
+	if count < p.Threshold {
+		return nil, nil
+	}
+
+	resetHits(ip)
+	return ban(ip, reason, p)
+}
+
+// incrementHits 增加IP在统计窗口内的拦截次数并返回当前次数
+func incrementHits(ip string, window time.Duration) (int, error) {
+	if redisClient != nil {
+		count, err := hitScript.Run(context.Background(), redisClient, []string{hitKey(ip)}, window.Milliseconds()).Int()
+		return count, err
+	}
+
+	now := time.Now()
+	mutex.Lock()
+	defer mutex.Unlock()
+	counter, found := hits[ip]
+	if !found || now.Sub(counter.start) > window {
+		counter = &hitCounter{start: now}
+		hits[ip] = counter
+	}
+	counter.count++
+	return counter.count, nil
+}
+
+// hitScript 原子地增加计数，第一次计数时设置过期时间
+var hitScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func hitKey(ip string) string {
+	return "bans:hits:" + ip
+}
+
+func resetHits(ip string) {
+	if redisClient != nil {
+		redisClient.Del(context.Background(), hitKey(ip))
+		return
+	}
+	mutex.Lock()
+	delete(hits, ip)
+	mutex.Unlock()
+}
+
+// ban 封禁IP，累犯的封禁时长按次数翻倍
+func ban(ip, reason string, p Policy) (*Ban, error) {
+	ctx := context.Background()
+	now := time.Now()
+
+	offenses := 1
+	var previous Ban
+	err := mongoCollection.FindOne(ctx, bson.M{"ip": ip}).Decode(&previous)
+	switch {
+	case err == nil && now.Before(previous.PurgeAt):
+		offenses = previous.Offenses + 1
+	case err != nil && !errors.Is(err, mongo.ErrNoDocuments):
+		return nil, fmt.Errorf("读取IP %s 的封禁记录失败: %w", ip, err)
+	}
+
+	duration := p.Duration
+	for i := 1; i < offenses && duration < p.MaxDuration; i++ {
+		duration *= 2
+	}
+	duration = min(duration, p.MaxDuration)
+
+	record := Ban{
+		IP:        ip,
+		Reason:    reason,
+		Offenses:  offenses,
+		BannedAt:  now,
+		ExpiresAt: now.Add(duration),
+		PurgeAt:   now.Add(duration + p.History),
+	}
+	_, err = mongoCollection.ReplaceOne(ctx, bson.M{"ip": ip}, record, options.Replace().SetUpsert(true))
+	if err != nil {
+		return nil, fmt.Errorf("保存IP %s 的封禁记录失败: %w", ip, err)
+	}
+
+	mutex.Lock()
+	active[ip] = record
+	mutex.Unlock()
+
+	logging.LogInfo(fmt.Sprintf("IP %s 被自动封禁 %s（第 %d 次，原因: %s）", ip, duration, offenses, reason))
+	return &record, nil
+}
+
+// Lift 提前解除IP的封禁，保留累犯次数
+func Lift(ctx context.Context, ip string) error {
+	now := time.Now()
+	result, err := mongoCollection.UpdateOne(ctx,
+		bson.M{"ip": ip, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"expires_at": now}},
+	)
+	if err != nil {
+		return fmt.Errorf("解除IP %s 的封禁失败: %w", ip, err)
+	}
+
+	mutex.Lock()
+	delete(active, ip)
+	mutex.Unlock()
+
+	if result.MatchedCount == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+// Get 获取IP的封禁记录，包括已解除但尚未删除的记录
+func Get(ctx context.Context, ip string) (Ban, error) {
+	var record Ban
+	err := mongoCollection.FindOne(ctx, bson.M{"ip": ip}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Ban{}, ErrBanNotFound
+	}
+	if err != nil {
+		return Ban{}, fmt.Errorf("读取IP %s 的封禁记录失败: %w", ip, err)
+	}
+	return record, nil
+}
+
+// List 按封禁时间倒序列出封禁记录，all 为false时只返回有效的封禁
+func List(ctx context.Context, all bool) ([]Ban, error) {
+	filter := bson.M{}
+	if !all {
+		filter["expires_at"] = bson.M{"$gt": time.Now()}
+	}
+	cursor, err := mongoCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "banned_at", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("从MongoDB读取封禁记录失败: %w", err)
+	}
+	list := []Ban{}
+	if err := cursor.All(ctx, &list); err != nil {
+		return nil, fmt.Errorf("解析封禁记录失败: %w", err)
+	}
+	return list, nil
+}
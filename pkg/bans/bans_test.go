@@ -0,0 +1,149 @@
+package bans
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// resetState 清空封禁缓存和计数，恢复默认策略
+func resetState() {
+	mutex.Lock()
+	policy = DefaultPolicy
+	active = make(map[string]Ban)
+	hits = make(map[string]*hitCounter)
+	mutex.Unlock()
+	mongoCollection = nil
+	redisClient = nil
+}
+
+func TestSetPolicy(t *testing.T) {
+	defer resetState()
+	tests := []struct {
+		name string
+		in   Policy
+		want Policy
+	}{
+		{name: "未设置时使用默认值", in: Policy{Threshold: 3}, want: Policy{Threshold: 3, Window: time.Minute, Duration: 10 * time.Minute, MaxDuration: 24 * time.Hour, History: 7 * 24 * time.Hour}},
+		{name: "最长时长不小于初始时长", in: Policy{Threshold: 1, Duration: 48 * time.Hour, MaxDuration: time.Hour}, want: Policy{Threshold: 1, Window: time.Minute, Duration: 48 * time.Hour, MaxDuration: 48 * time.Hour, History: 7 * 24 * time.Hour}},
+		{name: "保留已设置的值", in: Policy{Threshold: 5, Window: time.Second, Duration: time.Minute, MaxDuration: time.Hour, History: time.Hour}, want: Policy{Threshold: 5, Window: time.Second, Duration: time.Minute, MaxDuration: time.Hour, History: time.Hour}},
+	}
+	for _, tt := range tests {
+		SetPolicy(tt.in)
+		if policy != tt.want {
+			t.Errorf("%s: 策略 %+v，期望 %+v", tt.name, policy, tt.want)
+		}
+	}
+}
+
+func TestIncrementHits(t *testing.T) {
+	defer resetState()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	for _, c := range []*redis.Client{nil, client} {
+		redisClient = c
+		for i := 1; i <= 3; i++ {
+			count, err := incrementHits("10.0.0.1", time.Minute)
+			if err != nil || count != i {
+				t.Errorf("redis=%v: 第 %d 次计数 %d(%v)", c != nil, i, count, err)
+			}
+		}
+		resetHits("10.0.0.1")
+		if count, _ := incrementHits("10.0.0.1", time.Minute); count != 1 {
+			t.Errorf("redis=%v: 重置后计数应从1开始，实际 %d", c != nil, count)
+		}
+	}
+
+	// 超出统计窗口后重新计数
+	server.FastForward(2 * time.Minute)
+	if count, _ := incrementHits("10.0.0.1", time.Minute); count != 1 {
+		t.Errorf("Redis计数过期后应从1开始，实际 %d", count)
+	}
+	redisClient = nil
+	mutex.Lock()
+	hits["10.0.0.1"].start = time.Now().Add(-2 * time.Minute)
+	mutex.Unlock()
+	if count, _ := incrementHits("10.0.0.1", time.Minute); count != 1 {
+		t.Errorf("内存计数超出窗口后应从1开始，实际 %d", count)
+	}
+}
+
+func TestBanEscalation(t *testing.T) {
+	defer resetState()
+	p := Policy{Threshold: 1, Window: time.Minute, Duration: time.Hour, MaxDuration: 6 * time.Hour, History: 24 * time.Hour}
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		previous bson.D // 已有的封禁记录，nil 表示没有
+		offenses int
+		duration time.Duration
+	}{
+		{name: "首次封禁", offenses: 1, duration: time.Hour},
+		{name: "第二次翻倍", previous: bson.D{{Key: "ip", Value: "1.2.3.4"}, {Key: "offenses", Value: 1}, {Key: "purge_at", Value: now.Add(time.Hour)}}, offenses: 2, duration: 2 * time.Hour},
+		{name: "第三次再翻倍", previous: bson.D{{Key: "ip", Value: "1.2.3.4"}, {Key: "offenses", Value: 2}, {Key: "purge_at", Value: now.Add(time.Hour)}}, offenses: 3, duration: 4 * time.Hour},
+		{name: "不超过最长时长", previous: bson.D{{Key: "ip", Value: "1.2.3.4"}, {Key: "offenses", Value: 9}, {Key: "purge_at", Value: now.Add(time.Hour)}}, offenses: 10, duration: 6 * time.Hour},
+		{name: "历史记录过期后重新计算", previous: bson.D{{Key: "ip", Value: "1.2.3.4"}, {Key: "offenses", Value: 3}, {Key: "purge_at", Value: now.Add(-time.Hour)}}, offenses: 1, duration: time.Hour},
+	}
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			SetMongoCollection(mt.Coll)
+			var docs []bson.D
+			if tt.previous != nil {
+				docs = append(docs, tt.previous)
+			}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "stone.bans", mtest.FirstBatch, docs...),
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			)
+
+			record, err := ban("1.2.3.4", "test", p)
+			if err != nil {
+				mt.Fatalf("封禁失败: %v", err)
+			}
+			if record.Offenses != tt.offenses || record.ExpiresAt.Sub(record.BannedAt) != tt.duration {
+				mt.Errorf("第 %d 次封禁 %s，期望第 %d 次 %s", record.Offenses, record.ExpiresAt.Sub(record.BannedAt), tt.offenses, tt.duration)
+			}
+			if record.PurgeAt.Sub(record.ExpiresAt) != p.History {
+				mt.Errorf("记录应在封禁解除后保留 %s", p.History)
+			}
+		})
+	}
+}
+
+func TestRecordBlock(t *testing.T) {
+	defer resetState()
+	SetPolicy(Policy{Threshold: 2})
+
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("达到阈值后封禁", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		if record, err := RecordBlock("5.6.7.8", "sqli"); record != nil || err != nil {
+			mt.Fatalf("未达到阈值不应封禁: %+v %v", record, err)
+		}
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.bans", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		record, err := RecordBlock("5.6.7.8", "sqli")
+		if err != nil || record == nil || record.Reason != "sqli" {
+			mt.Fatalf("达到阈值应当封禁: %+v %v", record, err)
+		}
+		if _, banned := IsBanned("5.6.7.8"); !banned {
+			mt.Error("封禁后 IsBanned 应返回 true")
+		}
+		// 封禁期间的拦截不再计数，也不会访问MongoDB
+		if record, err := RecordBlock("5.6.7.8", "sqli"); record != nil || err != nil {
+			mt.Errorf("封禁期间不应重复封禁: %+v %v", record, err)
+		}
+	})
+}
@@ -3,7 +3,9 @@
 package capture
 
 import (
+	"Stone/pkg/logging"
 	"Stone/pkg/processing"
+	"crypto/tls"
 	"fmt"
 	"net"
 )
@@ -17,16 +19,21 @@ func StartCapture(port int, targetAddress string) error {
 	}
 	defer listener.Close()
 
-	fmt.Printf("流量捕获已启动，监听端口: %d\n", port)
+	logging.LogInfo(fmt.Sprintf("流量捕获已启动，监听端口: %d", port))
 
-	// 接受并处理连接
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Println("接受连接失败:", err)
-			continue
-		}
+	// 接受并处理连接，请求的解析、长连接和超时由 http.Server 管理
+	return processing.NewServer(targetAddress).Serve(listener)
+}
 
-		go processing.HandleHTTPConnection(conn, targetAddress)
+// StartTLSCapture 启动HTTPS流量捕获，在本地终止TLS后按与HTTP相同的方式检查和转发
+func StartTLSCapture(port int, targetAddress string, config *tls.Config) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("监听HTTPS端口失败: %w", err)
 	}
+	defer listener.Close()
+
+	logging.LogInfo(fmt.Sprintf("HTTPS流量捕获已启动，监听端口: %d", port))
+
+	return processing.NewServer(targetAddress).Serve(tls.NewListener(listener, config))
 }
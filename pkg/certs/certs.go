@@ -0,0 +1,277 @@
+// pkg/certs/certs.go
+
+package certs
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// 证书来源
+const (
+	SourceFile     = "file"
+	SourceDatabase = "database"
+)
+
+// ErrNoCertificate 没有与客户端请求的主机名匹配的证书
+var ErrNoCertificate = errors.New("没有匹配的证书")
+
+// Certificate 保存在MongoDB中的证书，证书链和私钥均为PEM格式
+type Certificate struct {
+	Name      string    `bson:"name" json:"name"`
+	CertPEM   string    `bson:"cert" json:"cert"`
+	KeyPEM    string    `bson:"key" json:"key,omitempty"`
+	CA        bool      `bson:"ca,omitempty" json:"ca,omitempty"` // 本地CA，只用于签发证书，不用于TLS监听
+	CreatedBy string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt time.Time `bson:"created_at,omitempty" json:"created_at,omitempty"`
+}
+
+// CertInfo 已加载证书的摘要信息
+type CertInfo struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"` // file 或 database
+	Subject   string    `json:"subject"`
+	Hosts     []string  `json:"hosts"` // 证书中的主机名，可能包含 *.example.com
+	Issuer    string    `json:"issuer"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	DaysLeft  int       `json:"days_left"` // 距过期的天数，已过期时为负数
+	Expiring  bool      `json:"expiring"`  // 已过期或在提醒天数内过期
+}
+
+// loadedCert 解析后的证书
+type loadedCert struct {
+	info CertInfo
+	cert *tls.Certificate
+}
+
+// store 按主机名索引的证书，重新加载时整体替换
+type store struct {
+	certs  []loadedCert
+	byHost map[string]*tls.Certificate
+}
+
+var (
+	current         atomic.Pointer[store]
+	loadMutex       sync.Mutex
+	certDir         string
+	defaultHost     atomic.Pointer[string]
+	mongoCollection *mongo.Collection
+)
+
+func init() {
+	current.Store(&store{byHost: map[string]*tls.Certificate{}})
+}
+
+// SetMongoCollection 设置保存证书的MongoDB集合，未设置时只从证书目录加载
+func SetMongoCollection(collection *mongo.Collection) {
+	mongoCollection = collection
+}
+
+// SetDirectory 设置证书目录，目录中每对 <name>.crt 和 <name>.key 为一个证书
+func SetDirectory(dir string) {
+	loadMutex.Lock()
+	certDir = dir
+	loadMutex.Unlock()
+}
+
+// SetDefaultHost 设置客户端未发送SNI或没有匹配证书时使用的证书主机名
+func SetDefaultHost(host string) {
+	host = strings.ToLower(host)
+	defaultHost.Store(&host)
+}
+
+// ParseCertificate 解析PEM格式的证书链和私钥
+func ParseCertificate(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+	cert.Leaf = leaf
+	return &cert, nil
+}
+
+// certHosts 返回证书中的主机名，没有SAN时使用CN
+func certHosts(leaf *x509.Certificate) []string {
+	hosts := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses)+1)
+	for _, name := range leaf.DNSNames {
+		hosts = append(hosts, strings.ToLower(name))
+	}
+	for _, ip := range leaf.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	if len(hosts) == 0 && leaf.Subject.CommonName != "" {
+		hosts = append(hosts, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return hosts
+}
+
+// newLoadedCert 根据解析后的证书生成索引项
+func newLoadedCert(name, source string, cert *tls.Certificate) loadedCert {
+	return loadedCert{
+		info: CertInfo{
+			Name:      name,
+			Source:    source,
+			Subject:   cert.Leaf.Subject.String(),
+			Hosts:     certHosts(cert.Leaf),
+			Issuer:    cert.Leaf.Issuer.CommonName,
+			NotBefore: cert.Leaf.NotBefore,
+			NotAfter:  cert.Leaf.NotAfter,
+		},
+		cert: cert,
+	}
+}
+
+// Load 从证书目录和MongoDB重新加载全部证书，无法解析的证书记录日志后跳过
+// 同一主机名有多个证书时，MongoDB中的证书优先，其次是过期时间较晚的证书
+func Load(ctx context.Context) error {
+	loadMutex.Lock()
+	defer loadMutex.Unlock()
+
+	var loaded []loadedCert
+	if certDir != "" {
+		fromDir, err := loadDirectory(certDir)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, fromDir...)
+	}
+	if mongoCollection != nil {
+		fromDB, err := loadDatabase(ctx)
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, fromDB...)
+	}
+
+	sort.SliceStable(loaded, func(i, j int) bool {
+		if loaded[i].info.Source != loaded[j].info.Source {
+			return loaded[i].info.Source == SourceDatabase
+		}
+		return loaded[i].info.NotAfter.After(loaded[j].info.NotAfter)
+	})
+	s := &store{certs: loaded, byHost: make(map[string]*tls.Certificate)}
+	for _, c := range loaded {
+		for _, host := range c.info.Hosts {
+			if _, exists := s.byHost[host]; !exists {
+				s.byHost[host] = c.cert
+			}
+		}
+	}
+	current.Store(s)
+	return nil
+}
+
+// loadDirectory 加载证书目录中的 <name>.crt 和 <name>.key
+func loadDirectory(dir string) ([]loadedCert, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	var loaded []loadedCert
+	for _, certFile := range files {
+		name := strings.TrimSuffix(filepath.Base(certFile), ".crt")
+		certPEM, err := os.ReadFile(certFile)
+		if err != nil {
+			logging.LogError(fmt.Errorf("读取证书 %s 失败: %w", certFile, err))
+			continue
+		}
+		keyPEM, err := os.ReadFile(filepath.Join(dir, name+".key"))
+		if err != nil {
+			logging.LogError(fmt.Errorf("读取证书 %s 的私钥失败: %w", certFile, err))
+			continue
+		}
+		cert, err := ParseCertificate(certPEM, keyPEM)
+		if err != nil {
+			logging.LogError(fmt.Errorf("证书 %s: %w", certFile, err))
+			continue
+		}
+		loaded = append(loaded, newLoadedCert(name, SourceFile, cert))
+	}
+	return loaded, nil
+}
+
+// loadDatabase 加载MongoDB中的证书
+func loadDatabase(ctx context.Context) ([]loadedCert, error) {
+	cursor, err := mongoCollection.Find(ctx, bson.M{"ca": bson.M{"$ne": true}})
+	if err != nil {
+		return nil, fmt.Errorf("从MongoDB读取证书失败: %w", err)
+	}
+	var docs []Certificate
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, fmt.Errorf("从MongoDB读取证书失败: %w", err)
+	}
+
+	var loaded []loadedCert
+	for _, doc := range docs {
+		cert, err := ParseCertificate([]byte(doc.CertPEM), []byte(doc.KeyPEM))
+		if err != nil {
+			logging.LogError(fmt.Errorf("证书 %s: %w", doc.Name, err))
+			continue
+		}
+		loaded = append(loaded, newLoadedCert(doc.Name, SourceDatabase, cert))
+	}
+	return loaded, nil
+}
+
+// Lookup 按主机名选择证书：精确匹配优先，其次是上一级的通配证书，最后使用默认证书
+func Lookup(host string) (*tls.Certificate, error) {
+	s := current.Load()
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host != "" {
+		if cert, found := s.byHost[host]; found {
+			return cert, nil
+		}
+		if i := strings.IndexByte(host, '.'); i > 0 {
+			if cert, found := s.byHost["*"+host[i:]]; found {
+				return cert, nil
+			}
+		}
+	}
+
+	if fallback := defaultHost.Load(); fallback != nil && *fallback != "" {
+		if cert, found := s.byHost[*fallback]; found {
+			return cert, nil
+		}
+	}
+	// 只有一个证书时直接使用
+	if len(s.certs) == 1 {
+		return s.certs[0].cert, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrNoCertificate, host)
+}
+
+// List 返回已加载证书的摘要，按名称排序
+func List() []CertInfo {
+	s := current.Load()
+	now := time.Now()
+	infos := make([]CertInfo, 0, len(s.certs))
+	for _, c := range s.certs {
+		infos = append(infos, withExpiry(c.info, now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Name != infos[j].Name {
+			return infos[i].Name < infos[j].Name
+		}
+		return infos[i].Source < infos[j].Source
+	})
+	return infos
+}
@@ -0,0 +1,223 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCert 生成自签名证书，CN 为 name，有效期到 notAfter
+func newTestCert(t *testing.T, name string, hosts []string, notAfter time.Time) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template, err := newTemplate(name, 1)
+	if err != nil {
+		t.Fatalf("创建证书模板失败: %v", err)
+	}
+	template.NotAfter = notAfter
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成证书失败: %v", err)
+	}
+	certPEM, keyPEM, err = encodePEM(der, key)
+	if err != nil {
+		t.Fatalf("编码证书失败: %v", err)
+	}
+	return certPEM, keyPEM
+}
+
+// writeTestCert 在证书目录中写入 <name>.crt 和 <name>.key
+func writeTestCert(t *testing.T, dir, name string, hosts []string, notAfter time.Time) {
+	t.Helper()
+	certPEM, keyPEM := newTestCert(t, name, hosts, notAfter)
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestDirectory 从目录加载证书，测试结束后清空
+func loadTestDirectory(t *testing.T, dir string) {
+	t.Helper()
+	SetDirectory(dir)
+	if err := Load(context.Background()); err != nil {
+		t.Fatalf("加载证书失败: %v", err)
+	}
+	t.Cleanup(func() {
+		SetDirectory("")
+		SetDefaultHost("")
+		Load(context.Background())
+	})
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	year := time.Now().AddDate(1, 0, 0)
+	writeTestCert(t, dir, "www", []string{"www.example.com"}, year)
+	writeTestCert(t, dir, "wild", []string{"*.example.com"}, year)
+	writeTestCert(t, dir, "wild-old", []string{"*.example.com"}, year.AddDate(0, -6, 0))
+	writeTestCert(t, dir, "ip", []string{"127.0.0.1"}, year)
+	os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("not a certificate"), 0o644) // 无法解析的证书被跳过
+	loadTestDirectory(t, dir)
+
+	tests := []struct {
+		host        string
+		defaultHost string
+		want        string // 选中证书的CN，为空表示没有匹配的证书
+	}{
+		{host: "www.example.com", want: "www"},
+		{host: "WWW.Example.COM.", want: "www"},
+		{host: "api.example.com", want: "wild"}, // 同一主机名过期时间较晚的优先
+		{host: "a.b.example.com"},               // 通配证书只匹配一级
+		{host: "a.b.example.com", defaultHost: "WWW.example.com", want: "www"},
+		{host: "", defaultHost: "www.example.com", want: "www"},
+		{host: "127.0.0.1", want: "ip"},
+		{host: "other.org", defaultHost: "missing.example.com"},
+	}
+	for _, tt := range tests {
+		SetDefaultHost(tt.defaultHost)
+		cert, err := Lookup(tt.host)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNoCertificate) {
+				t.Errorf("%q: 期望 ErrNoCertificate，结果 %v", tt.host, err)
+			}
+			continue
+		}
+		if err != nil || cert.Leaf.Subject.CommonName != tt.want {
+			t.Errorf("%q: 选择了 %v(%v)，期望 %q", tt.host, cert, err, tt.want)
+		}
+	}
+	if infos := List(); len(infos) != 4 || infos[0].Name != "ip" {
+		t.Errorf("应加载4个证书并按名称排序，结果 %+v", infos)
+	}
+}
+
+func TestLookupSingleCertificate(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "only", []string{"www.example.com"}, time.Now().AddDate(1, 0, 0))
+	loadTestDirectory(t, dir)
+
+	if cert, err := Lookup("other.org"); err != nil || cert.Leaf.Subject.CommonName != "only" {
+		t.Errorf("只有一个证书时应直接使用，结果 %v(%v)", cert, err)
+	}
+}
+
+func TestServerConfigSNI(t *testing.T) {
+	dir := t.TempDir()
+	year := time.Now().AddDate(1, 0, 0)
+	writeTestCert(t, dir, "a", []string{"a.example.com"}, year)
+	writeTestCert(t, dir, "b", []string{"b.example.com"}, year)
+	loadTestDirectory(t, dir)
+	defer SetOptions("", nil)
+
+	tests := []struct {
+		name       string
+		minVersion string
+		serverName string
+		maxVersion uint16
+		want       string // 握手成功时服务端出示的证书CN
+	}{
+		{name: "按SNI选择证书", serverName: "a.example.com", want: "a"},
+		{name: "按SNI选择另一个证书", serverName: "b.example.com", want: "b"},
+		{name: "没有匹配的证书", serverName: "c.example.com"},
+		{name: "低于最低版本", minVersion: "1.3", serverName: "a.example.com", maxVersion: tls.VersionTLS12},
+		{name: "满足最低版本", minVersion: "TLS1.3", serverName: "a.example.com", want: "a"},
+	}
+	for _, tt := range tests {
+		if err := SetOptions(tt.minVersion, nil); err != nil {
+			t.Fatalf("%s: 设置TLS选项失败: %v", tt.name, err)
+		}
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", ServerConfig())
+		if err != nil {
+			t.Fatalf("监听失败: %v", err)
+		}
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+		client, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true, MaxVersion: tt.maxVersion})
+		listener.Close()
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: 握手应当失败", tt.name)
+			}
+		} else if err != nil {
+			t.Errorf("%s: 握手失败: %v", tt.name, err)
+		} else if cn := client.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != tt.want {
+			t.Errorf("%s: 服务端出示证书 %q，期望 %q", tt.name, cn, tt.want)
+		}
+		if client != nil {
+			client.Close()
+		}
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	defer SetOptions("", nil)
+	tests := []struct {
+		minVersion   string
+		cipherSuites []string
+		valid        bool
+	}{
+		{valid: true},
+		{minVersion: "1.0", cipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}, valid: true},
+		{minVersion: "1.4"},
+		{cipherSuites: []string{"TLS_AES_128_GCM_SHA256_UNKNOWN"}},
+	}
+	for _, tt := range tests {
+		if err := SetOptions(tt.minVersion, tt.cipherSuites); (err == nil) != tt.valid || (err != nil && !errors.Is(err, ErrInvalidTLSOptions)) {
+			t.Errorf("%q %v: 结果 %v，期望有效 %v", tt.minVersion, tt.cipherSuites, err, tt.valid)
+		}
+	}
+}
+
+func TestClientConfig(t *testing.T) {
+	certPEM, _ := newTestCert(t, "ca", []string{"upstream.internal"}, time.Now().AddDate(1, 0, 0))
+	path := filepath.Join(t.TempDir(), "ca.crt")
+	os.WriteFile(path, certPEM, 0o644)
+
+	tests := []struct {
+		name  string
+		ca    string
+		roots bool
+		valid bool
+	}{
+		{name: "系统CA", valid: true},
+		{name: "PEM内容", ca: string(certPEM), roots: true, valid: true},
+		{name: "不接受文件路径", ca: path},
+		{name: "无效的PEM", ca: "-----BEGIN CERTIFICATE-----\nbad\n-----END CERTIFICATE-----"},
+	}
+	for _, tt := range tests {
+		config, err := ClientConfig(tt.ca, "upstream.internal", false)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: 结果 %v，期望有效 %v", tt.name, err, tt.valid)
+			continue
+		}
+		if err == nil && (config.RootCAs != nil) != tt.roots {
+			t.Errorf("%s: 自定义CA %v，期望 %v", tt.name, config.RootCAs != nil, tt.roots)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// pkg/certs/expiry.go
+
+package certs
+
+import (
+	"Stone/pkg/logging"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultExpiryWarningDays 证书在多少天内过期时提醒
+const DefaultExpiryWarningDays = 30
+
+// alertInterval 同一证书两次提醒之间的最短间隔
+const alertInterval = 24 * time.Hour
+
+var (
+	warningDays  atomic.Int64
+	alertWebhook atomic.Pointer[string]
+
+	alertedMutex sync.Mutex
+	alerted      = make(map[string]time.Time) // 每个证书上次提醒的时间，键为来源/名称
+)
+
+func init() {
+	warningDays.Store(DefaultExpiryWarningDays)
+}
+
+// SetExpiryWarning 设置提醒天数和接收提醒的Webhook地址，days 为0时使用默认值，webhook 为空时只记录日志
+func SetExpiryWarning(days int, webhook string) {
+	if days <= 0 {
+		days = DefaultExpiryWarningDays
+	}
+	warningDays.Store(int64(days))
+	alertWebhook.Store(&webhook)
+}
+
+// withExpiry 根据当前时间填写剩余天数和是否即将过期
+func withExpiry(info CertInfo, now time.Time) CertInfo {
+	remaining := info.NotAfter.Sub(now)
+	info.DaysLeft = int(remaining / (24 * time.Hour))
+	if remaining < 0 {
+		info.DaysLeft = -int(-remaining / (24 * time.Hour))
+	}
+	info.Expiring = remaining < time.Duration(warningDays.Load())*24*time.Hour
+	return info
+}
+
+// Expiring 返回已过期或在提醒天数内过期的证书，按过期时间排序
+func Expiring() []CertInfo {
+	expiring := []CertInfo{}
+	for _, info := range List() {
+		if info.Expiring {
+			expiring = append(expiring, info)
+		}
+	}
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].NotAfter.Before(expiring[j].NotAfter) })
+	return expiring
+}
+
+// StartExpiryMonitor 定期检查证书有效期，即将过期的证书记录日志并发送到Webhook，同一证书每天最多提醒一次
+func StartExpiryMonitor(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			checkExpiry(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// expiryAlert 发送到Webhook的提醒内容
+type expiryAlert struct {
+	Type        string   `json:"type"`
+	Message     string   `json:"message"`
+	Certificate CertInfo `json:"certificate"`
+}
+
+// checkExpiry 检查一次证书有效期并发送提醒
+func checkExpiry(ctx context.Context) {
+	now := time.Now()
+	for _, info := range Expiring() {
+		key := info.Source + "/" + info.Name
+		alertedMutex.Lock()
+		last, found := alerted[key]
+		if found && now.Sub(last) < alertInterval {
+			alertedMutex.Unlock()
+			continue
+		}
+		alerted[key] = now
+		alertedMutex.Unlock()
+
+		message := fmt.Sprintf("证书 %s 将于 %s 过期，剩余 %d 天", info.Name, info.NotAfter.Format(time.RFC3339), info.DaysLeft)
+		if now.After(info.NotAfter) {
+			message = fmt.Sprintf("证书 %s 已于 %s 过期", info.Name, info.NotAfter.Format(time.RFC3339))
+		}
+		logging.LogError(errors.New(message))
+
+		if err := sendAlert(ctx, expiryAlert{Type: "certificate_expiring", Message: message, Certificate: info}); err != nil {
+			logging.LogError(fmt.Errorf("发送证书过期提醒失败: %w", err))
+		}
+	}
+}
+
+// sendAlert 将提醒以JSON发送到Webhook，未设置Webhook时不发送
+func sendAlert(ctx context.Context, alert expiryAlert) error {
+	webhook := alertWebhook.Load()
+	if webhook == nil || *webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithExpiry(t *testing.T) {
+	defer SetExpiryWarning(0, "")
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		days     int
+		notAfter time.Time
+		daysLeft int
+		expiring bool
+	}{
+		{name: "有效期充足", notAfter: now.Add(90 * day), daysLeft: 90},
+		{name: "刚好超过提醒天数", notAfter: now.Add(30 * day), daysLeft: 30},
+		{name: "进入提醒天数", notAfter: now.Add(30*day - time.Minute), daysLeft: 29, expiring: true},
+		{name: "自定义提醒天数", days: 7, notAfter: now.Add(10 * day), daysLeft: 10},
+		{name: "自定义提醒天数内", days: 7, notAfter: now.Add(6 * day), daysLeft: 6, expiring: true},
+		{name: "不足一天", notAfter: now.Add(time.Hour), daysLeft: 0, expiring: true},
+		{name: "已过期", notAfter: now.Add(-36 * time.Hour), daysLeft: -1, expiring: true},
+	}
+	for _, tt := range tests {
+		SetExpiryWarning(tt.days, "")
+		info := withExpiry(CertInfo{NotAfter: tt.notAfter}, now)
+		if info.DaysLeft != tt.daysLeft || info.Expiring != tt.expiring {
+			t.Errorf("%s: 剩余 %d 天 即将过期 %v，期望 %d 天 %v", tt.name, info.DaysLeft, info.Expiring, tt.daysLeft, tt.expiring)
+		}
+	}
+}
+
+func TestExpiryAlerts(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeTestCert(t, dir, "ok", []string{"ok.example.com"}, now.AddDate(1, 0, 0))
+	writeTestCert(t, dir, "soon", []string{"soon.example.com"}, now.AddDate(0, 0, 10))
+	writeTestCert(t, dir, "expired", []string{"expired.example.com"}, now.AddDate(0, 0, -1))
+	loadTestDirectory(t, dir)
+
+	if expiring := Expiring(); len(expiring) != 2 || expiring[0].Name != "expired" || expiring[1].Name != "soon" {
+		t.Errorf("即将过期的证书 %+v，期望按过期时间排序的 expired 和 soon", expiring)
+	}
+
+	var mutex sync.Mutex
+	var received []expiryAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert expiryAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		received = append(received, alert)
+		mutex.Unlock()
+	}))
+	defer webhook.Close()
+	SetExpiryWarning(0, webhook.URL)
+	defer SetExpiryWarning(0, "")
+	defer func() {
+		alertedMutex.Lock()
+		alerted = make(map[string]time.Time)
+		alertedMutex.Unlock()
+	}()
+
+	// 同一证书在间隔内只提醒一次
+	checkExpiry(context.Background())
+	checkExpiry(context.Background())
+	if len(received) != 2 {
+		t.Fatalf("收到 %d 条提醒，期望 2 条", len(received))
+	}
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{name: "expired", message: "证书 expired 已于 " + received[0].Certificate.NotAfter.Format(time.RFC3339) + " 过期"},
+		{name: "soon", message: "证书 soon 将于 " + received[1].Certificate.NotAfter.Format(time.RFC3339) + " 过期，剩余 9 天"},
+	}
+	for i, tt := range tests {
+		if alert := received[i]; alert.Type != "certificate_expiring" || alert.Certificate.Name != tt.name || alert.Message != tt.message {
+			t.Errorf("第 %d 条提醒 %+v，期望证书 %s 消息 %q", i+1, alert, tt.name, tt.message)
+		}
+	}
+
+	// 超过提醒间隔后再次提醒
+	alertedMutex.Lock()
+	alerted["file/soon"] = now.Add(-alertInterval)
+	alertedMutex.Unlock()
+	checkExpiry(context.Background())
+	if len(received) != 3 || received[2].Certificate.Name != "soon" {
+		t.Errorf("超过提醒间隔后应再次提醒 soon，收到 %d 条", len(received))
+	}
+}
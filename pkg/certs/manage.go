@@ -0,0 +1,271 @@
+// pkg/certs/manage.go
+
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 证书管理的错误
+var (
+	ErrInvalidCertificate  = errors.New("无效的证书")
+	ErrCertificateNotFound = errors.New("证书不存在")
+	ErrFileCertificate     = errors.New("证书目录中的证书只能通过修改文件管理")
+)
+
+// 签发方式
+const (
+	IssuerSelfSigned = "self_signed" // 自签名
+	IssuerLocalCA    = "local_ca"    // 由本地CA签发，客户端信任本地CA后即可校验
+)
+
+// 生成证书的有效期
+const (
+	DefaultValidityDays = 365
+	MaxValidityDays     = 825
+	localCAValidityDays = 3650
+	localCAName         = "Stone Local CA"
+)
+
+// GenerateRequest 生成证书的参数
+type GenerateRequest struct {
+	Name   string   `json:"name"`
+	Hosts  []string `json:"hosts"`  // 主机名或IP，第一个作为证书的CN
+	Issuer string   `json:"issuer"` // self_signed（默认）或 local_ca
+	Days   int      `json:"days"`   // 有效天数，0 使用默认值
+}
+
+// ValidateName 校验证书名称，名称同时用作证书目录中的文件名
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: 名称不能为空", ErrInvalidCertificate)
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("%w: 名称 %q 不能包含路径分隔符", ErrInvalidCertificate, name)
+	}
+	return nil
+}
+
+// Get 返回特定名称的证书摘要，同名证书同时存在于MongoDB和证书目录时返回MongoDB中的证书
+func Get(name string) (CertInfo, bool) {
+	for _, info := range List() {
+		if info.Name == name && info.Source == SourceDatabase {
+			return info, true
+		}
+	}
+	for _, info := range List() {
+		if info.Name == name {
+			return info, true
+		}
+	}
+	return CertInfo{}, false
+}
+
+// Save 校验证书链和私钥后保存到MongoDB，同名证书会被替换，保存后立即重新加载
+func Save(ctx context.Context, cert Certificate) (CertInfo, error) {
+	if err := ValidateName(cert.Name); err != nil {
+		return CertInfo{}, err
+	}
+	parsed, err := ParseCertificate([]byte(cert.CertPEM), []byte(cert.KeyPEM))
+	if err != nil {
+		return CertInfo{}, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	cert.CA = false
+	if cert.CreatedAt.IsZero() {
+		cert.CreatedAt = time.Now()
+	}
+
+	_, err = mongoCollection.ReplaceOne(ctx,
+		bson.M{"name": cert.Name, "ca": bson.M{"$ne": true}},
+		cert,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	if err := Load(ctx); err != nil {
+		return CertInfo{}, err
+	}
+	return withExpiry(newLoadedCert(cert.Name, SourceDatabase, parsed).info, time.Now()), nil
+}
+
+// Delete 删除MongoDB中的证书，证书目录中的证书不能通过接口删除
+func Delete(ctx context.Context, name string) error {
+	result, err := mongoCollection.DeleteOne(ctx, bson.M{"name": name, "ca": bson.M{"$ne": true}})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		if info, found := Get(name); found && info.Source == SourceFile {
+			return fmt.Errorf("%w: %s", ErrFileCertificate, name)
+		}
+		return fmt.Errorf("%w: %s", ErrCertificateNotFound, name)
+	}
+	return Load(ctx)
+}
+
+// Generate 生成证书并保存到MongoDB，私钥为ECDSA P-256
+func Generate(ctx context.Context, req GenerateRequest, author string) (CertInfo, error) {
+	if err := ValidateName(req.Name); err != nil {
+		return CertInfo{}, err
+	}
+	if len(req.Hosts) == 0 {
+		return CertInfo{}, fmt.Errorf("%w: 至少需要一个主机名", ErrInvalidCertificate)
+	}
+	if req.Days <= 0 {
+		req.Days = DefaultValidityDays
+	}
+	if req.Days > MaxValidityDays {
+		return CertInfo{}, fmt.Errorf("%w: 有效期不能超过 %d 天", ErrInvalidCertificate, MaxValidityDays)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	template, err := newTemplate(req.Hosts[0], req.Days)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range req.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, strings.ToLower(host))
+		}
+	}
+
+	// 自签名证书的签发者是证书本身
+	parent, signer := template, any(key)
+	var chain []byte
+	switch req.Issuer {
+	case "", IssuerSelfSigned:
+	case IssuerLocalCA:
+		ca, caKey, caPEM, err := localCA(ctx)
+		if err != nil {
+			return CertInfo{}, err
+		}
+		parent, signer, chain = ca, caKey, caPEM
+	default:
+		return CertInfo{}, fmt.Errorf("%w: 签发方式 %q 无效，可选 %s、%s", ErrInvalidCertificate, req.Issuer, IssuerSelfSigned, IssuerLocalCA)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	certPEM, keyPEM, err := encodePEM(der, key)
+	if err != nil {
+		return CertInfo{}, err
+	}
+	return Save(ctx, Certificate{
+		Name:      req.Name,
+		CertPEM:   string(append(certPEM, chain...)),
+		KeyPEM:    string(keyPEM),
+		CreatedBy: author,
+	})
+}
+
+// LocalCACertificate 返回本地CA证书，尚未创建时生成一个新的CA
+func LocalCACertificate(ctx context.Context) ([]byte, error) {
+	_, _, caPEM, err := localCA(ctx)
+	return caPEM, err
+}
+
+// localCA 读取本地CA，不存在时生成并保存到MongoDB
+func localCA(ctx context.Context) (*x509.Certificate, *ecdsa.PrivateKey, []byte, error) {
+	var doc Certificate
+	err := mongoCollection.FindOne(ctx, bson.M{"ca": true}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		doc, err = createLocalCA(ctx)
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("读取本地CA失败: %w", err)
+	}
+
+	parsed, err := ParseCertificate([]byte(doc.CertPEM), []byte(doc.KeyPEM))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("本地CA: %w", err)
+	}
+	key, ok := parsed.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, nil, errors.New("本地CA的私钥类型不受支持")
+	}
+	return parsed.Leaf, key, []byte(doc.CertPEM), nil
+}
+
+// createLocalCA 生成本地CA并保存，其他实例同时生成时使用先保存的CA
+func createLocalCA(ctx context.Context) (Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Certificate{}, err
+	}
+	template, err := newTemplate(localCAName, localCAValidityDays)
+	if err != nil {
+		return Certificate{}, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return Certificate{}, err
+	}
+	certPEM, keyPEM, err := encodePEM(der, key)
+	if err != nil {
+		return Certificate{}, err
+	}
+
+	doc := Certificate{Name: localCAName, CertPEM: string(certPEM), KeyPEM: string(keyPEM), CA: true, CreatedAt: time.Now()}
+	_, err = mongoCollection.UpdateOne(ctx, bson.M{"ca": true}, bson.M{"$setOnInsert": doc}, options.Update().SetUpsert(true))
+	if err != nil {
+		return Certificate{}, err
+	}
+	var saved Certificate
+	err = mongoCollection.FindOne(ctx, bson.M{"ca": true}).Decode(&saved)
+	return saved, err
+}
+
+// newTemplate 创建有效期从一小时前开始的证书模板，避免各主机时钟偏差导致证书尚未生效
+func newTemplate(commonName string, days int) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Stone"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 0, days),
+	}, nil
+}
+
+// encodePEM 将证书和私钥编码为PEM
+func encodePEM(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
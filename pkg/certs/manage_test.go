@@ -0,0 +1,189 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// newTestCA 生成本地CA文档，模拟其他实例已保存的CA
+func newTestCA(t *testing.T) Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	template, err := newTemplate(localCAName, localCAValidityDays)
+	if err != nil {
+		t.Fatalf("创建证书模板失败: %v", err)
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("生成CA证书失败: %v", err)
+	}
+	certPEM, keyPEM, err := encodePEM(der, key)
+	if err != nil {
+		t.Fatalf("编码CA证书失败: %v", err)
+	}
+	return Certificate{Name: localCAName, CertPEM: string(certPEM), KeyPEM: string(keyPEM), CA: true}
+}
+
+// certificateDoc 将证书转换为模拟响应中的文档
+func certificateDoc(t *testing.T, cert Certificate) bson.D {
+	t.Helper()
+	raw, err := bson.Marshal(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// savedCertificate 返回 Save 写入MongoDB的证书链，即最后一次更新的内容
+func savedCertificate(mt *mtest.T) []*x509.Certificate {
+	events := mt.GetAllStartedEvents()
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if event.CommandName != "update" {
+			continue
+		}
+		rest := []byte(event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "cert").StringValue())
+		var chain []*x509.Certificate
+		for {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				return chain
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				mt.Fatalf("解析保存的证书失败: %v", err)
+			}
+			chain = append(chain, cert)
+		}
+	}
+	mt.Fatal("没有保存证书")
+	return nil
+}
+
+func TestGenerate(t *testing.T) {
+	defer SetMongoCollection(nil)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("自签名证书", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch))
+		info, err := Generate(context.Background(), GenerateRequest{Name: "www", Hosts: []string{"WWW.example.com", "*.example.com", "10.0.0.1"}}, "admin")
+		if err != nil {
+			mt.Fatalf("生成证书失败: %v", err)
+		}
+		if info.Subject != "CN=WWW.example.com,O=Stone" || info.Issuer != "WWW.example.com" || info.Source != SourceDatabase {
+			mt.Errorf("证书 %+v，期望以第一个主机名为CN的自签名证书", info)
+		}
+		if info.DaysLeft != DefaultValidityDays-1 || info.Expiring {
+			mt.Errorf("剩余 %d 天，期望默认有效期 %d 天", info.DaysLeft, DefaultValidityDays)
+		}
+		leaf := savedCertificate(mt)[0]
+		if err := leaf.VerifyHostname("api.example.com"); err != nil {
+			mt.Errorf("证书应包含小写的通配主机名: %v", err)
+		}
+		if err := leaf.VerifyHostname("10.0.0.1"); err != nil || len(leaf.DNSNames) != 2 {
+			mt.Errorf("IP应写入IP地址而不是主机名: %v %v", leaf.DNSNames, err)
+		}
+		if time.Since(leaf.NotBefore) < 59*time.Minute {
+			mt.Errorf("生效时间 %v，期望提前一小时", leaf.NotBefore)
+		}
+	})
+	mt.Run("使用已保存的本地CA签发", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		ca := newTestCA(t)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch, certificateDoc(t, ca)),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch),
+		)
+		info, err := Generate(context.Background(), GenerateRequest{Name: "api", Hosts: []string{"api.internal"}, Issuer: IssuerLocalCA, Days: 30}, "admin")
+		if err != nil {
+			mt.Fatalf("生成证书失败: %v", err)
+		}
+		if info.Issuer != localCAName || !info.Expiring {
+			mt.Errorf("证书 %+v，期望由本地CA签发且在提醒天数内过期", info)
+		}
+		chain := savedCertificate(mt)
+		if len(chain) != 2 {
+			mt.Fatalf("证书链包含 %d 个证书，期望附带CA证书", len(chain))
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM([]byte(ca.CertPEM))
+		if _, err := chain[0].Verify(x509.VerifyOptions{DNSName: "api.internal", Roots: roots}); err != nil {
+			mt.Errorf("信任本地CA后证书应校验通过: %v", err)
+		}
+	})
+	mt.Run("其他实例同时创建本地CA", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		ca := newTestCA(t)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch, certificateDoc(t, ca)),
+			mtest.CreateSuccessResponse(),
+			mtest.CreateCursorResponse(0, "stone.certs", mtest.FirstBatch),
+		)
+		if _, err := Generate(context.Background(), GenerateRequest{Name: "api", Hosts: []string{"api.internal"}, Issuer: IssuerLocalCA}, "admin"); err != nil {
+			mt.Fatalf("生成证书失败: %v", err)
+		}
+		if chain := savedCertificate(mt); len(chain) != 2 || chain[1].Subject.CommonName != localCAName || string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[1].Raw})) != ca.CertPEM {
+			mt.Error("应使用先保存的本地CA签发")
+		}
+	})
+
+	tests := []struct {
+		name string
+		req  GenerateRequest
+	}{
+		{name: "缺少名称", req: GenerateRequest{Hosts: []string{"a.example.com"}}},
+		{name: "名称包含路径", req: GenerateRequest{Name: "../a", Hosts: []string{"a.example.com"}}},
+		{name: "缺少主机名", req: GenerateRequest{Name: "a"}},
+		{name: "有效期过长", req: GenerateRequest{Name: "a", Hosts: []string{"a.example.com"}, Days: MaxValidityDays + 1}},
+		{name: "未知的签发方式", req: GenerateRequest{Name: "a", Hosts: []string{"a.example.com"}, Issuer: "acme"}},
+	}
+	for _, tt := range tests {
+		if _, err := Generate(context.Background(), tt.req, "admin"); !errors.Is(err, ErrInvalidCertificate) {
+			t.Errorf("%s: 期望 ErrInvalidCertificate，结果 %v", tt.name, err)
+		}
+	}
+}
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "www.example.com", valid: true},
+		{name: "wildcard_example", valid: true},
+		{name: ""},
+		{name: "."},
+		{name: ".."},
+		{name: "a/b"},
+		{name: `a\b`},
+	}
+	for _, tt := range tests {
+		if err := ValidateName(tt.name); (err == nil) != tt.valid {
+			t.Errorf("%q: 结果 %v，期望有效 %v", tt.name, err, tt.valid)
+		}
+	}
+}
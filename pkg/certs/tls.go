@@ -0,0 +1,157 @@
+// pkg/certs/tls.go
+
+package certs
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ErrInvalidTLSOptions TLS版本或加密套件配置无效
+var ErrInvalidTLSOptions = errors.New("无效的TLS配置")
+
+// DefaultMinVersion 未配置时允许的最低TLS版本
+const DefaultMinVersion = "1.2"
+
+// reloadDelay 证书目录变化后等待写入完成再重新加载
+const reloadDelay = 500 * time.Millisecond
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsOptions 当前的TLS版本和加密套件，握手时读取，修改后对新连接立即生效
+type tlsOptions struct {
+	minVersion   uint16
+	cipherSuites []uint16
+}
+
+var currentOptions atomic.Pointer[tlsOptions]
+
+func init() {
+	currentOptions.Store(&tlsOptions{minVersion: tls.VersionTLS12})
+}
+
+// SetOptions 设置允许的最低TLS版本和 TLS 1.2 及以下使用的加密套件，为空时使用默认值
+// 加密套件使用Go中的名称，例如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256；TLS 1.3 的加密套件不可配置
+func SetOptions(minVersion string, cipherSuites []string) error {
+	if minVersion == "" {
+		minVersion = DefaultMinVersion
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(minVersion, "TLS")]
+	if !ok {
+		return fmt.Errorf("%w: 最低版本 %q，可选 1.0、1.1、1.2、1.3", ErrInvalidTLSOptions, minVersion)
+	}
+
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range cipherSuites {
+		id, ok := suites[name]
+		if !ok {
+			return fmt.Errorf("%w: 未知的加密套件 %q", ErrInvalidTLSOptions, name)
+		}
+		ids = append(ids, id)
+	}
+
+	currentOptions.Store(&tlsOptions{minVersion: version, cipherSuites: ids})
+	return nil
+}
+
+// ServerConfig 返回TLS监听使用的配置，按SNI选择证书，每次握手使用当前的版本和加密套件设置
+func ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			opts := currentOptions.Load()
+			return &tls.Config{
+				MinVersion:   opts.minVersion,
+				CipherSuites: opts.cipherSuites,
+				GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return Lookup(hello.ServerName)
+				},
+			}, nil
+		},
+	}
+}
+
+// ClientConfig 返回连接HTTPS上游使用的配置
+// ca 为信任的CA证书的PEM内容，为空时使用系统CA；serverName 为空时使用上游地址中的主机名
+// 路由通过管理接口修改，不接受文件路径，以免读取服务器上的任意文件
+func ClientConfig(ca, serverName string, insecureSkipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if ca == "" {
+		return config, nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		return nil, errors.New("CA证书中没有有效的PEM证书")
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// WatchDirectory 监视证书目录，文件变化时重新加载全部证书，ctx 取消时停止
+func WatchDirectory(ctx context.Context, dir string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建证书目录监视器失败: %w", err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("监视证书目录失败: %w", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				// 合并短时间内的多个事件，证书和私钥通常先后写入
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					if err := Load(context.Background()); err != nil {
+						logging.LogError(fmt.Errorf("重新加载证书失败，继续使用当前证书: %w", err))
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logging.LogError(fmt.Errorf("监视证书目录出错: %w", err))
+			}
+		}
+	}()
+	return nil
+}
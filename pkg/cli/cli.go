@@ -0,0 +1,260 @@
+// pkg/cli/cli.go
+
+package cli
+
+import (
+	"Stone/pkg/rules"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultMongoURI 与服务进程使用相同的MongoDB地址
+const defaultMongoURI = "mongodb://localhost:27017"
+
+const usage = `用法:
+  stone ip export    [-type blacklist|whitelist] [-format txt|csv] [-o 文件]
+  stone ip import    [-type blacklist|whitelist] [-format txt|csv] [-dry-run] 文件
+  stone rules export [-format yaml|json] [-o 文件]
+  stone rules import [-format yaml|json] [-dry-run] [-allow-empty] 文件
+  stone rules test   [-candidate 规则文件] 请求文件
+
+导入会整体替换对应的名单或规则，建议先使用 -dry-run 查看差异；导入空规则集需要指定 -allow-empty。
+文件为 "-" 时从标准输入读取，未指定 -format 时按文件扩展名判断格式。
+rules test 用MongoDB中的规则或 -candidate 指定的规则检查请求文件中的原始HTTP请求，不影响运行中的实例。`
+
+// IsCommand 判断命令行参数是否为CLI子命令
+func IsCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "ip" || args[0] == "rules" || args[0] == "help")
+}
+
+// Run 执行CLI子命令，args 不包含程序名
+func Run(args []string) error {
+	if len(args) < 2 || args[0] == "help" {
+		fmt.Println(usage)
+		return nil
+	}
+
+	flags := flag.NewFlagSet("stone "+args[0]+" "+args[1], flag.ContinueOnError)
+	mongoURI := flags.String("mongo", defaultMongoURI, "MongoDB地址")
+	listType := flags.String("type", "blacklist", "IP名单类型：blacklist 或 whitelist")
+	format := flags.String("format", "", "文件格式")
+	output := flags.String("o", "-", "导出文件，默认输出到标准输出")
+	dryRun := flags.Bool("dry-run", false, "只显示差异，不写入")
+	allowEmpty := flags.Bool("allow-empty", false, "允许导入空规则集，清空全部拦截规则")
+	candidate := flags.String("candidate", "", "测试使用的候选规则文件，yaml 或 json")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(*mongoURI))
+	if err != nil {
+		return fmt.Errorf("无法连接到MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+	rules.SetMongoCollection(client.Database("stoneDB").Collection("rules"))
+	rules.SetHistoryCollection(client.Database("stoneDB").Collection("rule_versions"))
+
+	switch args[0] + " " + args[1] {
+	case "ip export":
+		current, err := rules.LoadIPControlRules(ctx)
+		if err != nil {
+			return err
+		}
+		entries := current.Blacklist
+		if *listType == "whitelist" {
+			entries = current.Whitelist
+		}
+		return writeOutput(*output, func(w io.Writer) error {
+			return rules.FormatIPList(fileFormat(*format, *output, rules.FormatText), entries, w)
+		})
+
+	case "ip import":
+		if _, err := rules.LoadIPControlRules(ctx); err != nil {
+			return err
+		}
+		var entries []rules.IPEntry
+		err := readInput(flags.Arg(0), func(r io.Reader) error {
+			entries, err = rules.ParseIPList(fileFormat(*format, flags.Arg(0), rules.FormatText), r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		diff, err := rules.ReplaceIPList(*listType, entries, "cli", *dryRun)
+		if err != nil {
+			return err
+		}
+		printIPDiff(diff, *dryRun)
+		return nil
+
+	case "rules export":
+		current, err := rules.LoadInterceptionRules(ctx)
+		if err != nil {
+			return err
+		}
+		return writeOutput(*output, func(w io.Writer) error {
+			return rules.FormatInterceptionRules(fileFormat(*format, *output, rules.FormatYAML), current.Rules, w)
+		})
+
+	case "rules import":
+		if _, err := rules.LoadInterceptionRules(ctx); err != nil {
+			return err
+		}
+		var patterns []rules.Pattern
+		err := readInput(flags.Arg(0), func(r io.Reader) error {
+			patterns, err = rules.ParseInterceptionRules(fileFormat(*format, flags.Arg(0), rules.FormatYAML), r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		diff, err := rules.ReplaceInterceptionRules(patterns, "cli", *dryRun, *allowEmpty)
+		if err != nil {
+			return err
+		}
+		printRulesDiff(diff, *dryRun)
+		return nil
+
+	case "rules test":
+		var requests []*http.Request
+		err := readInput(flags.Arg(0), func(r io.Reader) error {
+			requests, err = rules.ParseRawRequests(r)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		// 未指定候选规则时使用MongoDB中的规则，无效的规则与服务进程一样被跳过
+		if _, err := rules.LoadInterceptionRules(ctx); err != nil {
+			return err
+		}
+		var patterns []rules.Pattern
+		if *candidate != "" {
+			err = readInput(*candidate, func(r io.Reader) error {
+				patterns, err = rules.ParseInterceptionRules(fileFormat(*format, *candidate, rules.FormatYAML), r)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		ruleset, err := rules.CandidateRuleset(nil, patterns)
+		if err != nil {
+			return err
+		}
+		printTestResults(rules.TestRequests(requests, ruleset))
+		return nil
+	}
+
+	fmt.Println(usage)
+	return fmt.Errorf("未知的命令 %q", strings.Join(args[:2], " "))
+}
+
+// fileFormat 返回文件格式：优先使用 -format，其次使用文件扩展名
+func fileFormat(format, path, defaultFormat string) string {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	switch format {
+	case "":
+		return defaultFormat
+	case "yml":
+		return rules.FormatYAML
+	case "text":
+		return rules.FormatText
+	}
+	return format
+}
+
+func readInput(path string, read func(io.Reader) error) error {
+	if path == "" {
+		return errors.New("缺少导入文件")
+	}
+	if path == "-" {
+		return read(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return read(f)
+}
+
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func printIPDiff(diff rules.IPListDiff, dryRun bool) {
+	for _, entry := range diff.Added {
+		fmt.Printf("+ %s %s\n", entry.IP, entry.Comment)
+	}
+	for _, entry := range diff.Removed {
+		fmt.Printf("- %s %s\n", entry.IP, entry.Comment)
+	}
+	for _, entry := range diff.Changed {
+		fmt.Printf("~ %s %s\n", entry.IP, entry.Comment)
+	}
+	printSummary(len(diff.Added), len(diff.Removed), len(diff.Changed), dryRun)
+}
+
+func printRulesDiff(diff rules.RulesDiff, dryRun bool) {
+	for _, pattern := range diff.Added {
+		fmt.Printf("+ %s\n", pattern.Name)
+	}
+	for _, pattern := range diff.Removed {
+		fmt.Printf("- %s\n", pattern.Name)
+	}
+	for _, pattern := range diff.Changed {
+		fmt.Printf("~ %s\n", pattern.Name)
+	}
+	printSummary(len(diff.Added), len(diff.Removed), len(diff.Changed), dryRun)
+}
+
+func printTestResults(results []rules.TestResult) {
+	for i, result := range results {
+		verdict := "放行"
+		switch {
+		case result.Oversize:
+			verdict = "拦截（请求体过大）"
+		case result.Blocked:
+			verdict = "拦截（" + result.Rule + "）"
+		}
+		fmt.Printf("#%d %s %s -> %s，评分 %d\n", i+1, result.Method, result.URL, verdict, result.Score)
+		for _, match := range result.Matches {
+			fmt.Printf("  %s @ %s: %q\n", match.Rule, match.Location, match.Snippet)
+		}
+	}
+}
+
+func printSummary(added, removed, changed int, dryRun bool) {
+	fmt.Printf("新增 %d，删除 %d，修改 %d\n", added, removed, changed)
+	if dryRun {
+		fmt.Println("dry-run：未写入任何修改")
+	} else {
+		fmt.Println("已写入MongoDB，运行中的实例会自动重新加载")
+	}
+}
@@ -15,13 +15,69 @@ type Config struct {
 }
 
 type ServerConfig struct {
-	Port int `bson:"port"`
+	Port     int           `bson:"port"`
+	TLS      TLSConfig     `bson:"tls"`
+	Timeouts TimeoutConfig `bson:"timeouts"`
+}
+
+// TimeoutConfig 代理的超时设置（秒），0 使用默认值，修改后需要重启
+type TimeoutConfig struct {
+	ReadHeader int `bson:"readheader"` // 读取请求头
+	Read       int `bson:"read"`       // 读取整个请求，包括请求体
+	Write      int `bson:"write"`      // 从读完请求头到写完响应，默认不限制
+	Idle       int `bson:"idle"`       // 客户端长连接的空闲时间
+	Dial       int `bson:"dial"`       // 连接上游，包括TLS握手
+	Upstream   int `bson:"upstream"`   // 发送完请求后等待上游响应头
+}
+
+// TLSConfig HTTPS监听配置，Port 为0时不启用；端口和证书目录修改后需要重启，其余配置热加载
+type TLSConfig struct {
+	Port         int      `bson:"port"`
+	CertDir      string   `bson:"certdir"`      // 证书目录，每对 <name>.crt 和 <name>.key 为一个证书，MongoDB中的证书优先
+	DefaultHost  string   `bson:"defaulthost"`  // 客户端未发送SNI或没有匹配证书时使用的证书主机名
+	MinVersion   string   `bson:"minversion"`   // 允许的最低TLS版本：1.0、1.1、1.2（默认）或 1.3
+	CipherSuites []string `bson:"ciphersuites"` // TLS 1.2及以下使用的加密套件，为空时使用Go的默认值
+
+	// 证书在 ExpiryWarningDays 天内过期时在 /status 中标记，并记录日志和发送到 AlertWebhook
+	ExpiryWarningDays int    `bson:"expirywarningdays"`
+	AlertWebhook      string `bson:"alertwebhook"`
 }
 
 type FirewallConfig struct {
-	Mode          string `bson:"mode"`
-	RulesFile     string `bson:"rulesfile"`
-	TargetAddress string `bson:"targetaddress"`
+	Mode               string `bson:"mode"`
+	RulesFile          string `bson:"rulesfile"`
+	RulesSource        string `bson:"rulessource"`        // 规则来源：database（默认）、file 或 file_seeds_database
+	ReloadPollInterval int    `bson:"reloadpollinterval"` // MongoDB不支持变更流时轮询规则和配置变化的间隔（秒），0 使用默认值
+	TargetAddress      string `bson:"targetaddress"`
+	BlockPageDir       string `bson:"blockpagedir"`     // 站点路由拦截页面所在的目录，为空时使用 blockpages，修改后需要重启
+	AnomalyThreshold   int    `bson:"anomalythreshold"` // 入站异常评分阈值，0 表示第一条命中的规则即拦截
+	BodyMaxDepth       int    `bson:"bodymaxdepth"`     // JSON包体最大嵌套深度，0 使用默认值
+	BodyMaxFields      int    `bson:"bodymaxfields"`    // 包体字段总数上限，0 使用默认值
+
+	// 请求体大小限制：MaxBodySize 为上限，InspectSize 为检测窗口，OversizeAction 为超过上限时的动作（block 或 pass）
+	MaxBodySize    int64           `bson:"maxbodysize"`
+	InspectSize    int64           `bson:"inspectsize"`
+	OversizeAction string          `bson:"oversizeaction"`
+	BodySizeLimits []BodySizeLimit `bson:"bodysizelimits"` // 按Host和路径前缀覆盖的限制
+
+	RateLimitBackend string `bson:"ratelimitbackend"` // 限流计数的存储方式：memory（默认）或 redis，多实例部署时使用 redis 共享计数
+
+	// 自动封禁：BanWindow 秒内被拦截 BanThreshold 次的IP封禁 BanDuration 秒，
+	// BanHistory 秒内再次被封禁时时长逐次翻倍，最长 BanMaxDuration 秒；BanThreshold 为0时关闭
+	BanThreshold   int `bson:"banthreshold"`
+	BanWindow      int `bson:"banwindow"`
+	BanDuration    int `bson:"banduration"`
+	BanMaxDuration int `bson:"banmaxduration"`
+	BanHistory     int `bson:"banhistory"`
+}
+
+// BodySizeLimit 按Host和路径前缀设置的请求体大小限制，未设置的项沿用全局值
+type BodySizeLimit struct {
+	Host        string `bson:"host"`
+	PathPrefix  string `bson:"pathprefix"`
+	MaxBodySize int64  `bson:"maxbodysize"`
+	InspectSize int64  `bson:"inspectsize"`
+	Action      string `bson:"action"`
 }
 
 var mongoCollection *mongo.Collection
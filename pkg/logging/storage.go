@@ -57,6 +57,11 @@ func InitStorage(redisAddr, mongoURI, mongoDB, mongoCollectionName string) error
 	return nil
 }
 
+// RedisClient 返回已初始化的Redis客户端，未初始化时返回nil
+func RedisClient() *redis.Client {
+	return redisClient
+}
+
 // LogTraffic 保存流量日志到Redis和MongoDB
 func LogTraffic(logData map[string]interface{}) error {
 	// 确保 Redis 和 MongoDB 客户端已初始化
@@ -88,8 +93,49 @@ func LogTraffic(logData map[string]interface{}) error {
 	return nil
 }
 
+// TrafficLog 回放流量日志时使用的字段，日志中不保存请求体
+type TrafficLog struct {
+	Timestamp time.Time           `bson:"timestamp"`
+	ClientIP  string              `bson:"client_ip"`
+	URL       string              `bson:"url"`
+	Method    string              `bson:"method"`
+	Headers   map[string][]string `bson:"headers"`
+	Status    string              `bson:"status"`
+	Error     string              `bson:"error"`
+	Rule      string              `bson:"rule"`
+	Blocked   bool                `bson:"blocked"` // 被拦截规则拦截
+}
+
+// ForEachTrafficLog 按时间顺序遍历时间范围内的流量日志，limit 大于0时最多遍历 limit 条，fn 返回错误时停止
+func ForEachTrafficLog(ctx context.Context, start, end time.Time, limit int64, fn func(TrafficLog) error) error {
+	filter := bson.M{"timestamp": bson.M{"$gte": start, "$lte": end}}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetProjection(bson.M{"timestamp": 1, "client_ip": 1, "url": 1, "method": 1, "headers": 1, "status": 1, "error": 1, "rule": 1, "blocked": 1})
+	if limit > 0 {
+		findOptions.SetLimit(limit)
+	}
+
+	cursor, err := mongoCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("检索日志失败: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var entry TrafficLog
+		if err := cursor.Decode(&entry); err != nil {
+			return fmt.Errorf("解析日志失败: %v", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
 // FetchLogsFromMongoWithFilters 从MongoDB中检索日志，支持过滤和分页
-func FetchLogsFromMongoWithFilters(ctx context.Context, page, pageSize int, startDateTime, endDateTime time.Time, ip, status string) ([]bson.M, int64, error) {
+func FetchLogsFromMongoWithFilters(ctx context.Context, page, pageSize int, startDateTime, endDateTime time.Time, ip, status, rule string) ([]bson.M, int64, error) {
 	// 构建过滤条件
 	filter := bson.D{}
 
@@ -116,9 +162,21 @@ func FetchLogsFromMongoWithFilters(ctx context.Context, page, pageSize int, star
 			filter = append(filter, bson.E{"status", bson.M{"$ne": "success"}})
 		} else if status == "passed" {
 			filter = append(filter, bson.E{"status", "success"})
+		} else if status == "detected" {
+			filter = append(filter, bson.E{Key: "detected", Value: true})
 		}
 	}
 
+	// 添加规则过滤条件
+	if rule != "" {
+		filter = append(filter, bson.E{Key: "$or", Value: bson.A{
+			bson.M{"rule": rule},
+			bson.M{"matched_rules.rule": rule},
+			bson.M{"detections.rule": rule},
+			bson.M{"rate_limit": rule},
+		}})
+	}
+
 	// 计算总记录数
 	totalCount, err := mongoCollection.CountDocuments(ctx, filter)
 	if err != nil {
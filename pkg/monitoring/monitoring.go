@@ -7,6 +7,7 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -17,10 +18,30 @@ func SetMongoCollection(collection *mongo.Collection) {
 }
 
 type DailyMetrics struct {
-	Date                    time.Time `bson:"date"`
-	WebsiteRequestsTotal    int       `bson:"websiteRequestsTotal"`
-	BlockedByBlacklistTotal int       `bson:"blockedByBlacklistTotal"`
-	BlockedByRulesTotal     int       `bson:"blockedByRulesTotal"`
+	Date                    time.Time      `bson:"date"`
+	WebsiteRequestsTotal    int            `bson:"websiteRequestsTotal"`
+	BlockedByBlacklistTotal int            `bson:"blockedByBlacklistTotal"`
+	BlockedByRulesTotal     int            `bson:"blockedByRulesTotal"`
+	WouldBlockByRulesTotal  int            `bson:"wouldBlockByRulesTotal"` // 命中规则但仅记录的请求数
+	BlockedByBodySizeTotal  int            `bson:"blockedByBodySizeTotal"` // 请求体超过大小上限被拒绝的请求数
+	RateLimitedTotal        int            `bson:"rateLimitedTotal"`       // 触发限流被拒绝的请求数
+	BlockedByBanTotal       int            `bson:"blockedByBanTotal"`      // 被自动封禁的IP发出的请求数
+	NoUpstreamTotal         int            `bson:"noUpstreamTotal"`        // 路由的全部上游都不可用而失败的请求数
+	RuleHits                map[string]int `bson:"ruleHits"`               // 按规则名称统计的拦截次数
+	RuleDetections          map[string]int `bson:"ruleDetections"`         // 按规则名称统计的仅记录命中次数
+}
+
+// ruleKeyReplacer MongoDB字段名不能包含 "." 或以 "$" 开头，规则名称中的这些字符替换为 "_"
+var ruleKeyReplacer = strings.NewReplacer(".", "_", "$", "_")
+
+// IncrementRuleHit 增加指定规则当天的命中次数
+func IncrementRuleHit(rule string) error {
+	return IncrementMetric("ruleHits." + ruleKeyReplacer.Replace(rule))
+}
+
+// IncrementRuleDetection 增加指定规则当天仅记录（本应拦截）的命中次数
+func IncrementRuleDetection(rule string) error {
+	return IncrementMetric("ruleDetections." + ruleKeyReplacer.Replace(rule))
 }
 
 func IncrementMetric(metric string) error {
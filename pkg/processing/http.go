@@ -1,114 +1,209 @@
 package processing
 
 import (
+	"Stone/pkg/bans"
+	"Stone/pkg/logging"
 	"Stone/pkg/monitoring"
+	"Stone/pkg/ratelimit"
+	"Stone/pkg/routing"
 	"Stone/pkg/rules"
 	"Stone/pkg/utils"
-	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 )
 
-// HandleHTTPConnection 处理HTTP连接
-func HandleHTTPConnection(clientConn net.Conn, targetAddress string) {
-	defer clientConn.Close()
+// Handler 检查请求并转发到站点路由的上游或默认目标地址
+type Handler struct {
+	targetAddress string
+}
+
+// NewHandler 创建处理HTTP请求的Handler，没有匹配的站点路由时转发到 targetAddress
+func NewHandler(targetAddress string) *Handler {
+	return &Handler{targetAddress: targetAddress}
+}
+
+// ServeHTTP 处理一个HTTP请求，连接的读取、长连接和超时由 http.Server 管理
+func (h *Handler) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	targetAddress := h.targetAddress
 
 	// 获取客户端IP
-	clientIP, _, _ := net.SplitHostPort(clientConn.RemoteAddr().String())
+	clientIP, _, _ := net.SplitHostPort(request.RemoteAddr)
 
 	// 尝试将IPv6地址转换为IPv4地址
 	clientIP = convertIPv6ToIPv4(clientIP)
 
-	// 创建bufio.Reader
-	reader := bufio.NewReader(clientConn)
+	// 按Host和路径选择站点路由，没有匹配的路由时使用默认目标地址和全部拦截规则
+	target, blockPage := targetAddress, routing.DefaultBlockPage
+	var upstream *routing.Server
+	var upstreamErr error
+	route := routing.Match(request.Host, request.URL.Path)
+	if route != nil {
+		blockPage = route.BlockedPage()
+		if upstream, upstreamErr = route.Pick(clientIP); upstreamErr == nil {
+			target = upstream.Address
+		} else {
+			target = ""
+		}
+	}
 
-	// 创建HTTP客户端
-	client := &http.Client{}
+	// 检查IP是否在黑名单
+	allowed, inWhitelist := rules.IsAllowed(clientIP)
+	if !allowed {
+		logging.LogInfo(fmt.Sprintf("IP在黑名单中，连接已阻断: %s", clientIP))
+		utils.LogTraffic(clientIP, target, request.URL.String(), request.Method, request.Header, "", "IP在黑名单中")
+		monitoring.IncrementMetric("blockedByBlacklistTotal")
+		writeBlockedResponse(w, blockPage)
+		return
+	}
 
-	for {
-		// 读取客户端请求
-		request, err := http.ReadRequest(reader)
-		if err != nil {
-			if err != io.EOF {
-				fmt.Println("读取HTTP请求失败:", err)
-			}
-			utils.LogTraffic(clientIP, targetAddress, "", "", nil, "", err.Error())
+	// 站点路由的IP策略在全局黑名单之后检查，对白名单IP同样生效
+	if route != nil && !route.CheckIP(clientIP) {
+		logging.LogInfo(fmt.Sprintf("IP不符合站点路由 %s 的IP策略，连接已阻断: %s", route.Name, clientIP))
+		utils.LogTrafficWithDetails(clientIP, target, request.URL.String(), request.Method, request.Header, "", "Blocked by route IP policy",
+			map[string]interface{}{"route": route.Name})
+		monitoring.IncrementMetric("blockedByBlacklistTotal")
+		writeBlockedResponse(w, blockPage)
+		return
+	}
+
+	// 如果IP不在白名单，检查自动封禁和限流规则，再进行URL和包体检查
+	var details map[string]interface{}
+	var bodyBuffered bool
+	if !inWhitelist {
+		if ban, banned := bans.IsBanned(clientIP); banned {
+			logging.LogInfo(fmt.Sprintf("IP已被临时封禁，连接已阻断: %s", clientIP))
+			utils.LogTrafficWithDetails(clientIP, target, request.URL.String(), request.Method, request.Header, "", "IP temporarily banned",
+				map[string]interface{}{"ban_reason": ban.Reason, "ban_expires_at": ban.ExpiresAt})
+			monitoring.IncrementMetric("blockedByBanTotal")
+			writeBlockedResponse(w, blockPage)
 			return
 		}
 
-		// 检查IP是否在黑名单
-		allowed, inWhitelist := rules.IsAllowed(clientIP)
-		if !allowed {
-			fmt.Printf("IP在黑名单中，连接已阻断: %s\n", clientIP)
-			utils.LogTraffic(clientIP, targetAddress, request.URL.String(), request.Method, request.Header, "", "IP在黑名单中")
-			monitoring.IncrementMetric("blockedByBlacklistTotal")
-			sendBlockedResponse(clientConn, "blocked.html")
+		if decision := ratelimit.Check(request, clientIP); decision != nil {
+			logging.LogInfo(fmt.Sprintf("触发限流规则 %s，连接已阻断: %s", decision.Rule, clientIP))
+			recordBlock(clientIP, "rate limit: "+decision.Rule)
+			utils.LogTrafficWithDetails(clientIP, target, request.URL.String(), request.Method, request.Header, "", "Rate limited",
+				map[string]interface{}{"rate_limit": decision.Rule, "retry_after": retryAfterSeconds(decision.RetryAfter)})
+			monitoring.IncrementMetric("rateLimitedTotal")
+			writeStatusResponse(w, http.StatusTooManyRequests,
+				map[string]string{"Retry-After": strconv.Itoa(retryAfterSeconds(decision.RetryAfter))})
 			return
 		}
 
-		// 如果IP不在白名单，进行URL和包体检查
-		if !inWhitelist && !rules.CheckRequest(request) {
-			fmt.Println("检测到危险请求，连接已阻断")
-			utils.LogTraffic(clientIP, targetAddress, request.URL.String(), request.Method, request.Header, "", "Blocked by rules")
-			monitoring.IncrementMetric("blockedByRulesTotal")
-			sendBlockedResponse(clientConn, "blocked.html")
+		var result rules.Result
+		if route != nil {
+			result = rules.CheckRequestWith(request, route.Ruleset())
+		} else {
+			result = rules.CheckRequest(request)
+		}
+		if result.Oversize {
+			logging.LogInfo("请求体超过大小上限，连接已阻断")
+			utils.LogTraffic(clientIP, target, request.URL.String(), request.Method, request.Header, "", "Request body too large")
+			monitoring.IncrementMetric("blockedByBodySizeTotal")
+			writeStatusResponse(w, http.StatusRequestEntityTooLarge, nil)
 			return
 		}
 
-		// 设置目标地址
-		request.URL.Scheme = "http"
-		request.URL.Host = targetAddress
-		request.RequestURI = ""
+		bodyBuffered = !result.PartiallyInspected
+		details = map[string]interface{}{}
+		if route != nil {
+			details["route"] = route.Name
+		}
+		if len(result.Matches) > 0 {
+			details["anomaly_score"] = result.Score
+			details["matched_rules"] = result.Matches
+		}
+		if result.PartiallyInspected {
+			details["partially_inspected"] = true
+			details["inspected_bytes"] = result.InspectedBytes
+		}
 
-		// 发送请求到目标服务
-		response, err := client.Do(request)
-		if err != nil {
-			fmt.Println("发送请求到目标服务失败:", err)
-			utils.LogTraffic(clientIP, targetAddress, request.URL.String(), request.Method, request.Header, "", err.Error())
+		if result.Blocked {
+			logging.LogInfo("检测到危险请求，连接已阻断")
+			reason := "rules"
+			if result.Match != nil {
+				details["rule"] = result.Match.Rule
+				details["match_location"] = result.Match.Location
+				details["match_snippet"] = result.Match.Snippet
+				reason = "rule: " + result.Match.Rule
+			}
+			details["blocked"] = true // 回放时据此判断请求原先是否被规则拦截
+			for _, match := range result.Matches {
+				monitoring.IncrementRuleHit(match.Rule)
+			}
+			recordBlock(clientIP, reason)
+			utils.LogTrafficWithDetails(clientIP, target, request.URL.String(), request.Method, request.Header, "", rules.BlockedLogMessage, details)
+			monitoring.IncrementMetric("blockedByRulesTotal")
+			writeBlockedResponse(w, blockPage)
 			return
 		}
 
-		// 将响应写回客户端
-		if err := response.Write(clientConn); err != nil {
-			fmt.Println("写回客户端失败:", err)
-			response.Body.Close()
-			utils.LogTraffic(clientIP, targetAddress, request.URL.String(), request.Method, request.Header, "", err.Error())
+		// 仅记录的命中：请求继续转发，日志和指标中记为"本应拦截"
+		if len(result.Detections) > 0 {
+			details["detected"] = true
+			details["detections"] = result.Detections
+			monitoring.IncrementMetric("wouldBlockByRulesTotal")
+			for _, detection := range result.Detections {
+				monitoring.IncrementRuleDetection(detection.Rule)
+			}
+		}
+	}
+
+	// 路由的全部上游都不可用
+	if upstreamErr != nil {
+		logging.LogInfo(fmt.Sprintf("发送请求到目标服务失败: %v", upstreamErr))
+		utils.LogTrafficWithDetails(clientIP, target, request.URL.String(), request.Method, request.Header, "", upstreamErr.Error(), details)
+		monitoring.IncrementMetric("noUpstreamTotal")
+		writeStatusResponse(w, http.StatusBadGateway, nil)
+		return
+	}
+
+	// 转发到目标服务，由 ReverseProxy 处理逐跳头部、100-continue、分块编码和trailer
+	scheme := "http"
+	if route != nil {
+		scheme = route.Scheme()
+		route.Begin(upstream)
+	}
+	state := &proxyState{route: route, upstream: upstream, target: target, scheme: scheme, bodyBuffered: bodyBuffered}
+	// 在延迟调用中记录转发结果，上游响应中断时 forwardRequest 不会正常返回
+	defer func() {
+		if state.err != nil {
+			logging.LogInfo(fmt.Sprintf("发送请求到目标服务失败: %v", state.err))
+			utils.LogTraffic(clientIP, target, state.url, request.Method, request.Header, "", state.err.Error())
 			return
 		}
 
 		// 请求成功，更新访问计数
-		err = monitoring.IncrementMetric("websiteRequestsTotal")
+		err := monitoring.IncrementMetric("websiteRequestsTotal")
 		if err != nil {
 			log.Printf("Failed to increment websiteRequestsTotal: %v", err)
 		}
-		utils.LogTraffic(clientIP, targetAddress, request.URL.String(), request.Method, request.Header, "", "")
-
-		// 关闭响应体
-		response.Body.Close()
-
-		// 检查是否需要保持连接
-		if !response.Close && response.Header.Get("Connection") != "close" {
-			continue
-		}
-		break
-	}
+		utils.LogTrafficWithDetails(clientIP, target, state.url, request.Method, request.Header, "", "", details)
+	}()
+	forwardRequest(w, request, state)
 }
 
-func sendBlockedResponse(conn net.Conn, filePath string) {
+// writeBlockedResponse 返回拦截页面，状态码随机，并在页面中插入随机长度的注释，随后关闭连接
+func writeBlockedResponse(w http.ResponseWriter, filePath string) {
 	// 读取HTML文件内容
 	htmlContent, err := os.ReadFile(filePath)
 	if err != nil {
-		fmt.Println("无法读取被阻断响应文件:", err)
+		logging.LogInfo(fmt.Sprintf("无法读取被阻断响应文件: %v", err))
+		writeStatusResponse(w, http.StatusForbidden, nil)
 		return
 	}
 
-	// 生成随机状态码（200到503之间）
+	// 生成随机状态码（200到503之间），204和304不能带响应体，改用200
 	randomStatusCode := rand.Intn(304) + 200
+	if randomStatusCode == http.StatusNoContent || randomStatusCode == http.StatusNotModified {
+		randomStatusCode = http.StatusOK
+	}
 
 	// 生成随机长度的随机字符串（5000到10000个字符）
 	randomLength := rand.Intn(5001) + 5000
@@ -120,21 +215,45 @@ func sendBlockedResponse(conn net.Conn, filePath string) {
 	// 将随机字符串作为HTML注释插入到HTML内容中
 	htmlWithRandomString := []byte(fmt.Sprintf("%s\n<!-- %s -->", htmlContent, randomString))
 
-	// 构造响应
-	response := fmt.Sprintf("HTTP/1.1 %d \r\n"+
-		"Content-Type: text/html; charset=UTF-8\r\n"+
-		"Content-Length: %d\r\n"+
-		"\r\n"+
-		"%s",
-		randomStatusCode,
-		len(htmlWithRandomString),
-		htmlWithRandomString)
-
 	// 发送响应
-	_, err = conn.Write([]byte(response))
-	if err != nil {
-		fmt.Println("写回被阻断响应失败:", err)
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(htmlWithRandomString)))
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(randomStatusCode)
+	if _, err := w.Write(htmlWithRandomString); err != nil {
+		logging.LogInfo(fmt.Sprintf("写回被阻断响应失败: %v", err))
+	}
+}
+
+// writeStatusResponse 返回不带响应体的状态码并关闭连接，headers 为额外的响应头
+func writeStatusResponse(w http.ResponseWriter, statusCode int, headers map[string]string) {
+	for name, value := range headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Content-Length", "0")
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(statusCode)
+}
+
+// isGatewayError 判断上游是否返回了表示自身不可用的网关错误
+func isGatewayError(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout
+}
+
+// recordBlock 记录一次拦截，达到自动封禁阈值时封禁该IP
+func recordBlock(clientIP, reason string) {
+	if _, err := bans.RecordBlock(clientIP, reason); err != nil {
+		log.Printf("记录拦截失败: %v", err)
+	}
+}
+
+// retryAfterSeconds 将等待时间向上取整为Retry-After使用的秒数，至少为1秒
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
 	}
+	return seconds
 }
 
 // 新增函数: 尝试将IPv6地址转换为IPv4地址
@@ -0,0 +1,204 @@
+// pkg/processing/proxy.go
+
+package processing
+
+import (
+	"Stone/pkg/logging"
+	"Stone/pkg/monitoring"
+	"Stone/pkg/routing"
+	"Stone/pkg/rules"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts 代理的超时设置，为0的项使用默认值
+type Timeouts struct {
+	ReadHeader time.Duration // 读取请求头
+	Read       time.Duration // 读取整个请求，包括请求体
+	Write      time.Duration // 从读完请求头到写完响应，默认不限制
+	Idle       time.Duration // 客户端长连接的空闲时间
+	Dial       time.Duration // 连接上游
+	Upstream   time.Duration // 发送完请求后等待上游响应头
+}
+
+// 默认超时
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultReadTimeout       = 60 * time.Second
+	DefaultWriteTimeout      = 0 * time.Second // 不限制，避免大文件下载和长时间的流式响应被中断
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultDialTimeout       = 10 * time.Second
+	DefaultUpstreamTimeout   = 60 * time.Second
+)
+
+// 上游连接池的大小
+const (
+	maxIdleConns        = 1000
+	maxIdleConnsPerHost = 100
+)
+
+// withDefaults 将为0的项替换为默认值
+func (t Timeouts) withDefaults() Timeouts {
+	defaults := []struct {
+		value *time.Duration
+		def   time.Duration
+	}{
+		{&t.ReadHeader, DefaultReadHeaderTimeout},
+		{&t.Read, DefaultReadTimeout},
+		{&t.Write, DefaultWriteTimeout},
+		{&t.Idle, DefaultIdleTimeout},
+		{&t.Dial, DefaultDialTimeout},
+		{&t.Upstream, DefaultUpstreamTimeout},
+	}
+	for _, d := range defaults {
+		if *d.value <= 0 {
+			*d.value = d.def
+		}
+	}
+	return t
+}
+
+var (
+	timeouts  atomic.Pointer[Timeouts]
+	transport atomic.Pointer[http.Transport] // 所有以HTTP连接上游的请求共享的连接池
+)
+
+func init() {
+	Configure(Timeouts{})
+}
+
+// Configure 设置超时并重建共享的上游连接池，站点路由的HTTPS上游使用相同的连接池参数
+// 需要在加载站点路由和启动监听之前调用，服务器的超时在启动监听时确定
+func Configure(t Timeouts) {
+	t = t.withDefaults()
+	timeouts.Store(&t)
+
+	dialer := &net.Dialer{Timeout: t.Dial, KeepAlive: 30 * time.Second}
+	shared := &http.Transport{
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   t.Dial,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: t.Upstream,
+	}
+	if previous := transport.Swap(shared); previous != nil {
+		previous.CloseIdleConnections()
+	}
+	routing.SetBaseTransport(shared)
+}
+
+// NewServer 创建处理HTTP请求的服务器，使用 Configure 设置的超时
+func NewServer(targetAddress string) *http.Server {
+	t := timeouts.Load()
+	return &http.Server{
+		Handler:           NewHandler(targetAddress),
+		ReadHeaderTimeout: t.ReadHeader,
+		ReadTimeout:       t.Read,
+		WriteTimeout:      t.Write,
+		IdleTimeout:       t.Idle,
+		ErrorLog:          logging.ErrorLogger,
+	}
+}
+
+// proxyContextKey 在转发请求的上下文中保存 proxyState
+type proxyContextKey struct{}
+
+// errResponseAborted 上游响应在转发给客户端的过程中中断
+var errResponseAborted = errors.New("转发上游响应时连接中断")
+
+// proxyState 一次转发的目标和结果
+type proxyState struct {
+	route        *routing.CompiledRoute // 没有匹配的站点路由时为nil
+	upstream     *routing.Server
+	target       string
+	scheme       string
+	bodyBuffered bool   // 请求体已在检查规则时完整读取
+	url          string // 转发到上游的URL
+	err          error  // 转发失败的原因
+	released     bool
+}
+
+// release 转发结束后更新上游的连接数和连续失败次数，只生效一次
+func (s *proxyState) release(failed bool) {
+	if s.released || s.upstream == nil {
+		return
+	}
+	s.released = true
+	s.route.Done(s.upstream, failed)
+}
+
+// proxy 所有请求共享的反向代理，不跟随重定向，按路由选择连接上游的 Transport
+var proxy = &httputil.ReverseProxy{
+	Rewrite: func(pr *httputil.ProxyRequest) {
+		state := pr.In.Context().Value(proxyContextKey{}).(*proxyState)
+		// 保留客户端请求的Host，上游按原始主机名处理请求
+		pr.Out.URL.Scheme = state.scheme
+		pr.Out.URL.Host = state.target
+		// 请求体已完整读取时不再等待上游的100 Continue；白名单IP和超出检测窗口的请求体以流的方式转发，保留Expect
+		if state.bodyBuffered {
+			pr.Out.Header.Del("Expect")
+		}
+		pr.SetXForwarded()
+		state.url = pr.Out.URL.String()
+	},
+	Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		state := req.Context().Value(proxyContextKey{}).(*proxyState)
+		if state.route != nil {
+			if rt := state.route.Transport(); rt != nil {
+				return rt.RoundTrip(req)
+			}
+		}
+		return transport.Load().RoundTrip(req)
+	}),
+	ModifyResponse: func(resp *http.Response) error {
+		state := resp.Request.Context().Value(proxyContextKey{}).(*proxyState)
+		state.release(isGatewayError(resp.StatusCode))
+		return nil
+	},
+	ErrorLog: logging.ErrorLogger,
+	ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+		state := req.Context().Value(proxyContextKey{}).(*proxyState)
+		state.err = err
+		// 未声明Content-Length的请求体在转发过程中超过上限
+		if errors.Is(err, rules.ErrBodyTooLarge) {
+			state.release(false)
+			monitoring.IncrementMetric("blockedByBodySizeTotal")
+			writeStatusResponse(w, http.StatusRequestEntityTooLarge, nil)
+			return
+		}
+		// 客户端断开不计入上游的失败次数
+		state.release(!errors.Is(err, context.Canceled))
+		writeStatusResponse(w, http.StatusBadGateway, nil)
+	},
+}
+
+// roundTripper 将函数用作 http.RoundTripper
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (f roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// forwardRequest 将请求转发到 state 指定的目标
+// 已写出响应头后复制响应体失败时 ReverseProxy 以 http.ErrAbortHandler 中断处理，这里记录到 state.err 后继续中断，
+// 调用方需要在延迟调用中记录转发结果
+func forwardRequest(w http.ResponseWriter, request *http.Request, state *proxyState) {
+	defer state.release(false)
+	defer func() {
+		if err := recover(); err != nil {
+			if err == http.ErrAbortHandler && state.err == nil {
+				state.err = errResponseAborted
+			}
+			panic(err)
+		}
+	}()
+	ctx := context.WithValue(request.Context(), proxyContextKey{}, state)
+	proxy.ServeHTTP(w, request.WithContext(ctx))
+}
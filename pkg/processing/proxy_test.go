@@ -0,0 +1,201 @@
+package processing
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// received 上游收到的请求
+type received struct {
+	host, uri, expect string
+	forwardedFor      string
+	forwardedHost     string
+	forwardedProto    string
+	hopByHop, body    string
+}
+
+// newBackend 启动记录请求的上游，返回地址和最近一次收到的请求
+func newBackend(t *testing.T) (string, func() received) {
+	t.Helper()
+	var mutex sync.Mutex
+	var last received
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		last = received{
+			host:           r.Host,
+			uri:            r.URL.RequestURI(),
+			expect:         r.Header.Get("Expect"),
+			forwardedFor:   r.Header.Get("X-Forwarded-For"),
+			forwardedHost:  r.Header.Get("X-Forwarded-Host"),
+			forwardedProto: r.Header.Get("X-Forwarded-Proto"),
+			hopByHop:       r.Header.Get("X-Hop"),
+			body:           string(body),
+		}
+		mutex.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(backend.Close)
+	return strings.TrimPrefix(backend.URL, "http://"), func() received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return last
+	}
+}
+
+// send 通过 handler 处理客户端发往 www.example.com 的请求，返回客户端收到的状态码
+func send(t *testing.T, handler http.Handler, method, target, body string, headers map[string]string) int {
+	t.Helper()
+	frontend := httptest.NewServer(handler)
+	defer frontend.Close()
+	req, err := http.NewRequest(method, frontend.URL+target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "www.example.com"
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := frontend.Client().Do(req)
+	if err != nil {
+		t.Fatalf("发送请求失败: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestProxyRewrite(t *testing.T) {
+	address, last := newBackend(t)
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		headers  map[string]string
+		buffered bool
+		status   int
+		url      string // 转发到上游的URL
+		expect   string // 上游收到的Expect
+	}{
+		{
+			name:    "保留Host、路径和查询参数",
+			method:  http.MethodGet,
+			target:  "/a/b?x=1&y=%2F",
+			headers: map[string]string{"X-Forwarded-For": "6.6.6.6", "Connection": "X-Hop", "X-Hop": "1"},
+			status:  http.StatusCreated,
+			url:     "http://" + address + "/a/b?x=1&y=%2F",
+		},
+		{
+			name:     "请求体已完整读取时去掉Expect",
+			method:   http.MethodPost,
+			target:   "/upload",
+			body:     "name=stone",
+			headers:  map[string]string{"Expect": "100-continue"},
+			buffered: true,
+			status:   http.StatusCreated,
+			url:      "http://" + address + "/upload",
+		},
+		{
+			name:    "流式转发的请求体保留Expect",
+			method:  http.MethodPost,
+			target:  "/upload",
+			body:    "name=stone",
+			headers: map[string]string{"Expect": "100-continue"},
+			status:  http.StatusCreated,
+			url:     "http://" + address + "/upload",
+			expect:  "100-continue",
+		},
+	}
+	for _, tt := range tests {
+		state := &proxyState{target: address, scheme: "http", bodyBuffered: tt.buffered}
+		status := send(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardRequest(w, r, state)
+		}), tt.method, tt.target, tt.body, tt.headers)
+
+		if status != tt.status || state.err != nil || state.url != tt.url {
+			t.Errorf("%s: 状态码 %d(%v) URL %s，期望 %d %s", tt.name, status, state.err, state.url, tt.status, tt.url)
+			continue
+		}
+		got := last()
+		if got.host != "www.example.com" || got.uri != strings.TrimPrefix(tt.url, "http://"+address) || got.body != tt.body {
+			t.Errorf("%s: 上游收到 %s%s 请求体 %q", tt.name, got.host, got.uri, got.body)
+		}
+		// 客户端伪造的X-Forwarded-For被替换，Connection中列出的逐跳头部不转发
+		if got.forwardedFor != "127.0.0.1" || got.forwardedHost != "www.example.com" || got.forwardedProto != "http" || got.hopByHop != "" {
+			t.Errorf("%s: 转发头部 %+v", tt.name, got)
+		}
+		if got.expect != tt.expect {
+			t.Errorf("%s: 上游收到Expect %q，期望 %q", tt.name, got.expect, tt.expect)
+		}
+	}
+}
+
+func TestProxyUpstreamError(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	w := httptest.NewRecorder()
+	state := &proxyState{target: "127.0.0.1:1", scheme: "http"}
+	forwardRequest(w, req, state)
+	if w.Code != http.StatusBadGateway || state.err == nil || w.Header().Get("Connection") != "close" {
+		t.Errorf("上游不可用时返回 %d(%v)，期望 502 并关闭连接", w.Code, state.err)
+	}
+}
+
+func TestHandlerExpect(t *testing.T) {
+	address, last := newBackend(t)
+	handler := NewHandler(address)
+
+	tests := []struct {
+		name   string
+		size   int
+		expect string
+	}{
+		{name: "检测窗口内的请求体", size: 1 << 10},
+		{name: "超出检测窗口的请求体", size: 256 << 10, expect: "100-continue"},
+	}
+	for _, tt := range tests {
+		status := send(t, handler, http.MethodPost, "/upload", strings.Repeat("a", tt.size), map[string]string{"Expect": "100-continue"})
+		got := last()
+		if status != http.StatusCreated || len(got.body) != tt.size {
+			t.Errorf("%s: 状态码 %d，上游收到 %d 字节，期望 %d 字节", tt.name, status, len(got.body), tt.size)
+		}
+		if got.expect != tt.expect {
+			t.Errorf("%s: 上游收到Expect %q，期望 %q", tt.name, got.expect, tt.expect)
+		}
+	}
+}
+
+func TestProxyResponseAborted(t *testing.T) {
+	// 上游声明的长度大于实际写出的响应体，复制响应体时连接中断
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+	}))
+	defer backend.Close()
+
+	recorded := make(chan error, 1)
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := &proxyState{target: strings.TrimPrefix(backend.URL, "http://"), scheme: "http"}
+		defer func() { recorded <- state.err }()
+		forwardRequest(w, r, state)
+	}))
+	defer frontend.Close()
+
+	// 响应头可能尚未发出，客户端在读取响应头或响应体时失败
+	resp, err := frontend.Client().Get(frontend.URL)
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("响应中断时客户端应读取失败")
+	}
+	if err := <-recorded; !errors.Is(err, errResponseAborted) {
+		t.Errorf("延迟调用中记录的转发结果 %v，期望 errResponseAborted", err)
+	}
+}
@@ -0,0 +1,343 @@
+// pkg/ratelimit/ratelimit.go
+
+package ratelimit
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidRule 限流规则无效
+	ErrInvalidRule = errors.New("无效的限流规则")
+	// ErrRuleNotFound 要删除的限流规则不存在
+	ErrRuleNotFound = errors.New("限流规则不存在")
+)
+
+// 限流算法
+const (
+	AlgorithmTokenBucket   = "token_bucket"   // 令牌桶：允许短时突发，长期速率不超过 Limit/Window
+	AlgorithmSlidingWindow = "sliding_window" // 滑动窗口：任意 Window 时间内不超过 Limit 次
+)
+
+// 计数键，header 和 cookie 需要以 "header:名称"、"cookie:名称" 的形式指定
+const (
+	KeyIP     = "ip"     // 客户端IP
+	KeyPath   = "path"   // 路径前缀，匹配该前缀的全部请求共享一个计数
+	KeyHeader = "header" // 请求头的值，例如 "header:X-Api-Key"
+	KeyCookie = "cookie" // Cookie的值，例如 "cookie:session"，用于按登录会话限流
+)
+
+// 计数器的存储方式
+const (
+	BackendMemory = "memory" // 单实例内存计数
+	BackendRedis  = "redis"  // Redis计数，多个实例共享限流
+)
+
+// Rule 限流规则
+type Rule struct {
+	Name       string `bson:"name" json:"name"`
+	Key        string `bson:"key" json:"key"`                                    // 计数键：ip、path、header:名称 或 cookie:名称
+	PathPrefix string `bson:"pathprefix,omitempty" json:"path_prefix,omitempty"` // 只对该路径前缀下的请求生效，按路径段匹配，为空时对全部请求生效
+	Method     string `bson:"method,omitempty" json:"method,omitempty"`          // 只对该请求方法生效，为空时对全部方法生效
+	Algorithm  string `bson:"algorithm,omitempty" json:"algorithm,omitempty"`    // token_bucket（默认）或 sliding_window
+	Limit      int    `bson:"limit" json:"limit"`                                // 窗口内允许的请求数，令牌桶的容量
+	Window     int    `bson:"window" json:"window"`                              // 窗口长度（秒），令牌桶从空到满的时间
+}
+
+// RateLimitRules 用于存储限流规则
+type RateLimitRules struct {
+	Rules []Rule `bson:"rules" json:"rules"`
+}
+
+// Decision 请求被限流时的结果
+type Decision struct {
+	Rule       string        // 触发限流的规则名称
+	RetryAfter time.Duration // 建议客户端重试前等待的时间
+}
+
+var (
+	rateLimitRules  RateLimitRules
+	rulesMutex      sync.RWMutex
+	writeMutex      sync.Mutex // 串行化规则修改及其MongoDB写入，写入时不持有 rulesMutex，请求检查不被阻塞
+	mongoCollection *mongo.Collection
+
+	storeMutex   sync.RWMutex
+	counterStore store = newMemoryStore()
+)
+
+// SetMongoCollection 设置MongoDB集合
+func SetMongoCollection(collection *mongo.Collection) {
+	mongoCollection = collection
+}
+
+// SetBackend 设置计数器的存储方式，redis 模式下未提供客户端时退回内存计数
+// 存储方式不变时保留现有计数，重新加载配置不会重置限流状态
+func SetBackend(backend string, client *redis.Client) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	if backend == BackendRedis && client != nil {
+		if current, ok := counterStore.(*redisStore); !ok || current.client != client {
+			counterStore = &redisStore{client: client}
+		}
+		return
+	}
+	if backend == BackendRedis {
+		logging.LogError(errors.New("未连接Redis，限流改用内存计数"))
+	}
+	if _, ok := counterStore.(*memoryStore); !ok {
+		counterStore = newMemoryStore()
+	}
+}
+
+// ValidateRule 校验限流规则
+func ValidateRule(rule Rule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("%w: 规则名称不能为空", ErrInvalidRule)
+	}
+	if _, err := parseKey(rule.Key); err != nil {
+		return fmt.Errorf("%w: 规则 %q: %v", ErrInvalidRule, rule.Name, err)
+	}
+	if rule.Algorithm != "" && rule.Algorithm != AlgorithmTokenBucket && rule.Algorithm != AlgorithmSlidingWindow {
+		return fmt.Errorf("%w: 规则 %q: 未知的限流算法 %q", ErrInvalidRule, rule.Name, rule.Algorithm)
+	}
+	if rule.Limit <= 0 || rule.Window <= 0 {
+		return fmt.Errorf("%w: 规则 %q: limit 和 window 必须大于0", ErrInvalidRule, rule.Name)
+	}
+	return nil
+}
+
+// ruleKey 解析后的计数键
+type ruleKey struct {
+	kind string
+	name string
+}
+
+func parseKey(spec string) (ruleKey, error) {
+	kind, name, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	kind = strings.ToLower(kind)
+	switch {
+	case (kind == KeyIP || kind == KeyPath) && name == "":
+		return ruleKey{kind: kind}, nil
+	case kind == KeyHeader && name != "":
+		return ruleKey{kind: kind, name: http.CanonicalHeaderKey(name)}, nil
+	case kind == KeyCookie && name != "":
+		return ruleKey{kind: kind, name: name}, nil
+	default:
+		return ruleKey{}, fmt.Errorf("未知的计数键 %q", spec)
+	}
+}
+
+// value 返回请求在该计数键下的取值，请求中没有对应的请求头或Cookie时返回false，规则不生效
+func (k ruleKey) value(req *http.Request, clientIP string) (string, bool) {
+	switch k.kind {
+	case KeyIP:
+		return clientIP, true
+	case KeyPath:
+		return "", true
+	case KeyHeader:
+		value := req.Header.Get(k.name)
+		return hashValue(value), value != ""
+	case KeyCookie:
+		cookie, err := req.Cookie(k.name)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return hashValue(cookie.Value), true
+	}
+	return "", false
+}
+
+// hashValue 请求头和Cookie可能是令牌或会话ID，只以摘要作为计数键，避免明文写入Redis
+func hashValue(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:12])
+}
+
+// Check 按规则顺序检查请求，返回第一个触发限流的规则，未触发时返回nil
+// Redis不可用时放行请求并记录日志，避免限流故障导致整站不可用
+func Check(req *http.Request, clientIP string) *Decision {
+	rulesMutex.RLock()
+	rules := rateLimitRules.Rules
+	rulesMutex.RUnlock()
+
+	storeMutex.RLock()
+	s := counterStore
+	storeMutex.RUnlock()
+
+	now := time.Now()
+	for _, rule := range rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, req.Method) {
+			continue
+		}
+		if !matchPrefix(rule.PathPrefix, req.URL.Path) {
+			continue
+		}
+		key, err := parseKey(rule.Key)
+		if err != nil {
+			continue
+		}
+		value, ok := key.value(req, clientIP)
+		if !ok {
+			continue
+		}
+
+		counter := rule.Name + ":" + value
+		window := time.Duration(rule.Window) * time.Second
+		var allowed bool
+		var retryAfter time.Duration
+		if rule.Algorithm == AlgorithmSlidingWindow {
+			allowed, retryAfter, err = s.slidingWindow(req.Context(), counter, rule.Limit, window, now)
+		} else {
+			allowed, retryAfter, err = s.tokenBucket(req.Context(), counter, rule.Limit, window, now)
+		}
+		if err != nil {
+			logging.LogError(fmt.Errorf("限流规则 %q 计数失败: %w", rule.Name, err))
+			continue
+		}
+		if !allowed {
+			return &Decision{Rule: rule.Name, RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// matchPrefix 按路径段匹配前缀，与站点路由相同：/api 匹配 /api 和 /api/users，不匹配 /apis
+func matchPrefix(prefix, path string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// LoadRules 从MongoDB加载限流规则，尚未创建限流规则文档时视为没有规则
+func LoadRules(ctx context.Context) (*RateLimitRules, error) {
+	var rules RateLimitRules
+	err := mongoCollection.FindOne(ctx, bson.M{"type": "rate_limit"}).Decode(&rules)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("从MongoDB读取限流规则失败: %w", err)
+	}
+
+	// 无效的规则记录日志后跳过
+	valid := make([]Rule, 0, len(rules.Rules))
+	for _, rule := range rules.Rules {
+		if err := ValidateRule(rule); err != nil {
+			logging.LogError(fmt.Errorf("忽略限流规则: %w", err))
+			continue
+		}
+		valid = append(valid, rule)
+	}
+
+	setRules(valid)
+
+	return &rules, nil
+}
+
+// GetRules 获取当前限流规则
+func GetRules() RateLimitRules {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	return rateLimitRules
+}
+
+// GetRule 获取特定名称的限流规则
+func GetRule(name string) (Rule, bool) {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+
+	for _, rule := range rateLimitRules.Rules {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// AddRule 添加限流规则，同名规则会被替换
+func AddRule(rule Rule) error {
+	if err := ValidateRule(rule); err != nil {
+		return err
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	current := GetRules().Rules
+	rules := make([]Rule, 0, len(current)+1)
+	for _, existing := range current {
+		if existing.Name != rule.Name {
+			rules = append(rules, existing)
+		}
+	}
+	rules = append(rules, rule)
+
+	// 先更新MongoDB中的限流规则，写入成功后再替换内存中的规则
+	if err := saveRules(rules); err != nil {
+		return err
+	}
+	setRules(rules)
+	return nil
+}
+
+// DeleteRule 删除特定名称的限流规则，规则不存在时返回 ErrRuleNotFound
+func DeleteRule(name string) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	current := GetRules().Rules
+	rules := make([]Rule, 0, len(current))
+	for _, rule := range current {
+		if rule.Name != name {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == len(current) {
+		return fmt.Errorf("%w: %q", ErrRuleNotFound, name)
+	}
+
+	// 先更新MongoDB中的限流规则，写入成功后再替换内存中的规则
+	if err := saveRules(rules); err != nil {
+		return err
+	}
+	setRules(rules)
+	return nil
+}
+
+// setRules 替换内存中的限流规则
+func setRules(rules []Rule) {
+	rulesMutex.Lock()
+	rateLimitRules = RateLimitRules{Rules: rules}
+	rulesMutex.Unlock()
+}
+
+// saveRules 将限流规则写回MongoDB，调用方需持有 writeMutex，不能持有 rulesMutex
+func saveRules(rules []Rule) error {
+	_, err := mongoCollection.UpdateOne(
+		context.Background(),
+		bson.M{"type": "rate_limit"},
+		bson.M{
+			"$set": bson.M{
+				"rules": rules,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
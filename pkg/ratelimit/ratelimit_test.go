@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// testStores 返回内存计数器和连接到内存Redis的计数器，两种存储应当给出相同的结果
+func testStores(t *testing.T) map[string]store {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return map[string]store{
+		BackendMemory: newMemoryStore(),
+		BackendRedis:  &redisStore{client: client},
+	}
+}
+
+// closeTo 判断两个等待时间是否在1毫秒内，Redis以毫秒计算
+func closeTo(a, b time.Duration) bool {
+	d := a - b
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	steps := []struct {
+		offset  time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{offset: 0, allowed: true},
+		{offset: 0, allowed: true},
+		{offset: 0, allowed: true},
+		{offset: 0, allowed: false, wait: time.Second}, // 容量3，每秒补充1个
+		{offset: 500 * time.Millisecond, allowed: false, wait: 500 * time.Millisecond},
+		{offset: time.Second, allowed: true},
+		{offset: time.Second, allowed: false, wait: time.Second},
+		{offset: 10 * time.Second, allowed: true}, // 补满后不超过容量
+		{offset: 10 * time.Second, allowed: true},
+		{offset: 10 * time.Second, allowed: true},
+		{offset: 10 * time.Second, allowed: false, wait: time.Second},
+	}
+	for name, s := range testStores(t) {
+		for i, step := range steps {
+			allowed, wait, err := s.tokenBucket(context.Background(), "tb", 3, 3*time.Second, start.Add(step.offset))
+			if err != nil {
+				t.Fatalf("%s: 第 %d 步计数失败: %v", name, i, err)
+			}
+			if allowed != step.allowed || (!allowed && !closeTo(wait, step.wait)) {
+				t.Errorf("%s: 第 %d 步 allowed=%v wait=%v，期望 %v %v", name, i, allowed, wait, step.allowed, step.wait)
+			}
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Unix(1700000000, 0) // 窗口边界
+	steps := []struct {
+		offset  time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{offset: time.Second, allowed: true},
+		{offset: time.Second, allowed: true},
+		{offset: time.Second, allowed: false, wait: 9 * time.Second},
+		// 下一个窗口过半，上一个窗口的2次按一半计入
+		{offset: 15 * time.Second, allowed: true},
+		{offset: 15 * time.Second, allowed: false, wait: 5 * time.Second},
+		// 间隔超过一个窗口后重新计数
+		{offset: 35 * time.Second, allowed: true},
+		{offset: 35 * time.Second, allowed: true},
+	}
+	for name, s := range testStores(t) {
+		for i, step := range steps {
+			allowed, wait, err := s.slidingWindow(context.Background(), "sw", 2, 10*time.Second, start.Add(step.offset))
+			if err != nil {
+				t.Fatalf("%s: 第 %d 步计数失败: %v", name, i, err)
+			}
+			if allowed != step.allowed || (!allowed && !closeTo(wait, step.wait)) {
+				t.Errorf("%s: 第 %d 步 allowed=%v wait=%v，期望 %v %v", name, i, allowed, wait, step.allowed, step.wait)
+			}
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	rateLimitRules = RateLimitRules{Rules: []Rule{
+		{Name: "login", Key: "ip", PathPrefix: "/login", Method: "POST", Algorithm: AlgorithmSlidingWindow, Limit: 1, Window: 60},
+		{Name: "api-key", Key: "header:X-Api-Key", PathPrefix: "/api", Limit: 1, Window: 60},
+		{Name: "session", Key: "cookie:sid", Limit: 2, Window: 60},
+	}}
+	defer func() { rateLimitRules = RateLimitRules{} }()
+
+	type request struct {
+		method, path, ip, apiKey, sid string
+	}
+	tests := []struct {
+		name     string
+		requests []request
+		rule     string // 最后一个请求触发的规则，为空表示不限流
+	}{
+		{name: "按IP限流", requests: []request{{"POST", "/login", "1.1.1.1", "", ""}, {"POST", "/login", "1.1.1.1", "", ""}}, rule: "login"},
+		{name: "不同IP分别计数", requests: []request{{"POST", "/login", "2.2.2.2", "", ""}, {"POST", "/login", "3.3.3.3", "", ""}}},
+		{name: "前缀按路径段匹配", requests: []request{{"POST", "/login/", "1.2.3.4", "", ""}, {"POST", "/login", "1.2.3.4", "", ""}}, rule: "login"},
+		{name: "前缀不匹配同名开头的路径", requests: []request{{"POST", "/loginx", "1.2.3.5", "", ""}, {"POST", "/loginx", "1.2.3.5", "", ""}}},
+		{name: "方法不匹配", requests: []request{{"GET", "/login", "4.4.4.4", "", ""}, {"GET", "/login", "4.4.4.4", "", ""}}},
+		{name: "按请求头限流", requests: []request{{"GET", "/api/a", "5.5.5.5", "k1", ""}, {"GET", "/api/b", "6.6.6.6", "k1", ""}}, rule: "api-key"},
+		{name: "缺少请求头时规则不生效", requests: []request{{"GET", "/api/a", "7.7.7.7", "", ""}, {"GET", "/api/a", "7.7.7.7", "", ""}}},
+		{name: "按Cookie限流", requests: []request{{"GET", "/", "8.8.8.8", "", "s1"}, {"GET", "/", "8.8.8.8", "", "s1"}, {"GET", "/", "9.9.9.9", "", "s1"}}, rule: "session"},
+	}
+	for _, backend := range []string{BackendMemory, BackendRedis} {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		SetBackend(backend, client)
+
+		for _, tt := range tests {
+			var decision *Decision
+			for _, r := range tt.requests {
+				req := httptest.NewRequest(r.method, r.path, nil)
+				if r.apiKey != "" {
+					req.Header.Set("X-Api-Key", r.apiKey)
+				}
+				if r.sid != "" {
+					req.Header.Set("Cookie", "sid="+r.sid)
+				}
+				decision = Check(req, r.ip)
+			}
+			switch {
+			case tt.rule == "" && decision != nil:
+				t.Errorf("%s/%s: 不应限流，触发了 %q", backend, tt.name, decision.Rule)
+			case tt.rule != "" && (decision == nil || decision.Rule != tt.rule):
+				t.Errorf("%s/%s: 期望触发 %q，结果 %+v", backend, tt.name, tt.rule, decision)
+			case decision != nil && decision.RetryAfter <= 0:
+				t.Errorf("%s/%s: 重试等待时间应大于0", backend, tt.name)
+			}
+		}
+		client.Close()
+	}
+	SetBackend(BackendMemory, nil)
+}
+
+func TestValidateRule(t *testing.T) {
+	tests := []struct {
+		rule  Rule
+		valid bool
+	}{
+		{rule: Rule{Name: "a", Key: "ip", Limit: 1, Window: 1}, valid: true},
+		{rule: Rule{Name: "a", Key: "Header:x-token", Limit: 1, Window: 1, Algorithm: AlgorithmSlidingWindow}, valid: true},
+		{rule: Rule{Key: "ip", Limit: 1, Window: 1}},
+		{rule: Rule{Name: "a", Key: "header", Limit: 1, Window: 1}},
+		{rule: Rule{Name: "a", Key: "ip:x", Limit: 1, Window: 1}},
+		{rule: Rule{Name: "a", Key: "ip", Limit: 1, Window: 1, Algorithm: "leaky_bucket"}},
+		{rule: Rule{Name: "a", Key: "ip", Limit: 0, Window: 1}},
+	}
+	for _, tt := range tests {
+		if err := ValidateRule(tt.rule); (err == nil) != tt.valid {
+			t.Errorf("%+v: 校验结果 %v，期望有效 %v", tt.rule, err, tt.valid)
+		}
+	}
+}
+
+func TestAddDeleteRule(t *testing.T) {
+	defer func() {
+		rateLimitRules = RateLimitRules{}
+		SetMongoCollection(nil)
+	}()
+	login := Rule{Name: "login", Key: "ip", Limit: 5, Window: 60}
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("写入成功后替换规则", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		rateLimitRules = RateLimitRules{Rules: []Rule{login}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		replaced := Rule{Name: "login", Key: "ip", Limit: 10, Window: 60}
+		if err := AddRule(replaced); err != nil {
+			mt.Fatalf("添加规则失败: %v", err)
+		}
+		if rules := GetRules().Rules; len(rules) != 1 || rules[0] != replaced {
+			mt.Errorf("同名规则应被替换，结果 %+v", rules)
+		}
+		if err := DeleteRule("login"); err != nil || len(GetRules().Rules) != 0 {
+			mt.Errorf("删除规则失败: %v，剩余 %+v", err, GetRules().Rules)
+		}
+		if err := DeleteRule("login"); !errors.Is(err, ErrRuleNotFound) {
+			mt.Errorf("删除不存在的规则应返回 ErrRuleNotFound，结果 %v", err)
+		}
+	})
+	mt.Run("写入失败时保留原规则", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		rateLimitRules = RateLimitRules{Rules: []Rule{login}}
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}),
+		)
+
+		if err := AddRule(Rule{Name: "api", Key: "ip", Limit: 1, Window: 1}); err == nil {
+			mt.Error("写入失败时应返回错误")
+		}
+		if err := DeleteRule("login"); err == nil {
+			mt.Error("写入失败时应返回错误")
+		}
+		if rules := GetRules().Rules; len(rules) != 1 || rules[0] != login {
+			mt.Errorf("写入失败后规则 %+v，期望保持不变", rules)
+		}
+	})
+}
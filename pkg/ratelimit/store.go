@@ -0,0 +1,182 @@
+// pkg/ratelimit/store.go
+
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// store 限流计数器的存储
+type store interface {
+	// tokenBucket 从令牌桶中取一个令牌，capacity 为桶容量，refill 为补满整个桶所需时间
+	tokenBucket(ctx context.Context, key string, capacity int, refill time.Duration, now time.Time) (bool, time.Duration, error)
+	// slidingWindow 按滑动窗口计数，窗口内请求数达到 limit 时拒绝
+	slidingWindow(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error)
+}
+
+// cleanupInterval 内存计数器清理间隔
+const cleanupInterval = time.Minute
+
+// memoryStore 单实例内存计数器
+type memoryStore struct {
+	mu          sync.Mutex
+	buckets     map[string]*bucketState
+	windows     map[string]*windowState
+	lastCleanup time.Time
+}
+
+type bucketState struct {
+	tokens  float64
+	updated time.Time
+	refill  time.Duration
+}
+
+type windowState struct {
+	start    time.Time
+	window   time.Duration
+	current  int
+	previous int
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets: make(map[string]*bucketState),
+		windows: make(map[string]*windowState),
+	}
+}
+
+func (s *memoryStore) tokenBucket(_ context.Context, key string, capacity int, refill time.Duration, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup(now)
+
+	rate := float64(capacity) / float64(refill) // 每纳秒补充的令牌数
+	state, ok := s.buckets[key]
+	if !ok {
+		state = &bucketState{tokens: float64(capacity), updated: now, refill: refill}
+		s.buckets[key] = state
+	}
+	state.tokens = math.Min(float64(capacity), state.tokens+float64(now.Sub(state.updated))*rate)
+	state.updated = now
+
+	if state.tokens >= 1 {
+		state.tokens--
+		return true, 0, nil
+	}
+	return false, time.Duration(math.Ceil((1 - state.tokens) / rate)), nil
+}
+
+func (s *memoryStore) slidingWindow(_ context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup(now)
+
+	start := now.Truncate(window)
+	state, ok := s.windows[key]
+	switch {
+	case !ok:
+		state = &windowState{start: start, window: window}
+		s.windows[key] = state
+	case start.Sub(state.start) == window:
+		state.previous, state.current, state.start = state.current, 0, start
+	case start.Sub(state.start) > window:
+		state.previous, state.current, state.start = 0, 0, start
+	}
+
+	elapsed := now.Sub(start)
+	estimate := float64(state.previous)*float64(window-elapsed)/float64(window) + float64(state.current)
+	if estimate >= float64(limit) {
+		return false, window - elapsed, nil
+	}
+	state.current++
+	return true, 0, nil
+}
+
+// cleanup 定期删除已经不影响限流结果的计数器：令牌桶已补满，或滑动窗口已完全移出，调用方需持有锁
+func (s *memoryStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < cleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+
+	for key, state := range s.buckets {
+		if now.Sub(state.updated) > state.refill {
+			delete(s.buckets, key)
+		}
+	}
+	for key, state := range s.windows {
+		if now.Sub(state.start) > 2*state.window {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// redisStore 基于Redis的计数器，多个Stone实例共享同一组限流计数
+type redisStore struct {
+	client *redis.Client
+}
+
+// tokenBucketScript 在Redis中原子地补充并扣减令牌
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + (now - ts) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+else
+  wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, wait}
+`)
+
+// slidingWindowScript 在Redis中按前后两个固定窗口加权估算滑动窗口内的请求数
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local previous = tonumber(redis.call('GET', KEYS[2]) or '0')
+local current = tonumber(redis.call('GET', KEYS[1]) or '0')
+if previous * (window - elapsed) / window + current >= limit then
+  return {0, window - elapsed}
+end
+redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], window * 2)
+return {1, 0}
+`)
+
+func (s *redisStore) tokenBucket(ctx context.Context, key string, capacity int, refill time.Duration, now time.Time) (bool, time.Duration, error) {
+	rate := float64(capacity) / float64(refill.Milliseconds()) // 每毫秒补充的令牌数
+	result, err := tokenBucketScript.Run(ctx, s.client, []string{"ratelimit:tb:" + key},
+		capacity, rate, now.UnixMilli(), refill.Milliseconds()*2).Int64Slice()
+	if err != nil {
+		return true, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+func (s *redisStore) slidingWindow(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	start := now.Truncate(window)
+	current := "ratelimit:sw:" + key + ":" + start.Format("20060102150405")
+	previous := "ratelimit:sw:" + key + ":" + start.Add(-window).Format("20060102150405")
+	result, err := slidingWindowScript.Run(ctx, s.client, []string{current, previous},
+		limit, window.Milliseconds(), now.Sub(start).Milliseconds()).Int64Slice()
+	if err != nil {
+		return true, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
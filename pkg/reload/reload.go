@@ -0,0 +1,184 @@
+// pkg/reload/reload.go
+
+package reload
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// 监视方式
+const (
+	ModeChangeStream = "change_stream" // MongoDB变更流，需要副本集或分片集群
+	ModePolling      = "polling"       // 定期比较集合内容的摘要
+)
+
+// DefaultPollInterval 轮询方式的默认间隔
+const DefaultPollInterval = 10 * time.Second
+
+var (
+	modesMutex sync.RWMutex
+	modes      = make(map[string]string) // 每个监视对象当前使用的监视方式
+)
+
+// Modes 返回每个监视对象当前使用的监视方式
+func Modes() map[string]string {
+	modesMutex.RLock()
+	defer modesMutex.RUnlock()
+	result := make(map[string]string, len(modes))
+	for name, mode := range modes {
+		result[name] = mode
+	}
+	return result
+}
+
+func setMode(name, mode string) {
+	modesMutex.Lock()
+	modes[name] = mode
+	modesMutex.Unlock()
+}
+
+// Source 一个需要监视的集合及其变化后的重新加载函数
+type Source struct {
+	Name       string
+	Collection *mongo.Collection
+	Filter     bson.M // 只监视匹配的文档，为空时监视整个集合
+	Reload     func(ctx context.Context) error
+}
+
+// Watch 监视集合的变化并调用 Reload，优先使用变更流，服务器不支持时退回轮询，ctx 取消时停止
+// 其他实例通过接口修改规则、或直接修改数据库后，本实例在变更流通知或下一次轮询时重新加载
+func Watch(ctx context.Context, source Source, pollInterval time.Duration) {
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+
+	go func() {
+		for ctx.Err() == nil {
+			err := watchChangeStream(ctx, source)
+			if ctx.Err() != nil {
+				return
+			}
+			if isChangeStreamUnsupported(err) {
+				logging.LogInfo(fmt.Sprintf("MongoDB不支持变更流，%s 改为每 %s 轮询一次", source.Name, pollInterval))
+				setMode(source.Name, ModePolling)
+				poll(ctx, source, pollInterval)
+				return
+			}
+			// 变更流中断（如主节点切换）后重新订阅，期间的变化通过重新加载补上
+			logging.LogError(fmt.Errorf("%s 变更流中断: %w", source.Name, err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			reload(ctx, source)
+		}
+	}()
+}
+
+// watchChangeStream 订阅变更流，每次变化后重新加载，返回时变更流已关闭
+func watchChangeStream(ctx context.Context, source Source) error {
+	// 更新事件默认不带完整文档，需要查询后才能按 Filter 过滤
+	stream, err := source.Collection.Watch(ctx, changeStreamPipeline(source.Filter), options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+	setMode(source.Name, ModeChangeStream)
+
+	for stream.Next(ctx) {
+		reload(ctx, source)
+	}
+	return stream.Err()
+}
+
+// changeStreamPipeline 只保留完整文档匹配 filter 的变更，删除类事件不带文档，始终保留
+func changeStreamPipeline(filter bson.M) mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(filter) > 0 {
+		match := bson.M{"operationType": bson.M{"$in": bson.A{"delete", "drop", "dropDatabase", "rename", "invalidate"}}}
+		or := bson.A{match}
+		for key, value := range filter {
+			or = append(or, bson.M{"fullDocument." + key: value})
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": or}}})
+	}
+	return pipeline
+}
+
+// poll 定期计算匹配文档的摘要，摘要变化时重新加载
+func poll(ctx context.Context, source Source, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, err := checksum(ctx, source)
+	if err != nil {
+		logging.LogError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := checksum(ctx, source)
+			if err != nil {
+				logging.LogError(err)
+				continue
+			}
+			if current != last {
+				last = current
+				reload(ctx, source)
+			}
+		}
+	}
+}
+
+// checksum 计算匹配文档的摘要
+func checksum(ctx context.Context, source Source) ([sha256.Size]byte, error) {
+	filter := source.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+	cursor, err := source.Collection.Find(ctx, filter)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("轮询 %s 失败: %w", source.Name, err)
+	}
+	defer cursor.Close(ctx)
+
+	hash := sha256.New()
+	for cursor.Next(ctx) {
+		hash.Write(cursor.Current)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum, cursor.Err()
+}
+
+func reload(ctx context.Context, source Source) {
+	if err := source.Reload(ctx); err != nil {
+		logging.LogError(fmt.Errorf("重新加载 %s 失败: %w", source.Name, err))
+		return
+	}
+	logging.LogInfo(fmt.Sprintf("已重新加载 %s", source.Name))
+}
+
+// isChangeStreamUnsupported 单节点MongoDB不支持变更流
+func isChangeStreamUnsupported(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	// 40573: The $changeStream stage is only supported on replica sets
+	// 20: IllegalOperation，部分版本在单节点上返回该错误
+	return serverErr.HasErrorCode(40573) || serverErr.HasErrorCode(20)
+}
@@ -0,0 +1,155 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestIsChangeStreamUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "单节点不支持变更流", err: mongo.CommandError{Code: 40573, Message: "The $changeStream stage is only supported on replica sets"}, want: true},
+		{name: "IllegalOperation", err: mongo.CommandError{Code: 20}, want: true},
+		{name: "主节点切换", err: mongo.CommandError{Code: 10107, Message: "not primary"}},
+		{name: "网络错误", err: errors.New("connection reset")},
+		{name: "无错误", err: nil},
+	}
+	for _, tt := range tests {
+		if got := isChangeStreamUnsupported(tt.err); got != tt.want {
+			t.Errorf("%s: %v，期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChangeStreamPipeline(t *testing.T) {
+	if pipeline := changeStreamPipeline(nil); len(pipeline) != 0 {
+		t.Errorf("未设置 Filter 时应监视整个集合，实际 %v", pipeline)
+	}
+
+	pipeline := changeStreamPipeline(bson.M{"type": "ip_control"})
+	if len(pipeline) != 1 || pipeline[0][0].Key != "$match" {
+		t.Fatalf("应只有一个 $match 阶段，实际 %v", pipeline)
+	}
+	or := pipeline[0][0].Value.(bson.M)["$or"].(bson.A)
+	if len(or) != 2 {
+		t.Fatalf("匹配条件 %v，期望删除类事件和 Filter 两项", or)
+	}
+	if got := or[1].(bson.M)["fullDocument.type"]; got != "ip_control" {
+		t.Errorf("应按完整文档的 type 过滤，实际 %v", or[1])
+	}
+	deletes := or[0].(bson.M)["operationType"].(bson.M)["$in"].(bson.A)
+	if len(deletes) == 0 || deletes[0] != "delete" {
+		t.Errorf("删除事件不带文档，应始终保留，实际 %v", or[0])
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("文档变化时摘要变化", func(mt *mtest.T) {
+		source := Source{Name: "rules", Collection: mt.Coll, Filter: bson.M{"type": "interception"}}
+		docs := []bson.D{
+			{{Key: "type", Value: "interception"}, {Key: "rules", Value: bson.A{"a"}}},
+			{{Key: "type", Value: "interception"}, {Key: "rules", Value: bson.A{"a"}}},
+			{{Key: "type", Value: "interception"}, {Key: "rules", Value: bson.A{"a", "b"}}},
+		}
+		var sums [][32]byte
+		for _, doc := range docs {
+			mt.AddMockResponses(mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, doc))
+			sum, err := checksum(context.Background(), source)
+			if err != nil {
+				mt.Fatalf("计算摘要失败: %v", err)
+			}
+			sums = append(sums, sum)
+		}
+		if sums[0] != sums[1] {
+			mt.Error("内容相同时摘要应当相同")
+		}
+		if sums[1] == sums[2] {
+			mt.Error("内容变化后摘要应当不同")
+		}
+
+		// 查询使用 Filter
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if filter.Lookup("type").StringValue() != "interception" {
+			mt.Errorf("轮询应按 Filter 查询，实际 %v", filter)
+		}
+	})
+}
+
+func TestWatchFallsBackToPolling(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("不支持变更流时轮询", func(mt *mtest.T) {
+		var reloads atomic.Int32
+		source := Source{Name: "fallback", Collection: mt.Coll, Reload: func(context.Context) error {
+			reloads.Add(1)
+			return nil
+		}}
+		doc := func(v string) bson.D { return bson.D{{Key: "v", Value: v}} }
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 40573, Message: "not a replica set"}),
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, doc("a")), // 初始摘要
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, doc("a")), // 未变化
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, doc("b")), // 变化，重新加载
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Watch(ctx, source, 20*time.Millisecond)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for reloads.Load() == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond) // 之后的轮询没有可用的响应，不应再次重新加载
+		if got := reloads.Load(); got != 1 {
+			mt.Errorf("摘要变化一次，重新加载了 %d 次", got)
+		}
+		if mode := Modes()["fallback"]; mode != ModePolling {
+			mt.Errorf("监视方式 %q，期望 %q", mode, ModePolling)
+		}
+	})
+}
+
+func TestWatchChangeStream(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("每个变更事件重新加载", func(mt *mtest.T) {
+		var reloads atomic.Int32
+		source := Source{Name: "stream", Collection: mt.Coll, Filter: bson.M{"type": "ip_control"}, Reload: func(context.Context) error {
+			reloads.Add(1)
+			return nil
+		}}
+		event := func(token string) bson.D {
+			return bson.D{{Key: "_id", Value: bson.D{{Key: "_data", Value: token}}}, {Key: "operationType", Value: "update"}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "stone.rules", mtest.FirstBatch, event("1"), event("2")),
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.NextBatch),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		Watch(ctx, source, time.Hour)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for reloads.Load() < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := reloads.Load(); got != 2 {
+			mt.Errorf("收到2个变更事件，重新加载了 %d 次", got)
+		}
+		if mode := Modes()["stream"]; mode != ModeChangeStream {
+			mt.Errorf("监视方式 %q，期望 %q", mode, ModeChangeStream)
+		}
+
+	})
+}
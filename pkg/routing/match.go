@@ -0,0 +1,137 @@
+// pkg/routing/match.go
+
+package routing
+
+import (
+	"Stone/pkg/rules"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Match 返回与请求的Host和路径匹配的路由，没有匹配的路由时返回nil
+// 精确主机名优先于通配主机名，通配主机名优先于不限主机名；主机名相同时路径前缀最长的优先
+func Match(host, path string) *CompiledRoute {
+	routes := table.Load()
+	if routes == nil {
+		return nil
+	}
+	host = normalizeHost(host)
+
+	var best *CompiledRoute
+	bestHost, bestPrefix := -1, -1
+	for _, route := range *routes {
+		hostScore := matchHost(route.Host, host)
+		if hostScore < 0 || !matchPrefix(route.PathPrefix, path) {
+			continue
+		}
+		if hostScore > bestHost || (hostScore == bestHost && len(route.PathPrefix) > bestPrefix) {
+			best, bestHost, bestPrefix = route, hostScore, len(route.PathPrefix)
+		}
+	}
+	return best
+}
+
+// normalizeHost 去掉Host中的端口并转为小写
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// matchHost 返回主机名的匹配程度，不匹配时返回-1；通配主机名的后缀越长匹配程度越高
+func matchHost(pattern, host string) int {
+	switch {
+	case pattern == "":
+		return 0
+	case strings.HasPrefix(pattern, "*."):
+		suffix := pattern[1:]
+		if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+			return len(suffix)
+		}
+		return -1
+	case pattern == host:
+		// 精确匹配总是优先于通配匹配
+		return 1 << 16
+	}
+	return -1
+}
+
+// matchPrefix 按路径段匹配前缀，/api 匹配 /api 和 /api/users，不匹配 /apis
+func matchPrefix(prefix, path string) bool {
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// Pick 按路由的负载均衡策略选择一个可用的上游，全部上游不可用时返回 ErrNoUpstream
+func (r *CompiledRoute) Pick(clientIP string) (*Server, error) {
+	server, err := r.pool.pick(clientIP)
+	if err != nil {
+		return nil, fmt.Errorf("%w: 路由 %q", err, r.Name)
+	}
+	return server, nil
+}
+
+// Begin 开始向上游转发请求，转发结束后必须调用 Done
+func (r *CompiledRoute) Begin(server *Server) {
+	server.begin()
+}
+
+// Done 向上游转发请求结束，failed 表示连接失败或上游返回网关错误
+func (r *CompiledRoute) Done(server *Server, failed bool) {
+	r.pool.done(server, failed)
+}
+
+// Ruleset 返回路由使用的拦截规则集，路由不检查拦截规则时返回空规则集，请求体大小仍然受限
+// 规则变更后第一次调用时根据新的规则集重新选择
+func (r *CompiledRoute) Ruleset() *rules.Ruleset {
+	if r.Rules.Disabled {
+		return &rules.Ruleset{}
+	}
+	base := rules.ActiveRuleset()
+	if cached := r.selection.Load(); cached != nil && cached.base == base {
+		return cached.selected
+	}
+	selected := base.Select(r.Rules.Include, r.Rules.Exclude)
+	r.selection.Store(&selectedRuleset{base: base, selected: selected})
+	return selected
+}
+
+// CheckIP 按路由的IP策略判断是否允许访问
+func (r *CompiledRoute) CheckIP(ip string) bool {
+	if r.deny != nil && r.deny.Contains(ip) {
+		return false
+	}
+	if r.allow != nil && !r.allow.Contains(ip) {
+		return false
+	}
+	return true
+}
+
+// Scheme 返回连接上游使用的协议
+func (r *CompiledRoute) Scheme() string {
+	if r.transport != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Transport 返回连接上游使用的 RoundTripper，以HTTP连接时返回nil，使用默认值
+func (r *CompiledRoute) Transport() http.RoundTripper {
+	if r.transport == nil {
+		return nil
+	}
+	return r.transport
+}
+
+// BlockedPage 返回路由的拦截页面文件路径
+func (r *CompiledRoute) BlockedPage() string {
+	return r.blockPage
+}
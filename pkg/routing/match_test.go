@@ -0,0 +1,185 @@
+package routing
+
+import (
+	"Stone/pkg/rules"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setRoutes 编译路由并替换匹配表，不启动健康检查
+func setRoutes(t *testing.T, routes ...Route) {
+	t.Helper()
+	compiled := make([]*CompiledRoute, 0, len(routes))
+	for _, route := range routes {
+		c, err := compileRoute(route)
+		if err != nil {
+			t.Fatalf("编译路由 %q 失败: %v", route.Name, err)
+		}
+		c.pool = newPool(route, nil)
+		compiled = append(compiled, c)
+	}
+	table.Store(&compiled)
+	t.Cleanup(func() { table.Store(nil) })
+}
+
+func TestMatch(t *testing.T) {
+	upstreams := []string{"127.0.0.1:8080"}
+	setRoutes(t,
+		Route{Name: "默认", Upstreams: upstreams},
+		Route{Name: "任意主机API", PathPrefix: "/api", Upstreams: upstreams},
+		Route{Name: "通配", Host: "*.example.com", Upstreams: upstreams},
+		Route{Name: "通配API", Host: "*.example.com", PathPrefix: "/api", Upstreams: upstreams},
+		Route{Name: "更长的通配", Host: "*.shop.example.com", Upstreams: upstreams},
+		Route{Name: "精确", Host: "WWW.example.com", Upstreams: upstreams},
+		Route{Name: "精确API", Host: "www.example.com", PathPrefix: "/api/v2", Upstreams: upstreams},
+	)
+
+	tests := []struct {
+		host, path string
+		want       string
+	}{
+		{host: "other.org", path: "/", want: "默认"},
+		{host: "other.org", path: "/api/users", want: "任意主机API"},
+		{host: "other.org", path: "/apis", want: "默认"}, // 按路径段匹配前缀
+		{host: "a.example.com", path: "/", want: "通配"},
+		{host: "a.example.com", path: "/api", want: "通配API"},
+		{host: "example.com", path: "/", want: "默认"}, // 通配不匹配裸域名
+		{host: "cart.shop.example.com", path: "/api", want: "更长的通配"},
+		{host: "www.example.com:8443", path: "/api/v1", want: "精确"}, // 精确主机名优先于更长的路径前缀
+		{host: "WWW.EXAMPLE.COM.", path: "/api/v2/items", want: "精确API"},
+	}
+	for _, tt := range tests {
+		route := Match(tt.host, tt.path)
+		if route == nil || route.Name != tt.want {
+			t.Errorf("%s%s: 匹配 %v，期望 %q", tt.host, tt.path, route, tt.want)
+		}
+	}
+
+	table.Store(&[]*CompiledRoute{})
+	if route := Match("a.example.com", "/"); route != nil {
+		t.Errorf("没有路由时应返回nil，结果 %q", route.Name)
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		prefix, path string
+		match        bool
+	}{
+		{prefix: "", path: "/anything", match: true},
+		{prefix: "/", path: "/anything", match: true},
+		{prefix: "/api", path: "/api", match: true},
+		{prefix: "/api", path: "/api/users", match: true},
+		{prefix: "/api", path: "/apis"},
+		{prefix: "/api/", path: "/api/users", match: true},
+		{prefix: "/api/", path: "/api"},
+	}
+	for _, tt := range tests {
+		if got := matchPrefix(tt.prefix, tt.path); got != tt.match {
+			t.Errorf("前缀 %q 路径 %q: 结果 %v，期望 %v", tt.prefix, tt.path, got, tt.match)
+		}
+	}
+}
+
+func TestCompileRoute(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "custom.html"), []byte("blocked"), 0o644)
+	os.Mkdir(filepath.Join(dir, "shop"), 0o755)
+	os.WriteFile(filepath.Join(dir, "shop", "blocked.html"), []byte("blocked"), 0o644)
+	SetBlockPageDirectory(dir)
+	defer SetBlockPageDirectory("")
+
+	valid := Route{Name: "r", Upstreams: []string{"127.0.0.1:8080"}}
+	with := func(modify func(*Route)) Route {
+		route := valid
+		modify(&route)
+		return route
+	}
+	tests := []struct {
+		name      string
+		route     Route
+		valid     bool
+		blockPage string
+	}{
+		{name: "默认拦截页面", route: valid, valid: true, blockPage: DefaultBlockPage},
+		{name: "目录中的拦截页面", route: with(func(r *Route) { r.BlockPage = "custom.html" }), valid: true, blockPage: filepath.Join(dir, "custom.html")},
+		{name: "子目录中的拦截页面", route: with(func(r *Route) { r.BlockPage = "shop/blocked.html" }), valid: true, blockPage: filepath.Join(dir, "shop", "blocked.html")},
+		{name: "拦截页面不存在", route: with(func(r *Route) { r.BlockPage = "missing.html" })},
+		{name: "拦截页面为绝对路径", route: with(func(r *Route) { r.BlockPage = filepath.Join(dir, "custom.html") })},
+		{name: "拦截页面跳出目录", route: with(func(r *Route) { r.BlockPage = "../custom.html" })},
+		{name: "拦截页面包含..", route: with(func(r *Route) { r.BlockPage = "shop/../custom.html" })},
+		{name: "CA不接受文件路径", route: with(func(r *Route) { r.TLS = UpstreamTLS{Enabled: true, CA: filepath.Join(dir, "custom.html")} })},
+		{name: "缺少名称", route: with(func(r *Route) { r.Name = "" })},
+		{name: "路径前缀不以/开头", route: with(func(r *Route) { r.PathPrefix = "api" })},
+		{name: "缺少上游", route: with(func(r *Route) { r.Upstreams = nil })},
+		{name: "上游缺少端口", route: with(func(r *Route) { r.Upstreams = []string{"backend"} })},
+		{name: "未知的负载均衡策略", route: with(func(r *Route) { r.Balance = "random" })},
+		{name: "无效的IP策略", route: with(func(r *Route) { r.IPPolicy.Deny = []string{"bad"} })},
+	}
+	for _, tt := range tests {
+		compiled, err := compileRoute(tt.route)
+		if !tt.valid {
+			if !errors.Is(err, ErrInvalidRoute) {
+				t.Errorf("%s: 期望 ErrInvalidRoute，结果 %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: 编译失败: %v", tt.name, err)
+			continue
+		}
+		if compiled.BlockedPage() != tt.blockPage {
+			t.Errorf("%s: 拦截页面 %q，期望 %q", tt.name, compiled.BlockedPage(), tt.blockPage)
+		}
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	route, err := compileRoute(Route{Name: "r", Upstreams: []string{"127.0.0.1:8080"}, IPPolicy: IPPolicy{
+		Allow: []string{"10.0.0.0/8"},
+		Deny:  []string{"10.0.0.5"},
+	}})
+	if err != nil {
+		t.Fatalf("编译路由失败: %v", err)
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "10.1.2.3", allowed: true},
+		{ip: "10.0.0.5"}, // 拒绝名单优先
+		{ip: "192.168.0.1"},
+	}
+	for _, tt := range tests {
+		if got := route.CheckIP(tt.ip); got != tt.allowed {
+			t.Errorf("%s: 允许 %v，期望 %v", tt.ip, got, tt.allowed)
+		}
+	}
+}
+
+func TestRulesetDisabled(t *testing.T) {
+	// 不检查拦截规则的路由不解析包体，超出解析限制的JSON数组同样放行
+	array := "[" + strings.Repeat("1,", rules.DefaultBodyLimits.MaxFields) + "1]"
+	tests := []struct {
+		name  string
+		rules RuleSelection
+	}{
+		{name: "不检查拦截规则", rules: RuleSelection{Disabled: true}},
+		{name: "使用全部规则", rules: RuleSelection{}},
+	}
+	for _, tt := range tests {
+		route, err := compileRoute(Route{Name: "r", Upstreams: []string{"127.0.0.1:8080"}, Rules: tt.rules})
+		if err != nil {
+			t.Fatalf("编译路由失败: %v", err)
+		}
+		req := httptest.NewRequest("POST", "http://www.example.com/api", strings.NewReader(array))
+		req.Header.Set("Content-Type", "application/json")
+		if result := rules.CheckRequestWith(req, route.Ruleset()); result.Blocked {
+			t.Errorf("%s: 请求被 %+v 拦截", tt.name, result.Match)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+// pkg/routing/pool.go
+
+package routing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 负载均衡策略
+const (
+	BalanceRoundRobin     = "round_robin"     // 轮询，默认策略
+	BalanceLeastConn      = "least_conn"      // 选择正在处理的请求最少的上游
+	BalanceConsistentHash = "consistent_hash" // 按客户端IP一致性哈希，同一IP固定转发到同一上游
+)
+
+// 健康检查和被动摘除的默认参数
+const (
+	DefaultHealthCheckInterval = 10 // 秒
+	DefaultHealthCheckTimeout  = 2  // 秒
+	DefaultHealthyThreshold    = 2
+	DefaultUnhealthyThreshold  = 3
+	DefaultMaxFails            = 3
+	DefaultFailTimeout         = 30 // 秒
+
+	hashReplicas = 160 // 一致性哈希中每个上游的虚拟节点数
+)
+
+// ErrNoUpstream 路由的全部上游都不可用
+var ErrNoUpstream = errors.New("没有可用的上游服务")
+
+// HealthCheck 主动健康检查，Path 为空时不检查，上游始终视为健康
+type HealthCheck struct {
+	Path               string `bson:"path,omitempty" json:"path,omitempty"`                              // 检查的路径，返回 2xx 或 3xx 视为健康
+	Interval           int    `bson:"interval,omitempty" json:"interval,omitempty"`                      // 检查间隔秒数
+	Timeout            int    `bson:"timeout,omitempty" json:"timeout,omitempty"`                        // 单次检查超时秒数
+	HealthyThreshold   int    `bson:"healthythreshold,omitempty" json:"healthy_threshold,omitempty"`     // 连续成功多少次后恢复
+	UnhealthyThreshold int    `bson:"unhealthythreshold,omitempty" json:"unhealthy_threshold,omitempty"` // 连续失败多少次后标记为不健康
+}
+
+// Server 上游池中的一个上游服务
+type Server struct {
+	Address string
+
+	healthy      atomic.Bool
+	active       atomic.Int64 // 正在处理的请求数
+	fails        atomic.Int64 // 连续失败的请求数
+	ejectedUntil atomic.Int64 // 被动摘除的截止时间，UnixNano
+
+	// 主动健康检查连续成功和失败的次数
+	checkSuccesses, checkFailures atomic.Int64
+	lastCheckError                atomic.Pointer[string]
+}
+
+// ServerStatus 上游服务的当前状态
+type ServerStatus struct {
+	Address             string     `json:"address"`
+	Healthy             bool       `json:"healthy"` // 主动健康检查的结果
+	Ejected             bool       `json:"ejected"` // 因连续失败被暂时摘除
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+	LastCheckError      string     `json:"last_check_error,omitempty"`
+}
+
+// PoolStatus 路由上游池的当前状态
+type PoolStatus struct {
+	Route       string         `json:"route"`
+	Balance     string         `json:"balance"`
+	HealthCheck bool           `json:"health_check"`
+	Available   int            `json:"available"`
+	Servers     []ServerStatus `json:"servers"`
+}
+
+// pool 路由的上游池
+type pool struct {
+	balance     string
+	servers     []*Server
+	ring        []ringPoint
+	next        atomic.Uint64
+	maxFails    int64
+	failTimeout time.Duration
+}
+
+// ringPoint 一致性哈希环上的一个虚拟节点
+type ringPoint struct {
+	hash   uint32
+	server int
+}
+
+// newPool 创建上游池，previous 中地址相同的上游保留原有状态
+func newPool(route Route, previous map[string]*Server) *pool {
+	p := &pool{
+		balance:     route.Balance,
+		maxFails:    int64(route.MaxFails),
+		failTimeout: time.Duration(route.FailTimeout) * time.Second,
+	}
+	if p.balance == "" {
+		p.balance = BalanceRoundRobin
+	}
+	if p.maxFails <= 0 {
+		p.maxFails = DefaultMaxFails
+	}
+	if p.failTimeout <= 0 {
+		p.failTimeout = DefaultFailTimeout * time.Second
+	}
+
+	for _, address := range route.Upstreams {
+		server, found := previous[address]
+		if !found {
+			server = &Server{Address: address}
+			server.healthy.Store(true)
+		}
+		// 关闭健康检查后不再沿用之前的检查结果
+		if route.HealthCheck.Path == "" {
+			server.healthy.Store(true)
+		}
+		p.servers = append(p.servers, server)
+	}
+
+	if p.balance == BalanceConsistentHash {
+		for i, server := range p.servers {
+			for replica := 0; replica < hashReplicas; replica++ {
+				hash := crc32.ChecksumIEEE([]byte(server.Address + "#" + strconv.Itoa(replica)))
+				p.ring = append(p.ring, ringPoint{hash: hash, server: i})
+			}
+		}
+		sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	}
+	return p
+}
+
+// available 判断上游是否健康且未被摘除
+func (s *Server) available(now time.Time) bool {
+	return s.healthy.Load() && s.ejectedUntil.Load() <= now.UnixNano()
+}
+
+// pick 按负载均衡策略选择一个可用的上游
+func (p *pool) pick(clientIP string) (*Server, error) {
+	now := time.Now()
+	n := len(p.servers)
+	switch p.balance {
+	case BalanceLeastConn:
+		// 从轮询位置开始比较，请求数相同时依次分配
+		start := int(p.next.Add(1) % uint64(n))
+		var best *Server
+		for i := 0; i < n; i++ {
+			server := p.servers[(start+i)%n]
+			if server.available(now) && (best == nil || server.active.Load() < best.active.Load()) {
+				best = server
+			}
+		}
+		if best != nil {
+			return best, nil
+		}
+	case BalanceConsistentHash:
+		// 沿哈希环顺时针查找第一个可用的上游，上游不可用时只影响原本分配给它的客户端
+		hash := crc32.ChecksumIEEE([]byte(clientIP))
+		start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+		for i := 0; i < len(p.ring); i++ {
+			server := p.servers[p.ring[(start+i)%len(p.ring)].server]
+			if server.available(now) {
+				return server, nil
+			}
+		}
+	default:
+		start := int(p.next.Add(1) % uint64(n))
+		for i := 0; i < n; i++ {
+			if server := p.servers[(start+i)%n]; server.available(now) {
+				return server, nil
+			}
+		}
+	}
+	return nil, ErrNoUpstream
+}
+
+// begin 开始向上游转发一个请求
+func (s *Server) begin() {
+	s.active.Add(1)
+}
+
+// done 请求结束，failed 表示连接失败或上游返回网关错误，连续失败达到上限时暂时摘除上游
+func (p *pool) done(s *Server, failed bool) {
+	s.active.Add(-1)
+	if !failed {
+		s.fails.Store(0)
+		return
+	}
+	if s.fails.Add(1) >= p.maxFails {
+		s.fails.Store(0)
+		s.ejectedUntil.Store(time.Now().Add(p.failTimeout).UnixNano())
+	}
+}
+
+// status 返回上游池的当前状态
+func (p *pool) status(route Route) PoolStatus {
+	now := time.Now()
+	status := PoolStatus{Route: route.Name, Balance: p.balance, HealthCheck: route.HealthCheck.Path != ""}
+	for _, server := range p.servers {
+		s := ServerStatus{
+			Address:             server.Address,
+			Healthy:             server.healthy.Load(),
+			ActiveRequests:      server.active.Load(),
+			ConsecutiveFailures: server.fails.Load(),
+		}
+		if until := server.ejectedUntil.Load(); until > now.UnixNano() {
+			t := time.Unix(0, until)
+			s.Ejected, s.EjectedUntil = true, &t
+		}
+		if lastErr := server.lastCheckError.Load(); lastErr != nil {
+			s.LastCheckError = *lastErr
+		}
+		if server.available(now) {
+			status.Available++
+		}
+		status.Servers = append(status.Servers, s)
+	}
+	return status
+}
+
+// Pools 返回全部路由上游池的当前状态
+func Pools() []PoolStatus {
+	routes := table.Load()
+	if routes == nil {
+		return []PoolStatus{}
+	}
+	statuses := make([]PoolStatus, 0, len(*routes))
+	for _, route := range *routes {
+		statuses = append(statuses, route.pool.status(route.Route))
+	}
+	return statuses
+}
+
+// upstreamSettings 决定上游池、上游连接和健康检查的路由设置
+type upstreamSettings struct {
+	Upstreams   []string
+	Balance     string
+	HealthCheck HealthCheck
+	MaxFails    int
+	FailTimeout int
+	TLS         UpstreamTLS
+	ProbeHost   string // 健康检查请求使用的Host
+}
+
+// sameUpstreams 判断两个路由的上游设置是否相同，相同时重新加载路由不影响上游池和健康检查
+func sameUpstreams(a, b Route) bool {
+	settings := func(route Route) upstreamSettings {
+		return upstreamSettings{
+			Upstreams:   route.Upstreams,
+			Balance:     route.Balance,
+			HealthCheck: route.HealthCheck,
+			MaxFails:    route.MaxFails,
+			FailTimeout: route.FailTimeout,
+			TLS:         route.TLS,
+			ProbeHost:   strings.ToLower(route.Host),
+		}
+	}
+	return reflect.DeepEqual(settings(a), settings(b))
+}
+
+// serversByAddress 按地址返回上游池中的上游
+func (p *pool) serversByAddress() map[string]*Server {
+	servers := make(map[string]*Server, len(p.servers))
+	for _, server := range p.servers {
+		servers[server.Address] = server
+	}
+	return servers
+}
+
+// startHealthCheck 为配置了健康检查的路由启动健康检查，通过 stopCheck 停止
+func startHealthCheck(route *CompiledRoute) {
+	if route.HealthCheck.Path == "" {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	route.stopCheck = cancel
+	go runHealthCheck(ctx, route)
+}
+
+// runHealthCheck 定期检查路由的全部上游，直到 ctx 取消
+func runHealthCheck(ctx context.Context, route *CompiledRoute) {
+	p := route.pool
+	check := route.HealthCheck
+	interval := time.Duration(check.Interval) * time.Second
+	if interval <= 0 {
+		interval = DefaultHealthCheckInterval * time.Second
+	}
+	timeout := time.Duration(check.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout * time.Second
+	}
+	healthyThreshold, unhealthyThreshold := int64(check.HealthyThreshold), int64(check.UnhealthyThreshold)
+	if healthyThreshold <= 0 {
+		healthyThreshold = DefaultHealthyThreshold
+	}
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = DefaultUnhealthyThreshold
+	}
+	client := &http.Client{
+		Transport: route.Transport(),
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var wg sync.WaitGroup
+		for _, server := range p.servers {
+			wg.Add(1)
+			go func(server *Server) {
+				defer wg.Done()
+				err := probe(ctx, client, route, server.Address)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					message := err.Error()
+					server.lastCheckError.Store(&message)
+					server.checkSuccesses.Store(0)
+					if server.checkFailures.Add(1) >= unhealthyThreshold {
+						server.healthy.Store(false)
+					}
+					return
+				}
+				server.lastCheckError.Store(nil)
+				server.checkFailures.Store(0)
+				if server.checkSuccesses.Add(1) >= healthyThreshold {
+					server.healthy.Store(true)
+				}
+			}(server)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe 向上游发送一次健康检查请求，路由指定了精确主机名时使用该主机名作为Host
+func probe(ctx context.Context, client *http.Client, route *CompiledRoute, address string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, route.Scheme()+"://"+address+route.HealthCheck.Path, nil)
+	if err != nil {
+		return err
+	}
+	if route.Host != "" && route.Host[0] != '*' {
+		req.Host = route.Host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("健康检查返回状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
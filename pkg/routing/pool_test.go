@@ -0,0 +1,247 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPool 创建上游池，地址为 s0、s1 ...
+func testPool(balance string, n int) *pool {
+	route := Route{Balance: balance, MaxFails: 2, FailTimeout: 60}
+	for i := 0; i < n; i++ {
+		route.Upstreams = append(route.Upstreams, fmt.Sprintf("s%d:80", i))
+	}
+	return newPool(route, nil)
+}
+
+// pickAddresses 连续选择 n 次，返回选中的地址
+func pickAddresses(t *testing.T, p *pool, clientIP string, n int) []string {
+	t.Helper()
+	var picked []string
+	for i := 0; i < n; i++ {
+		server, err := p.pick(clientIP)
+		if err != nil {
+			t.Fatalf("选择上游失败: %v", err)
+		}
+		picked = append(picked, server.Address)
+	}
+	return picked
+}
+
+func TestPickBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		balance string
+		setup   func(p *pool)
+		want    string // 连续选择4次的结果
+	}{
+		{name: "轮询", balance: BalanceRoundRobin, want: "s1:80,s2:80,s0:80,s1:80"},
+		{name: "轮询跳过不健康的上游", balance: BalanceRoundRobin, setup: func(p *pool) { p.servers[1].healthy.Store(false) }, want: "s2:80,s2:80,s0:80,s2:80"},
+		{name: "轮询跳过被摘除的上游", balance: "", setup: func(p *pool) { p.servers[2].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano()) }, want: "s1:80,s0:80,s0:80,s1:80"},
+		{name: "最少连接", balance: BalanceLeastConn, setup: func(p *pool) {
+			p.servers[0].active.Store(5)
+			p.servers[1].active.Store(1)
+			p.servers[2].active.Store(3)
+		}, want: "s1:80,s1:80,s1:80,s1:80"},
+		{name: "最少连接相同时依次分配", balance: BalanceLeastConn, want: "s1:80,s2:80,s0:80,s1:80"},
+	}
+	for _, tt := range tests {
+		p := testPool(tt.balance, 3)
+		if tt.setup != nil {
+			tt.setup(p)
+		}
+		if got := strings.Join(pickAddresses(t, p, "1.1.1.1", 4), ","); got != tt.want {
+			t.Errorf("%s: 选择了 %s，期望 %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPickConsistentHash(t *testing.T) {
+	p := testPool(BalanceConsistentHash, 4)
+	clients := make([]string, 100)
+	assigned := make(map[string]string, len(clients))
+	for i := range clients {
+		clients[i] = fmt.Sprintf("10.0.%d.%d", i/10, i%10)
+		picked := pickAddresses(t, p, clients[i], 3)
+		if picked[0] != picked[1] || picked[1] != picked[2] {
+			t.Fatalf("%s: 同一客户端应固定转发到同一上游，实际 %v", clients[i], picked)
+		}
+		assigned[clients[i]] = picked[0]
+	}
+
+	// 一个上游不可用时只影响原本分配给它的客户端
+	p.servers[0].healthy.Store(false)
+	for _, client := range clients {
+		server, _ := p.pick(client)
+		switch {
+		case server.Address == "s0:80":
+			t.Errorf("%s: 不应转发到不可用的上游", client)
+		case assigned[client] != "s0:80" && server.Address != assigned[client]:
+			t.Errorf("%s: 从 %s 改为 %s，上游可用时不应变化", client, assigned[client], server.Address)
+		}
+	}
+}
+
+func TestPassiveEjection(t *testing.T) {
+	p := testPool(BalanceRoundRobin, 2)
+	server := p.servers[0]
+
+	steps := []struct {
+		failed  bool
+		ejected bool
+	}{
+		{failed: true},
+		{failed: false}, // 成功后重新计数
+		{failed: true},
+		{failed: true, ejected: true}, // 连续失败 MaxFails 次后摘除
+	}
+	for i, step := range steps {
+		server.begin()
+		p.done(server, step.failed)
+		if ejected := !server.available(time.Now()); ejected != step.ejected {
+			t.Errorf("第 %d 步: 摘除 %v，期望 %v", i, ejected, step.ejected)
+		}
+	}
+	if server.active.Load() != 0 {
+		t.Errorf("请求结束后正在处理的请求数应为0，实际 %d", server.active.Load())
+	}
+	if !server.available(time.Now().Add(61 * time.Second)) {
+		t.Error("超过 FailTimeout 后上游应恢复")
+	}
+
+	p.servers[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	if _, err := p.pick("1.1.1.1"); !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("全部上游不可用时应返回 ErrNoUpstream，结果 %v", err)
+	}
+	if status := p.status(Route{Name: "r"}); status.Available != 0 || !status.Servers[0].Ejected {
+		t.Errorf("状态 %+v，期望全部上游被摘除", status)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" || r.Host != "www.example.com" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer backend.Close()
+	address := strings.TrimPrefix(backend.URL, "http://")
+
+	sitesMutex.Lock()
+	sites = Sites{Routes: []Route{{
+		Name:        "r",
+		Host:        "www.example.com",
+		Upstreams:   []string{address},
+		HealthCheck: HealthCheck{Path: "/health", Interval: 1, HealthyThreshold: 1, UnhealthyThreshold: 1},
+	}}}
+	rebuildTable()
+	sitesMutex.Unlock()
+	defer func() {
+		sitesMutex.Lock()
+		sites = Sites{}
+		rebuildTable()
+		sitesMutex.Unlock()
+	}()
+
+	route := Match("www.example.com", "/")
+	waitHealthy := func(want bool) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if route.pool.servers[0].healthy.Load() == want {
+				return true
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	}
+	if !waitHealthy(false) {
+		t.Fatal("健康检查失败后上游应标记为不健康")
+	}
+	if _, err := route.Pick("1.1.1.1"); !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("唯一的上游不健康时应返回 ErrNoUpstream，结果 %v", err)
+	}
+	healthy.Store(true)
+	if !waitHealthy(true) {
+		t.Fatal("健康检查恢复后上游应重新可用")
+	}
+}
+
+func TestRebuildTableKeepsUnchangedUpstreams(t *testing.T) {
+	defer func() {
+		sitesMutex.Lock()
+		sites = Sites{}
+		rebuildTable()
+		sitesMutex.Unlock()
+	}()
+	load := func(routes ...Route) map[string]*CompiledRoute {
+		sitesMutex.Lock()
+		sites = Sites{Routes: routes}
+		rebuildTable()
+		sitesMutex.Unlock()
+		byName := map[string]*CompiledRoute{}
+		for _, route := range *table.Load() {
+			byName[route.Name] = route
+		}
+		return byName
+	}
+
+	tlsRoute := Route{Name: "tls", Upstreams: []string{"127.0.0.1:1", "127.0.0.1:2"}, TLS: UpstreamTLS{Enabled: true}}
+	plain := Route{Name: "plain", Upstreams: []string{"127.0.0.1:3", "127.0.0.1:4"}}
+	before := load(tlsRoute, plain)
+	before["plain"].pool.servers[0].fails.Store(2)
+
+	// 只修改匹配条件和规则选择，修改另一个路由的上游
+	tlsRoute.PathPrefix = "/api"
+	tlsRoute.Rules = RuleSelection{Exclude: []string{"x"}}
+	plain.Upstreams = []string{"127.0.0.1:3", "127.0.0.1:5"}
+	after := load(tlsRoute, plain)
+
+	if after["tls"].pool != before["tls"].pool || after["tls"].transport != before["tls"].transport {
+		t.Error("上游设置未变化的路由应沿用原有的上游池和连接")
+	}
+	if after["tls"].PathPrefix != "/api" {
+		t.Error("路由的其他设置应当更新")
+	}
+	if after["plain"].pool == before["plain"].pool {
+		t.Error("上游变化的路由应重建上游池")
+	}
+	if after["plain"].pool.servers[0] != before["plain"].pool.servers[0] || after["plain"].pool.servers[0].fails.Load() != 2 {
+		t.Error("重建上游池时地址相同的上游应保留原有状态")
+	}
+}
+
+func TestSameUpstreams(t *testing.T) {
+	base := Route{Name: "r", Host: "a.example.com", Upstreams: []string{"h:1"}, HealthCheck: HealthCheck{Path: "/health"}}
+	tests := []struct {
+		name   string
+		modify func(*Route)
+		same   bool
+	}{
+		{name: "路径前缀", modify: func(r *Route) { r.PathPrefix = "/api" }, same: true},
+		{name: "IP策略", modify: func(r *Route) { r.IPPolicy.Deny = []string{"1.1.1.1"} }, same: true},
+		{name: "主机名大小写", modify: func(r *Route) { r.Host = "A.example.com" }, same: true},
+		{name: "上游地址", modify: func(r *Route) { r.Upstreams = []string{"h:2"} }},
+		{name: "负载均衡策略", modify: func(r *Route) { r.Balance = BalanceLeastConn }},
+		{name: "健康检查", modify: func(r *Route) { r.HealthCheck.Interval = 5 }},
+		{name: "上游TLS", modify: func(r *Route) { r.TLS.Enabled = true }},
+		{name: "健康检查使用的主机名", modify: func(r *Route) { r.Host = "b.example.com" }},
+	}
+	for _, tt := range tests {
+		route := base
+		tt.modify(&route)
+		if got := sameUpstreams(base, route); got != tt.same {
+			t.Errorf("%s: 上游设置相同 %v，期望 %v", tt.name, got, tt.same)
+		}
+	}
+}
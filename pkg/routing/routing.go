@@ -0,0 +1,368 @@
+// pkg/routing/routing.go
+
+package routing
+
+import (
+	"Stone/pkg/certs"
+	"Stone/pkg/logging"
+	"Stone/pkg/rules"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrInvalidRoute 站点路由无效
+	ErrInvalidRoute = errors.New("无效的站点路由")
+	// ErrRouteNotFound 要删除的路由不存在
+	ErrRouteNotFound = errors.New("站点路由不存在")
+)
+
+// DefaultBlockPage 未指定拦截页面时使用的文件
+const DefaultBlockPage = "blocked.html"
+
+// DefaultBlockPageDir 未配置时路由拦截页面所在的目录
+const DefaultBlockPageDir = "blockpages"
+
+// Route 站点路由，按Host和路径前缀匹配请求，决定转发的上游以及使用的规则和IP策略
+type Route struct {
+	Name        string        `bson:"name" json:"name"`
+	Host        string        `bson:"host,omitempty" json:"host,omitempty"`              // 主机名，支持 *.example.com，为空时匹配任意主机
+	PathPrefix  string        `bson:"pathprefix,omitempty" json:"path_prefix,omitempty"` // 路径前缀，为空时匹配全部路径
+	Upstreams   []string      `bson:"upstreams" json:"upstreams"`                        // 上游地址 host:port
+	Balance     string        `bson:"balance,omitempty" json:"balance,omitempty"`        // 负载均衡策略，默认 round_robin
+	HealthCheck HealthCheck   `bson:"healthcheck,omitempty" json:"health_check,omitempty"`
+	MaxFails    int           `bson:"maxfails,omitempty" json:"max_fails,omitempty"`       // 连续失败多少次后暂时摘除上游，默认 3
+	FailTimeout int           `bson:"failtimeout,omitempty" json:"fail_timeout,omitempty"` // 摘除的秒数，默认 30
+	TLS         UpstreamTLS   `bson:"tls,omitempty" json:"tls,omitempty"`                  // 以HTTPS连接上游
+	Rules       RuleSelection `bson:"rules,omitempty" json:"rules,omitempty"`
+	IPPolicy    IPPolicy      `bson:"ippolicy,omitempty" json:"ip_policy,omitempty"`
+	BlockPage   string        `bson:"blockpage,omitempty" json:"block_page,omitempty"` // 拦截页面目录下的文件名，为空时使用 blocked.html
+}
+
+// RuleSelection 路由使用的拦截规则，默认使用全部规则
+type RuleSelection struct {
+	Disabled bool     `bson:"disabled,omitempty" json:"disabled,omitempty"` // 不检查拦截规则
+	Include  []string `bson:"include,omitempty" json:"include,omitempty"`   // 只使用这些规则，为空时使用全部规则
+	Exclude  []string `bson:"exclude,omitempty" json:"exclude,omitempty"`   // 不使用这些规则
+}
+
+// IPPolicy 路由的IP策略，在全局黑名单之后检查，条目格式与IP控制规则相同
+type IPPolicy struct {
+	Allow []string `bson:"allow,omitempty" json:"allow,omitempty"` // 只允许这些IP访问，为空时不限制
+	Deny  []string `bson:"deny,omitempty" json:"deny,omitempty"`   // 拒绝这些IP访问
+}
+
+// UpstreamTLS 连接上游使用的TLS设置
+type UpstreamTLS struct {
+	Enabled            bool   `bson:"enabled,omitempty" json:"enabled,omitempty"`
+	CA                 string `bson:"ca,omitempty" json:"ca,omitempty"`                                   // 信任的CA证书的PEM内容，为空时使用系统CA
+	ServerName         string `bson:"servername,omitempty" json:"server_name,omitempty"`                  // 校验上游证书使用的主机名，为空时使用上游地址中的主机名
+	InsecureSkipVerify bool   `bson:"insecureskipverify,omitempty" json:"insecure_skip_verify,omitempty"` // 不校验上游证书，仅用于测试
+}
+
+// Sites 用于存储站点路由
+type Sites struct {
+	Routes []Route `bson:"routes" json:"routes"`
+}
+
+// CompiledRoute 预处理后用于匹配请求的路由
+type CompiledRoute struct {
+	Route
+	allow, deny *rules.IPSet
+	pool        *pool
+	transport   *http.Transport    // 以HTTPS连接上游时使用，否则为nil
+	blockPage   string             // 拦截页面的完整路径
+	stopCheck   context.CancelFunc // 停止健康检查，未配置健康检查时为nil
+	selection   atomic.Pointer[selectedRuleset]
+}
+
+// selectedRuleset 根据某个版本的规则集选出的规则，规则集替换后重新选择
+type selectedRuleset struct {
+	base     *rules.Ruleset
+	selected *rules.Ruleset
+}
+
+var (
+	sites           Sites
+	sitesMutex      sync.RWMutex
+	writeMutex      sync.Mutex // 串行化路由修改及其MongoDB写入，写入时不持有 sitesMutex，请求匹配不被阻塞
+	table           atomic.Pointer[[]*CompiledRoute]
+	mongoCollection *mongo.Collection
+	blockPageDir    = DefaultBlockPageDir
+)
+
+// baseTransport 以HTTPS连接上游时复制其连接池和超时参数
+var baseTransport = http.DefaultTransport.(*http.Transport)
+
+// SetBaseTransport 设置HTTPS上游使用的连接池和超时参数，需要在加载站点路由之前调用
+func SetBaseTransport(transport *http.Transport) {
+	baseTransport = transport
+}
+
+// SetBlockPageDirectory 设置路由拦截页面所在的目录，为空时使用 DefaultBlockPageDir，需要在加载站点路由之前调用
+func SetBlockPageDirectory(dir string) {
+	if dir == "" {
+		dir = DefaultBlockPageDir
+	}
+	blockPageDir = dir
+}
+
+// SetMongoCollection 设置MongoDB集合，站点路由与配置保存在同一集合中
+func SetMongoCollection(collection *mongo.Collection) {
+	mongoCollection = collection
+}
+
+// ValidateRoute 校验站点路由
+func ValidateRoute(route Route) error {
+	_, err := compileRoute(route)
+	return err
+}
+
+// compileRoute 校验并预处理路由
+func compileRoute(route Route) (*CompiledRoute, error) {
+	if route.Name == "" {
+		return nil, fmt.Errorf("%w: 路由名称不能为空", ErrInvalidRoute)
+	}
+	if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+		return nil, fmt.Errorf("%w: 路由 %q 的路径前缀必须以 / 开头", ErrInvalidRoute, route.Name)
+	}
+	if len(route.Upstreams) == 0 {
+		return nil, fmt.Errorf("%w: 路由 %q 至少需要一个上游地址", ErrInvalidRoute, route.Name)
+	}
+	for _, upstream := range route.Upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			return nil, fmt.Errorf("%w: 路由 %q 的上游地址 %q 必须为 host:port", ErrInvalidRoute, route.Name, upstream)
+		}
+	}
+	switch route.Balance {
+	case "", BalanceRoundRobin, BalanceLeastConn, BalanceConsistentHash:
+	default:
+		return nil, fmt.Errorf("%w: 路由 %q 的负载均衡策略 %q 无效，可选 %s、%s、%s", ErrInvalidRoute, route.Name, route.Balance,
+			BalanceRoundRobin, BalanceLeastConn, BalanceConsistentHash)
+	}
+	if route.HealthCheck.Path != "" && !strings.HasPrefix(route.HealthCheck.Path, "/") {
+		return nil, fmt.Errorf("%w: 路由 %q 的健康检查路径必须以 / 开头", ErrInvalidRoute, route.Name)
+	}
+	blockPage := DefaultBlockPage
+	if route.BlockPage != "" {
+		// 拦截页面只能是拦截页面目录下的文件，路由通过管理接口修改，不能借此读取服务器上的任意文件
+		if !isLocalPath(route.BlockPage) {
+			return nil, fmt.Errorf("%w: 路由 %q 的拦截页面必须是拦截页面目录下的相对路径，不能是绝对路径或包含 ..", ErrInvalidRoute, route.Name)
+		}
+		blockPage = filepath.Join(blockPageDir, route.BlockPage)
+		if _, err := os.Stat(blockPage); err != nil {
+			return nil, fmt.Errorf("%w: 路由 %q 的拦截页面不可用: %v", ErrInvalidRoute, route.Name, err)
+		}
+	}
+
+	compiled := &CompiledRoute{Route: route, blockPage: blockPage}
+	if route.TLS.Enabled {
+		tlsConfig, err := certs.ClientConfig(route.TLS.CA, route.TLS.ServerName, route.TLS.InsecureSkipVerify)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 路由 %q 的上游TLS设置: %v", ErrInvalidRoute, route.Name, err)
+		}
+		compiled.transport = baseTransport.Clone()
+		compiled.transport.TLSClientConfig = tlsConfig
+	}
+	compiled.Host = strings.ToLower(route.Host)
+	var err error
+	if len(route.IPPolicy.Allow) > 0 {
+		if compiled.allow, err = rules.NewIPSet(route.IPPolicy.Allow); err != nil {
+			return nil, fmt.Errorf("%w: 路由 %q 的允许名单: %v", ErrInvalidRoute, route.Name, err)
+		}
+	}
+	if len(route.IPPolicy.Deny) > 0 {
+		if compiled.deny, err = rules.NewIPSet(route.IPPolicy.Deny); err != nil {
+			return nil, fmt.Errorf("%w: 路由 %q 的拒绝名单: %v", ErrInvalidRoute, route.Name, err)
+		}
+	}
+	return compiled, nil
+}
+
+// isLocalPath 判断路径是否为不含 .. 的相对路径
+func isLocalPath(name string) bool {
+	if !filepath.IsLocal(name) {
+		return false
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == filepath.Separator }) {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadSites 从MongoDB加载站点路由，尚未创建站点文档时视为没有路由，全部请求转发到默认目标地址
+func LoadSites(ctx context.Context) (*Sites, error) {
+	var loaded Sites
+	err := mongoCollection.FindOne(ctx, bson.M{"type": "sites"}).Decode(&loaded)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("从MongoDB读取站点路由失败: %w", err)
+	}
+
+	// 无效的路由记录日志后跳过
+	valid := make([]Route, 0, len(loaded.Routes))
+	for _, route := range loaded.Routes {
+		if err := ValidateRoute(route); err != nil {
+			logging.LogError(fmt.Errorf("忽略站点路由: %w", err))
+			continue
+		}
+		valid = append(valid, route)
+	}
+
+	replaceRoutes(valid)
+
+	return &loaded, nil
+}
+
+// rebuildTable 根据当前路由重建匹配表，调用方需持有写锁
+// 上游设置未变化的同名路由沿用原有的上游池、连接和健康检查；其他路由重新启动健康检查，地址相同的上游保留原有状态
+func rebuildTable() {
+	previous := make(map[string]*CompiledRoute)
+	if routes := table.Load(); routes != nil {
+		for _, route := range *routes {
+			previous[route.Name] = route
+		}
+	}
+
+	compiled := make([]*CompiledRoute, 0, len(sites.Routes))
+	var started []*CompiledRoute
+	for _, route := range sites.Routes {
+		c, err := compileRoute(route)
+		if err != nil {
+			continue
+		}
+		old, found := previous[route.Name]
+		switch {
+		case found && sameUpstreams(old.Route, route):
+			c.pool, c.transport, c.stopCheck = old.pool, old.transport, old.stopCheck
+			delete(previous, route.Name)
+		case found:
+			c.pool = newPool(route, old.pool.serversByAddress())
+			started = append(started, c)
+		default:
+			c.pool = newPool(route, nil)
+			started = append(started, c)
+		}
+		compiled = append(compiled, c)
+	}
+	table.Store(&compiled)
+
+	// 停止已删除或修改了上游设置的路由的健康检查，再为新的路由启动
+	for _, old := range previous {
+		if old.stopCheck != nil {
+			old.stopCheck()
+		}
+		if old.transport != nil {
+			old.transport.CloseIdleConnections()
+		}
+	}
+	for _, c := range started {
+		startHealthCheck(c)
+	}
+}
+
+// GetSites 获取当前站点路由
+func GetSites() Sites {
+	sitesMutex.RLock()
+	defer sitesMutex.RUnlock()
+	return sites
+}
+
+// GetRoute 获取特定名称的路由
+func GetRoute(name string) (Route, bool) {
+	sitesMutex.RLock()
+	defer sitesMutex.RUnlock()
+
+	for _, route := range sites.Routes {
+		if route.Name == name {
+			return route, true
+		}
+	}
+	return Route{}, false
+}
+
+// AddRoute 添加站点路由，同名路由会被替换
+func AddRoute(route Route) error {
+	if err := ValidateRoute(route); err != nil {
+		return err
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	current := GetSites().Routes
+	routes := make([]Route, 0, len(current)+1)
+	for _, existing := range current {
+		if existing.Name != route.Name {
+			routes = append(routes, existing)
+		}
+	}
+	routes = append(routes, route)
+
+	// 先更新MongoDB中的站点路由，写入成功后再重建匹配表
+	if err := saveSites(routes); err != nil {
+		return err
+	}
+	replaceRoutes(routes)
+	return nil
+}
+
+// DeleteRoute 删除特定名称的路由，路由不存在时返回 ErrRouteNotFound
+func DeleteRoute(name string) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	current := GetSites().Routes
+	routes := make([]Route, 0, len(current))
+	for _, route := range current {
+		if route.Name != name {
+			routes = append(routes, route)
+		}
+	}
+	if len(routes) == len(current) {
+		return fmt.Errorf("%w: %q", ErrRouteNotFound, name)
+	}
+
+	// 先更新MongoDB中的站点路由，写入成功后再重建匹配表
+	if err := saveSites(routes); err != nil {
+		return err
+	}
+	replaceRoutes(routes)
+	return nil
+}
+
+// replaceRoutes 替换当前路由并重建匹配表
+func replaceRoutes(routes []Route) {
+	sitesMutex.Lock()
+	sites = Sites{Routes: routes}
+	rebuildTable()
+	sitesMutex.Unlock()
+}
+
+// saveSites 将站点路由写回MongoDB，调用方需持有 writeMutex，不能持有 sitesMutex
+func saveSites(routes []Route) error {
+	_, err := mongoCollection.UpdateOne(
+		context.Background(),
+		bson.M{"type": "sites"},
+		bson.M{
+			"$set": bson.M{
+				"routes": routes,
+			},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
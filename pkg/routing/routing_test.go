@@ -0,0 +1,61 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAddDeleteRoute(t *testing.T) {
+	defer func() {
+		replaceRoutes(nil)
+		SetMongoCollection(nil)
+	}()
+	api := Route{Name: "api", Host: "api.example.com", Upstreams: []string{"127.0.0.1:8080"}}
+	matched := func(host string) string {
+		if route := Match(host, "/"); route != nil {
+			return route.Name
+		}
+		return ""
+	}
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("写入成功后重建匹配表", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		replaceRoutes([]Route{api})
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		www := Route{Name: "www", Host: "www.example.com", Upstreams: []string{"127.0.0.1:8081"}}
+		if err := AddRoute(www); err != nil {
+			mt.Fatalf("添加路由失败: %v", err)
+		}
+		if matched("www.example.com") != "www" || len(GetSites().Routes) != 2 {
+			mt.Errorf("添加后应匹配新路由，路由 %+v", GetSites().Routes)
+		}
+		if err := DeleteRoute("www"); err != nil || matched("www.example.com") != "" {
+			mt.Errorf("删除路由失败: %v", err)
+		}
+		if err := DeleteRoute("www"); !errors.Is(err, ErrRouteNotFound) {
+			mt.Errorf("删除不存在的路由应返回 ErrRouteNotFound，结果 %v", err)
+		}
+	})
+	mt.Run("写入失败时保留原路由", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		replaceRoutes([]Route{api})
+		mt.AddMockResponses(
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}),
+			mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}),
+		)
+
+		if err := AddRoute(Route{Name: "www", Host: "www.example.com", Upstreams: []string{"127.0.0.1:8081"}}); err == nil {
+			mt.Error("写入失败时应返回错误")
+		}
+		if err := DeleteRoute("api"); err == nil {
+			mt.Error("写入失败时应返回错误")
+		}
+		if matched("www.example.com") != "" || matched("api.example.com") != "api" || len(GetSites().Routes) != 1 {
+			mt.Errorf("写入失败后路由 %+v，期望保持不变", GetSites().Routes)
+		}
+	})
+}
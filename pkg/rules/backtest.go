@@ -0,0 +1,303 @@
+// pkg/rules/backtest.go
+
+package rules
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BlockedLogMessage 被拦截规则拦截的请求在流量日志中的错误信息
+const BlockedLogMessage = "Blocked by rules"
+
+// 回放的默认参数
+const (
+	DefaultBacktestLimit = 100000 // 单次最多回放的日志条数
+	MaxBacktestLimit     = 1000000
+	defaultBacktestTop   = 10
+	backtestSamples      = 20 // 每类变化保留的示例请求数
+)
+
+// ErrInvalidBacktest 回放参数无效
+var ErrInvalidBacktest = errors.New("无效的回放参数")
+
+// BacktestOptions 回放参数
+type BacktestOptions struct {
+	Start time.Time
+	End   time.Time
+	Limit int // 最多回放的日志条数，0 使用 DefaultBacktestLimit
+	Top   int // 排行榜的条数，0 使用默认值
+}
+
+// BacktestChange 回放结果与原结果不同的一个请求
+type BacktestChange struct {
+	Timestamp time.Time `json:"timestamp"`
+	ClientIP  string    `json:"client_ip"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Rule      string    `json:"rule,omitempty"` // 新拦截时为候选规则中拦截的规则，新放行时为原先拦截的规则
+}
+
+// CountItem 排行榜中的一项
+type CountItem struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// BacktestReport 回放报告
+type BacktestReport struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Replayed  int       `json:"replayed"`  // 经过规则检查的请求数
+	Skipped   int       `json:"skipped"`   // 被黑名单、封禁、限流等在规则检查之前处理，或来自白名单IP的请求
+	Truncated bool      `json:"truncated"` // 时间范围内的日志超过回放上限，只回放了最早的部分
+
+	PreviouslyBlocked int            `json:"previously_blocked"`
+	Blocked           int            `json:"blocked"` // 候选规则下会被拦截的请求数
+	NewlyBlocked      int            `json:"newly_blocked"`
+	NewlyPassed       int            `json:"newly_passed"`
+	RuleHits          map[string]int `json:"rule_hits"` // 候选规则中每条规则拦截的请求数
+
+	TopNewlyBlockedURLs []CountItem `json:"top_newly_blocked_urls"`
+	TopNewlyBlockedIPs  []CountItem `json:"top_newly_blocked_ips"`
+	TopNewlyPassedURLs  []CountItem `json:"top_newly_passed_urls"`
+	TopNewlyPassedIPs   []CountItem `json:"top_newly_passed_ips"`
+
+	NewlyBlockedSamples []BacktestChange `json:"newly_blocked_samples"`
+	NewlyPassedSamples  []BacktestChange `json:"newly_passed_samples"`
+}
+
+// Backtest 用规则集回放时间范围内的流量日志，与日志中记录的结果比较
+// 日志中不保存请求体和Host，匹配这两个位置的规则在回放中不会命中；按拦截模式评估，不受检测模式影响
+// 白名单和黑名单取每条日志当时生效的版本，没有版本历史时使用当前名单
+func Backtest(ctx context.Context, ruleset *Ruleset, opts BacktestOptions) (*BacktestReport, error) {
+	if opts.Start.IsZero() || opts.End.IsZero() || opts.End.Before(opts.Start) {
+		return nil, fmt.Errorf("%w: 必须指定开始时间和结束时间，且结束时间不能早于开始时间", ErrInvalidBacktest)
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultBacktestLimit
+	}
+	if opts.Limit > MaxBacktestLimit {
+		opts.Limit = MaxBacktestLimit
+	}
+	if opts.Top <= 0 {
+		opts.Top = defaultBacktestTop
+	}
+
+	lists, err := loadIPListHistory(ctx, opts.Start, opts.End)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BacktestReport{Start: opts.Start, End: opts.End, RuleHits: map[string]int{}}
+	checkOpts := CheckOptions{AnomalyThreshold: AnomalyThreshold()}
+	blockedURLs, blockedIPs := map[string]int{}, map[string]int{}
+	passedURLs, passedIPs := map[string]int{}, map[string]int{}
+
+	// 多取一条用于判断是否超过上限
+	seen := 0
+	err = logging.ForEachTrafficLog(ctx, opts.Start, opts.End, int64(opts.Limit)+1, func(entry logging.TrafficLog) error {
+		if seen++; seen > opts.Limit {
+			report.Truncated = true
+			return nil
+		}
+
+		// 只有放行或被规则拦截的请求经过了规则检查
+		// 旧版本写入的日志没有 blocked 字段，按错误信息判断
+		previouslyBlocked := entry.Blocked || entry.Error == BlockedLogMessage
+		if entry.Error != "" && !previouslyBlocked {
+			report.Skipped++
+			return nil
+		}
+		if lists.listed(entry.ClientIP, entry.Timestamp) {
+			report.Skipped++
+			return nil
+		}
+		req, err := requestFromLog(entry)
+		if err != nil {
+			report.Skipped++
+			return nil
+		}
+
+		report.Replayed++
+		result := ruleset.Check(req, nil, checkOpts)
+		change := BacktestChange{
+			Timestamp: entry.Timestamp,
+			ClientIP:  entry.ClientIP,
+			Method:    entry.Method,
+			URL:       req.URL.RequestURI(),
+		}
+		if previouslyBlocked {
+			report.PreviouslyBlocked++
+		}
+		if result.Blocked {
+			report.Blocked++
+			if result.Match != nil {
+				report.RuleHits[result.Match.Rule]++
+				change.Rule = result.Match.Rule
+			}
+		}
+
+		switch {
+		case result.Blocked && !previouslyBlocked:
+			report.NewlyBlocked++
+			blockedURLs[req.URL.Path]++
+			blockedIPs[entry.ClientIP]++
+			if len(report.NewlyBlockedSamples) < backtestSamples {
+				report.NewlyBlockedSamples = append(report.NewlyBlockedSamples, change)
+			}
+		case !result.Blocked && previouslyBlocked:
+			report.NewlyPassed++
+			passedURLs[req.URL.Path]++
+			passedIPs[entry.ClientIP]++
+			change.Rule = entry.Rule
+			if len(report.NewlyPassedSamples) < backtestSamples {
+				report.NewlyPassedSamples = append(report.NewlyPassedSamples, change)
+			}
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.TopNewlyBlockedURLs = topCounts(blockedURLs, opts.Top)
+	report.TopNewlyBlockedIPs = topCounts(blockedIPs, opts.Top)
+	report.TopNewlyPassedURLs = topCounts(passedURLs, opts.Top)
+	report.TopNewlyPassedIPs = topCounts(passedIPs, opts.Top)
+	return report, nil
+}
+
+// ipListHistory 回放期间按时间顺序还原每个时刻生效的白名单和黑名单
+type ipListHistory struct {
+	snapshot  RulesSnapshot
+	pending   []RuleVersion // 回放范围内尚未应用的版本，按版本号升序
+	whitelist *ipSet
+	blacklist *ipSet
+}
+
+// loadIPListHistory 读取开始时间生效的名单和时间范围内的名单变化
+// 开始时间早于第一个版本时从第一个版本开始，没有版本历史时使用当前名单
+func loadIPListHistory(ctx context.Context, start, end time.Time) (*ipListHistory, error) {
+	history := &ipListHistory{}
+	if historyCollection == nil {
+		// 当前的前缀树会被原地修改，复制名单后另行构建
+		rulesMutex.RLock()
+		history.snapshot = currentSnapshot()
+		rulesMutex.RUnlock()
+		history.advance(start)
+		return history, nil
+	}
+
+	var base RuleVersion
+	err := historyCollection.FindOne(ctx,
+		bson.M{"created_at": bson.M{"$lte": start}},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"version": 1}),
+	).Decode(&base)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+	case err != nil:
+		return nil, err
+	default:
+		snapshot, err := loadSnapshot(ctx, base.Version)
+		if err != nil {
+			return nil, err
+		}
+		history.snapshot = *snapshot
+	}
+
+	// 只需要名单的差异，拦截规则的差异和检查点中的全部规则不读取
+	cursor, err := historyCollection.Find(ctx,
+		bson.M{"version": bson.M{"$gt": base.Version}, "created_at": bson.M{"$lte": end}},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}).
+			SetProjection(bson.M{"version": 1, "created_at": 1, "diff.whitelist": 1, "diff.blacklist": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &history.pending); err != nil {
+		return nil, err
+	}
+	if base.Version == 0 && len(history.pending) > 0 {
+		history.pending[0].CreatedAt = time.Time{} // 第一个版本记录的是开始记录历史时已有的名单
+	}
+	history.advance(start)
+	return history, nil
+}
+
+// advance 应用 t 之前的名单变化，名单有变化时重建前缀树
+func (h *ipListHistory) advance(t time.Time) {
+	changed := h.whitelist == nil
+	for len(h.pending) > 0 && !h.pending[0].CreatedAt.After(t) {
+		diff := h.pending[0].Diff
+		h.snapshot.Whitelist = applyIPListDiff(h.snapshot.Whitelist, diff.Whitelist)
+		h.snapshot.Blacklist = applyIPListDiff(h.snapshot.Blacklist, diff.Blacklist)
+		h.pending = h.pending[1:]
+		changed = true
+	}
+	if changed {
+		h.whitelist, _ = newIPSet(ipList(h.snapshot.Whitelist))
+		h.blacklist, _ = newIPSet(ipList(h.snapshot.Blacklist))
+	}
+}
+
+// listed 判断IP在 t 时刻是否在白名单或黑名单中，这些请求不经过规则检查，t 需按时间顺序递增
+func (h *ipListHistory) listed(ip string, t time.Time) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	h.advance(t)
+	if _, found := h.blacklist.lookup(addr, t); found {
+		return true
+	}
+	_, found := h.whitelist.lookup(addr, t)
+	return found
+}
+
+// requestFromLog 根据日志还原请求，放行的请求记录的是转发后的绝对URL，只使用其路径和查询参数
+func requestFromLog(entry logging.TrafficLog) (*http.Request, error) {
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme, u.Host = "", ""
+	return &http.Request{
+		Method:     entry.Method,
+		URL:        u,
+		RequestURI: u.RequestURI(),
+		Header:     http.Header(entry.Headers),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+	}, nil
+}
+
+// topCounts 返回次数最多的 n 项，次数相同时按名称排序
+func topCounts(counts map[string]int, n int) []CountItem {
+	items := make([]CountItem, 0, len(counts))
+	for key, count := range counts {
+		items = append(items, CountItem{Key: key, Count: count})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+	return items
+}
@@ -0,0 +1,199 @@
+package rules
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// trafficLogDoc 构造模拟的流量日志文档
+func trafficLogDoc(ts time.Time, ip, url, errorMsg string, blocked bool, rule string) bson.D {
+	doc := bson.D{
+		{Key: "timestamp", Value: ts},
+		{Key: "client_ip", Value: ip},
+		{Key: "url", Value: url},
+		{Key: "method", Value: "GET"},
+		{Key: "headers", Value: bson.D{{Key: "User-Agent", Value: bson.A{"test"}}}},
+		{Key: "error", Value: errorMsg},
+		{Key: "rule", Value: rule},
+	}
+	if blocked {
+		doc = append(doc, bson.E{Key: "blocked", Value: true})
+	}
+	return doc
+}
+
+func TestBacktest(t *testing.T) {
+	defer func() {
+		resetRules()
+		logging.SetMongoCollection(nil)
+	}()
+	rulesMutex.Lock()
+	ipControlRules = IPControlRules{Whitelist: ipEntries("10.9.9.9")}
+	rebuildIPSets()
+	rulesMutex.Unlock()
+	ruleset, err := CompileRuleset([]Pattern{{Name: "新规则", Regex: "attack", Targets: []string{"args"}}})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("与原结果比较", func(mt *mtest.T) {
+		logging.SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "stone.logs", mtest.FirstBatch,
+			trafficLogDoc(start.Add(1*time.Minute), "1.1.1.1", "/?q=old", BlockedLogMessage, true, "旧规则"),
+			trafficLogDoc(start.Add(2*time.Minute), "2.2.2.2", "http://backend:8080/x?q=attack", "", false, ""),
+			trafficLogDoc(start.Add(3*time.Minute), "3.3.3.3", "/?q=attack", "IP in blacklist", false, ""),
+			trafficLogDoc(start.Add(4*time.Minute), "10.9.9.9", "/?q=attack", "", false, ""),
+			trafficLogDoc(start.Add(5*time.Minute), "4.4.4.4", "/?q=hello", "", false, ""),
+		))
+		report, err := Backtest(context.Background(), ruleset, BacktestOptions{Start: start, End: start.Add(time.Hour)})
+		if err != nil {
+			mt.Fatalf("回放失败: %v", err)
+		}
+
+		tests := []struct {
+			name      string
+			got, want int
+		}{
+			{name: "回放", got: report.Replayed, want: 3},
+			{name: "跳过黑名单和白名单", got: report.Skipped, want: 2},
+			{name: "原先拦截", got: report.PreviouslyBlocked, want: 1},
+			{name: "候选规则拦截", got: report.Blocked, want: 1},
+			{name: "新拦截", got: report.NewlyBlocked, want: 1},
+			{name: "新放行", got: report.NewlyPassed, want: 1},
+		}
+		for _, tt := range tests {
+			if tt.got != tt.want {
+				mt.Errorf("%s: %d，期望 %d", tt.name, tt.got, tt.want)
+			}
+		}
+		if !reflect.DeepEqual(report.RuleHits, map[string]int{"新规则": 1}) {
+			mt.Errorf("规则命中 %v", report.RuleHits)
+		}
+		if len(report.NewlyBlockedSamples) != 1 || report.NewlyBlockedSamples[0].URL != "/x?q=attack" || report.NewlyBlockedSamples[0].Rule != "新规则" {
+			mt.Errorf("新拦截示例 %+v，期望只保留路径和查询参数", report.NewlyBlockedSamples)
+		}
+		if len(report.NewlyPassedSamples) != 1 || report.NewlyPassedSamples[0].Rule != "旧规则" {
+			mt.Errorf("新放行示例 %+v，期望记录原先拦截的规则", report.NewlyPassedSamples)
+		}
+		if want := []CountItem{{Key: "/x", Count: 1}}; !reflect.DeepEqual(report.TopNewlyBlockedURLs, want) {
+			mt.Errorf("新拦截URL排行 %v，期望 %v", report.TopNewlyBlockedURLs, want)
+		}
+	})
+	mt.Run("旧格式的日志", func(mt *mtest.T) {
+		logging.SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "stone.logs", mtest.FirstBatch,
+			trafficLogDoc(start.Add(1*time.Minute), "1.1.1.1", "/?q=attack", BlockedLogMessage, false, ""),
+			trafficLogDoc(start.Add(2*time.Minute), "2.2.2.2", "/?q=hello", BlockedLogMessage, false, ""),
+		))
+		report, err := Backtest(context.Background(), ruleset, BacktestOptions{Start: start, End: start.Add(time.Hour)})
+		if err != nil {
+			mt.Fatalf("回放失败: %v", err)
+		}
+		if report.Replayed != 2 || report.Skipped != 0 || report.PreviouslyBlocked != 2 || report.NewlyBlocked != 0 || report.NewlyPassed != 1 {
+			mt.Errorf("结果 %+v，期望没有 blocked 字段的日志按错误信息判断为原先拦截", report)
+		}
+	})
+	mt.Run("超过回放上限", func(mt *mtest.T) {
+		logging.SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "stone.logs", mtest.FirstBatch,
+			trafficLogDoc(start, "1.1.1.1", "/", "", false, ""),
+			trafficLogDoc(start, "1.1.1.1", "/", "", false, ""),
+		))
+		report, err := Backtest(context.Background(), ruleset, BacktestOptions{Start: start, End: start.Add(time.Hour), Limit: 1})
+		if err != nil || !report.Truncated || report.Replayed != 1 {
+			mt.Errorf("结果 %+v(%v)，期望只回放1条并标记截断", report, err)
+		}
+	})
+
+	if _, err := Backtest(context.Background(), ruleset, BacktestOptions{Start: start, End: start.Add(-time.Hour)}); !errors.Is(err, ErrInvalidBacktest) {
+		t.Errorf("结束时间早于开始时间应返回 ErrInvalidBacktest，结果 %v", err)
+	}
+}
+
+func TestIPListHistory(t *testing.T) {
+	defer SetHistoryCollection(nil)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("按时间应用名单变化", func(mt *mtest.T) {
+		SetHistoryCollection(mt.Coll)
+		checkpoint := RuleVersion{Version: 3, CreatedAt: start.Add(-time.Hour), Snapshot: &RulesSnapshot{Whitelist: ipEntries("10.0.0.1")}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, bson.D{{Key: "version", Value: int64(3)}}),
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, versionDoc(t, checkpoint)),
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch,
+				versionDoc(t, RuleVersion{Version: 4, CreatedAt: start.Add(10 * time.Minute), Diff: VersionDiff{
+					Whitelist: IPListDiff{Removed: ipEntries("10.0.0.1")},
+					Blacklist: IPListDiff{Added: ipEntries("10.0.0.2")},
+				}}),
+				versionDoc(t, RuleVersion{Version: 5, CreatedAt: start.Add(20 * time.Minute), Diff: VersionDiff{
+					Whitelist: IPListDiff{Added: ipEntries("10.0.0.3")},
+				}}),
+			),
+		)
+		lists, err := loadIPListHistory(context.Background(), start, start.Add(time.Hour))
+		if err != nil {
+			mt.Fatalf("读取名单历史失败: %v", err)
+		}
+
+		// 日志按时间顺序回放
+		tests := []struct {
+			ip     string
+			offset time.Duration
+			listed bool
+		}{
+			{ip: "10.0.0.1", offset: 0, listed: true},
+			{ip: "10.0.0.2", offset: 5 * time.Minute},
+			{ip: "10.0.0.1", offset: 10 * time.Minute},
+			{ip: "10.0.0.2", offset: 10 * time.Minute, listed: true},
+			{ip: "10.0.0.3", offset: 15 * time.Minute},
+			{ip: "10.0.0.3", offset: 30 * time.Minute, listed: true},
+			{ip: "invalid", offset: 30 * time.Minute},
+		}
+		for _, tt := range tests {
+			if got := lists.listed(tt.ip, start.Add(tt.offset)); got != tt.listed {
+				mt.Errorf("%s@%v: 在名单中 %v，期望 %v", tt.ip, tt.offset, got, tt.listed)
+			}
+		}
+	})
+	mt.Run("开始时间早于第一个版本", func(mt *mtest.T) {
+		SetHistoryCollection(mt.Coll)
+		first := RuleVersion{Version: 1, CreatedAt: start.Add(time.Hour), Diff: VersionDiff{Whitelist: IPListDiff{Added: ipEntries("10.0.0.1")}}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch),
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, versionDoc(t, first)),
+		)
+		lists, err := loadIPListHistory(context.Background(), start, start.Add(2*time.Hour))
+		if err != nil {
+			mt.Fatalf("读取名单历史失败: %v", err)
+		}
+		if !lists.listed("10.0.0.1", start) {
+			mt.Error("第一个版本之前应使用第一个版本的名单")
+		}
+	})
+}
+
+func TestTopCounts(t *testing.T) {
+	counts := map[string]int{"/a": 3, "/b": 1, "/c": 3, "/d": 2}
+	tests := []struct {
+		n    int
+		want []CountItem
+	}{
+		{n: 2, want: []CountItem{{Key: "/a", Count: 3}, {Key: "/c", Count: 3}}},
+		{n: 10, want: []CountItem{{Key: "/a", Count: 3}, {Key: "/c", Count: 3}, {Key: "/d", Count: 2}, {Key: "/b", Count: 1}}},
+	}
+	for _, tt := range tests {
+		if got := topCounts(counts, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("前 %d 项: %v，期望 %v", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// pkg/rules/body.go
+
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrBodyLimit 结构化包体超出解析限制
+var ErrBodyLimit = errors.New("请求体超出解析限制")
+
+// BodyLimitRule 包体超出解析限制时命中的内置规则名称，只在有规则匹配表单、JSON或上传文件时生效，可以像普通规则一样在路由中选择或排除
+const BodyLimitRule = "request-body-limits"
+
+// maxFormValueSize 单个multipart表单字段参与匹配的最大字节数
+const maxFormValueSize = 64 << 10
+
+// BodyLimits 结构化包体的解析限制
+type BodyLimits struct {
+	MaxDepth  int `bson:"maxdepth" json:"max_depth"`   // JSON最大嵌套深度
+	MaxFields int `bson:"maxfields" json:"max_fields"` // 字段总数上限：JSON叶子字段、表单字段和上传文件合计
+}
+
+// DefaultBodyLimits 未配置时使用的解析限制
+var DefaultBodyLimits = BodyLimits{MaxDepth: 32, MaxFields: 1000}
+
+var bodyLimits atomic.Pointer[BodyLimits]
+
+func init() {
+	bodyLimits.Store(&DefaultBodyLimits)
+}
+
+// SetBodyLimits 设置结构化包体的解析限制，未设置（0）的项使用默认值
+func SetBodyLimits(limits BodyLimits) {
+	if limits.MaxDepth <= 0 {
+		limits.MaxDepth = DefaultBodyLimits.MaxDepth
+	}
+	if limits.MaxFields <= 0 {
+		limits.MaxFields = DefaultBodyLimits.MaxFields
+	}
+	bodyLimits.Store(&limits)
+}
+
+// uploadedFile multipart请求中上传的文件
+type uploadedFile struct {
+	field       string
+	filename    string
+	contentType string
+}
+
+// parsedBody 按Content-Type解析后的包体
+type parsedBody struct {
+	form  url.Values
+	json  []matchValue
+	files []uploadedFile
+	err   error // 解析失败或超出限制的原因
+}
+
+// parseBody 按Content-Type解析JSON、urlencoded表单和multipart包体
+func parseBody(contentType string, body []byte, limits BodyLimits) *parsedBody {
+	parsed := &parsedBody{}
+	if len(body) == 0 {
+		return parsed
+	}
+
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		parsed.err = parsed.parseJSON(body, limits)
+	case mediaType == "application/x-www-form-urlencoded":
+		parsed.err = parsed.parseForm(body, limits)
+	case mediaType == "multipart/form-data":
+		parsed.err = parsed.parseMultipart(body, params["boundary"], limits)
+	}
+	return parsed
+}
+
+// fieldCount 已解析的字段总数
+func (p *parsedBody) fieldCount() int {
+	count := len(p.json) + len(p.files)
+	for _, values := range p.form {
+		count += len(values)
+	}
+	return count
+}
+
+func (p *parsedBody) parseForm(body []byte, limits BodyLimits) error {
+	if n := bytes.Count(body, []byte("&")) + 1; n > limits.MaxFields {
+		return fmt.Errorf("%w: 表单字段数 %d 超过上限 %d", ErrBodyLimit, n, limits.MaxFields)
+	}
+	form, err := url.ParseQuery(string(body))
+	p.form = form
+	return err
+}
+
+func (p *parsedBody) parseMultipart(body []byte, boundary string, limits BodyLimits) error {
+	if boundary == "" {
+		return errors.New("multipart请求缺少boundary")
+	}
+
+	p.form = url.Values{}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// 包体可能只读取了检测窗口内的部分，截断处之前解析出的字段仍然有效
+			return err
+		}
+		if p.fieldCount() >= limits.MaxFields {
+			return fmt.Errorf("%w: multipart字段数超过上限 %d", ErrBodyLimit, limits.MaxFields)
+		}
+
+		if part.FileName() != "" {
+			p.files = append(p.files, uploadedFile{
+				field:       part.FormName(),
+				filename:    part.FileName(),
+				contentType: part.Header.Get("Content-Type"),
+			})
+			continue
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFormValueSize))
+		if err != nil {
+			return err
+		}
+		p.form.Add(part.FormName(), string(value))
+	}
+}
+
+// parseJSON 流式解析JSON，将叶子字段展开为 "a.b.0.c" 形式，同时检查嵌套深度和字段数
+func (p *parsedBody) parseJSON(body []byte, limits BodyLimits) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return p.walkJSON(decoder, "", 0, limits)
+}
+
+func (p *parsedBody) walkJSON(decoder *json.Decoder, path string, depth int, limits BodyLimits) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	switch v := token.(type) {
+	case json.Delim:
+		if depth+1 > limits.MaxDepth {
+			return fmt.Errorf("%w: JSON嵌套深度超过上限 %d", ErrBodyLimit, limits.MaxDepth)
+		}
+		for i := 0; decoder.More(); i++ {
+			key := strconv.Itoa(i)
+			if v == '{' {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				key, _ = keyToken.(string)
+			}
+			if err := p.walkJSON(decoder, join(key), depth+1, limits); err != nil {
+				return err
+			}
+		}
+		_, err := decoder.Token() // 结束符 } 或 ]
+		return err
+	case string:
+		p.json = append(p.json, matchValue{location: path, value: v})
+	case json.Number:
+		p.json = append(p.json, matchValue{location: path, value: v.String()})
+	case bool:
+		p.json = append(p.json, matchValue{location: path, value: strconv.FormatBool(v)})
+	}
+
+	if len(p.json) > limits.MaxFields {
+		return fmt.Errorf("%w: JSON字段数超过上限 %d", ErrBodyLimit, limits.MaxFields)
+	}
+	return nil
+}
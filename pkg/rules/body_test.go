@@ -0,0 +1,132 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBody(t *testing.T) {
+	var multipartBody bytes.Buffer
+	writer := multipart.NewWriter(&multipartBody)
+	writer.WriteField("comment", "hello")
+	part, _ := writer.CreateFormFile("avatar", "shell.php")
+	part.Write([]byte("<?php"))
+	writer.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        map[string]string // 位置 -> 取值
+		limitErr    bool
+	}{
+		{
+			name:        "JSON字段展开",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"user":{"name":"alice","tags":["a",1,true]},"n":null}`,
+			want:        map[string]string{"json:user.name": "alice", "json:user.tags.0": "a", "json:user.tags.1": "1", "json:user.tags.2": "true"},
+		},
+		{
+			name:        "+json后缀",
+			contentType: "application/vnd.api+json",
+			body:        `{"q":"x"}`,
+			want:        map[string]string{"json:q": "x"},
+		},
+		{
+			name:        "urlencoded表单",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "a=1&b=%3Cscript%3E",
+			want:        map[string]string{"form:a": "1", "form:b": "<script>"},
+		},
+		{
+			name:        "multipart表单和文件",
+			contentType: writer.FormDataContentType(),
+			body:        multipartBody.String(),
+			want:        map[string]string{"form:comment": "hello", "files:avatar": "shell.php", "file_types:avatar": "application/octet-stream"},
+		},
+		{
+			name:        "JSON嵌套过深",
+			contentType: "application/json",
+			body:        strings.Repeat("[", 40) + strings.Repeat("]", 40),
+			limitErr:    true,
+		},
+		{
+			name:        "表单字段过多",
+			contentType: "application/x-www-form-urlencoded",
+			body:        strings.Repeat("a=1&", 1000) + "a=1",
+			limitErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", tt.contentType)
+		rd := newRequestData(req, []byte(tt.body))
+
+		if err := rd.structuredBody().err; errors.Is(err, ErrBodyLimit) != tt.limitErr {
+			t.Errorf("%s: 解析错误 %v，期望超出限制 %v", tt.name, err, tt.limitErr)
+			continue
+		}
+		got := map[string]string{}
+		for _, kind := range []string{TargetJSON, TargetForm, TargetFiles, TargetFileTypes} {
+			for _, v := range (target{kind: kind}).values(rd) {
+				got[v.location] = v.value
+			}
+		}
+		for location, value := range tt.want {
+			if got[location] != value {
+				t.Errorf("%s: %s = %q，期望 %q（全部取值 %v）", tt.name, location, got[location], value, got)
+			}
+		}
+	}
+}
+
+func TestBodyLimitRule(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "sqli", Regex: `(?i)union\s+select`, Targets: []string{"path"}},
+		{Name: "json", Regex: `<script`, Targets: []string{"json"}},
+		{Name: "json-disabled", Regex: `<script`, Targets: []string{"json"}, Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+	pathOnly, _ := CompileRuleset([]Pattern{{Name: "sqli", Regex: `(?i)union\s+select`, Targets: []string{"path"}}})
+	disabled, _ := CompileRuleset([]Pattern{{Name: "json-disabled", Regex: `<script`, Targets: []string{"json"}, Disabled: true}})
+	empty, _ := CompileRuleset(nil)
+
+	// 超过字段数上限的JSON数组
+	array := []byte("[" + strings.Repeat("1,", DefaultBodyLimits.MaxFields) + "1]")
+	tests := []struct {
+		name       string
+		ruleset    *Ruleset
+		opts       CheckOptions
+		blocked    bool
+		detections int
+	}{
+		{name: "匹配JSON字段的规则集", ruleset: ruleset, blocked: true},
+		{name: "检测模式只记录", ruleset: ruleset, opts: CheckOptions{DetectOnly: true}, detections: 1},
+		{name: "空规则集不解析包体", ruleset: &Ruleset{}},
+		{name: "编译出的空规则集", ruleset: empty},
+		{name: "没有规则匹配结构化包体", ruleset: pathOnly},
+		{name: "匹配JSON字段的规则已停用", ruleset: disabled},
+		{name: "选择时排除内置规则", ruleset: ruleset.Select(nil, []string{BodyLimitRule})},
+		{name: "选择的规则不包含内置规则", ruleset: ruleset.Select([]string{"json"}, nil)},
+		{name: "选择时包含内置规则", ruleset: ruleset.Select([]string{"json", BodyLimitRule}, nil), blocked: true},
+		{name: "排除匹配JSON字段的规则", ruleset: ruleset.Select(nil, []string{"json"})},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("Content-Type", "application/json")
+		result := tt.ruleset.Check(req, array, tt.opts)
+		if result.Blocked != tt.blocked || len(result.Detections) != tt.detections {
+			t.Errorf("%s: 结果 %+v，期望拦截 %v 记录 %d 条", tt.name, result, tt.blocked, tt.detections)
+			continue
+		}
+		if tt.blocked && result.Match.Rule != BodyLimitRule {
+			t.Errorf("%s: 被 %q 拦截，期望内置规则 %q", tt.name, result.Match.Rule, BodyLimitRule)
+		}
+	}
+}
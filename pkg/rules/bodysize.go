@@ -0,0 +1,141 @@
+// pkg/rules/bodysize.go
+
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ErrBodyTooLarge 请求体超过大小上限
+var ErrBodyTooLarge = errors.New("请求体超过大小上限")
+
+// 请求体超过大小上限时的动作
+const (
+	OversizeBlock = "block" // 以413拒绝请求
+	OversizePass  = "pass"  // 不检查包体，直接转发
+)
+
+// BodySizeLimit 请求体大小限制，Host 和 PathPrefix 为空时匹配全部请求
+type BodySizeLimit struct {
+	Host        string `bson:"host" json:"host"`
+	PathPrefix  string `bson:"pathprefix" json:"path_prefix"`
+	MaxBodySize int64  `bson:"maxbodysize" json:"max_body_size"` // 请求体大小上限（字节），0 表示不限制
+	InspectSize int64  `bson:"inspectsize" json:"inspect_size"`  // 检测窗口（字节），超出部分不检查直接转发
+	Action      string `bson:"action" json:"action"`             // 超过上限时的动作：block 或 pass
+}
+
+// DefaultBodySizeLimit 未配置时使用的大小限制
+var DefaultBodySizeLimit = BodySizeLimit{MaxBodySize: 10 << 20, InspectSize: 128 << 10, Action: OversizeBlock}
+
+var (
+	bodySizeMutex    sync.RWMutex
+	bodySizeDefault  = DefaultBodySizeLimit
+	bodySizeSpecific []BodySizeLimit
+)
+
+// SetBodySizeLimits 设置全局默认限制和按Host、路径前缀覆盖的限制，未设置的项沿用默认值
+func SetBodySizeLimits(defaults BodySizeLimit, limits []BodySizeLimit) {
+	defaults = fillBodySizeLimit(defaults, DefaultBodySizeLimit)
+	specific := make([]BodySizeLimit, len(limits))
+	for i, limit := range limits {
+		specific[i] = fillBodySizeLimit(limit, defaults)
+	}
+
+	bodySizeMutex.Lock()
+	bodySizeDefault = defaults
+	bodySizeSpecific = specific
+	bodySizeMutex.Unlock()
+}
+
+func fillBodySizeLimit(limit, defaults BodySizeLimit) BodySizeLimit {
+	if limit.MaxBodySize == 0 {
+		limit.MaxBodySize = defaults.MaxBodySize
+	}
+	if limit.InspectSize == 0 {
+		limit.InspectSize = defaults.InspectSize
+	}
+	if limit.Action != OversizeBlock && limit.Action != OversizePass {
+		limit.Action = defaults.Action
+	}
+	return limit
+}
+
+// BodySizeLimitFor 返回请求适用的大小限制：Host 精确匹配优先，其次路径前缀最长者优先
+func BodySizeLimitFor(host, path string) BodySizeLimit {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	bodySizeMutex.RLock()
+	defer bodySizeMutex.RUnlock()
+
+	best, bestScore := bodySizeDefault, -1
+	for _, limit := range bodySizeSpecific {
+		if limit.Host != "" && !strings.EqualFold(limit.Host, host) {
+			continue
+		}
+		if !strings.HasPrefix(path, limit.PathPrefix) {
+			continue
+		}
+		score := len(limit.PathPrefix)
+		if limit.Host != "" {
+			score += 1 << 16
+		}
+		if score > bestScore {
+			best, bestScore = limit, score
+		}
+	}
+	return best
+}
+
+// inspectBody 读取检测窗口内的请求体，并将 req.Body 替换为"已读部分 + 剩余部分"的流，保证转发的内容完整
+// partial 表示请求体超出检测窗口，只检查了前一部分
+func inspectBody(body io.ReadCloser, limit BodySizeLimit) (inspected []byte, rest io.ReadCloser, partial bool, err error) {
+	head, err := io.ReadAll(io.LimitReader(body, limit.InspectSize+1))
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	inspected = head
+	if int64(len(head)) > limit.InspectSize {
+		inspected = head[:limit.InspectSize]
+		partial = true
+	}
+
+	var stream io.Reader = io.MultiReader(bytes.NewReader(head), body)
+	if limit.MaxBodySize > 0 && limit.Action == OversizeBlock {
+		stream = &maxBytesReader{r: stream, remaining: limit.MaxBodySize}
+	}
+	return inspected, &readCloser{Reader: stream, Closer: body}, partial, nil
+}
+
+// maxBytesReader 转发过程中超过大小上限时返回 ErrBodyTooLarge，用于没有Content-Length的分块请求
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
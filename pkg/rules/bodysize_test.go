@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodySizeLimitFor(t *testing.T) {
+	SetBodySizeLimits(BodySizeLimit{MaxBodySize: 1000}, []BodySizeLimit{
+		{PathPrefix: "/upload", MaxBodySize: 5000},
+		{PathPrefix: "/upload/avatar", MaxBodySize: 100},
+		{Host: "api.example.com", MaxBodySize: 2000, Action: OversizePass},
+	})
+	defer SetBodySizeLimits(BodySizeLimit{}, nil)
+
+	tests := []struct {
+		host, path string
+		max        int64
+		action     string
+	}{
+		{host: "www.example.com", path: "/", max: 1000, action: OversizeBlock},
+		{host: "www.example.com", path: "/upload/file", max: 5000, action: OversizeBlock},
+		{host: "www.example.com", path: "/upload/avatar/1", max: 100, action: OversizeBlock},
+		{host: "API.example.com:8443", path: "/upload/avatar", max: 2000, action: OversizePass}, // Host 优先于路径
+	}
+	for _, tt := range tests {
+		limit := BodySizeLimitFor(tt.host, tt.path)
+		if limit.MaxBodySize != tt.max || limit.Action != tt.action {
+			t.Errorf("%s%s: 上限 %d 动作 %q，期望 %d %q", tt.host, tt.path, limit.MaxBodySize, limit.Action, tt.max, tt.action)
+		}
+		if limit.InspectSize != DefaultBodySizeLimit.InspectSize {
+			t.Errorf("%s%s: 未设置的检测窗口应沿用默认值，实际 %d", tt.host, tt.path, limit.InspectSize)
+		}
+	}
+}
+
+func TestInspectBody(t *testing.T) {
+	body := strings.Repeat("a", 10) + "ATTACK" + strings.Repeat("b", 10)
+	tests := []struct {
+		name      string
+		limit     BodySizeLimit
+		inspected string
+		partial   bool
+		tooLarge  bool
+	}{
+		{name: "全部在检测窗口内", limit: BodySizeLimit{InspectSize: 100, MaxBodySize: 100, Action: OversizeBlock}, inspected: body},
+		{name: "超出检测窗口", limit: BodySizeLimit{InspectSize: 12, MaxBodySize: 100, Action: OversizeBlock}, inspected: body[:12], partial: true},
+		{name: "转发时超过上限", limit: BodySizeLimit{InspectSize: 12, MaxBodySize: 20, Action: OversizeBlock}, inspected: body[:12], partial: true, tooLarge: true},
+		{name: "超过上限时放行", limit: BodySizeLimit{InspectSize: 12, MaxBodySize: 20, Action: OversizePass}, inspected: body[:12], partial: true},
+	}
+	for _, tt := range tests {
+		inspected, rest, partial, err := inspectBody(io.NopCloser(strings.NewReader(body)), tt.limit)
+		if err != nil {
+			t.Errorf("%s: 读取失败: %v", tt.name, err)
+			continue
+		}
+		if string(inspected) != tt.inspected || partial != tt.partial {
+			t.Errorf("%s: 检查了 %q(partial=%v)，期望 %q(partial=%v)", tt.name, inspected, partial, tt.inspected, tt.partial)
+		}
+
+		// 转发的内容必须完整，包括已经读取用于检查的部分
+		forwarded, err := io.ReadAll(rest)
+		if tt.tooLarge {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				t.Errorf("%s: 期望 ErrBodyTooLarge，结果 %v", tt.name, err)
+			}
+			continue
+		}
+		if err != nil || string(forwarded) != body {
+			t.Errorf("%s: 转发 %q(%v)，期望完整的请求体", tt.name, forwarded, err)
+		}
+	}
+}
+
+func TestMaxBytesReader(t *testing.T) {
+	tests := []struct {
+		size     int
+		max      int64
+		tooLarge bool
+	}{
+		{size: 10, max: 10},
+		{size: 11, max: 10, tooLarge: true},
+		{size: 0, max: 0},
+		{size: 1, max: 0, tooLarge: true},
+	}
+	for _, tt := range tests {
+		// 逐字节读取，模拟分块到达的请求体
+		reader := &maxBytesReader{r: io.LimitReader(strings.NewReader(strings.Repeat("x", tt.size)), int64(tt.size)), remaining: tt.max}
+		var n int
+		var err error
+		buf := make([]byte, 1)
+		for err == nil {
+			var read int
+			read, err = reader.Read(buf)
+			n += read
+		}
+		if tt.tooLarge != errors.Is(err, ErrBodyTooLarge) {
+			t.Errorf("%d字节/上限%d: 结果 %v，期望超过上限 %v", tt.size, tt.max, err, tt.tooLarge)
+		}
+		if !tt.tooLarge && n != tt.size {
+			t.Errorf("%d字节/上限%d: 读取了 %d 字节", tt.size, tt.max, n)
+		}
+	}
+}
+
+func TestCheckRequestBodySize(t *testing.T) {
+	SetBodySizeLimits(BodySizeLimit{MaxBodySize: 16, InspectSize: 8}, []BodySizeLimit{
+		{PathPrefix: "/pass", Action: OversizePass},
+	})
+	defer SetBodySizeLimits(BodySizeLimit{}, nil)
+	ruleset, err := CompileRuleset([]Pattern{{Name: "包体", Regex: "ATTACK", Targets: []string{"body"}}})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		body          string
+		contentLength int64 // -1 表示分块传输
+		blocked       bool
+		oversize      bool
+		partial       bool
+	}{
+		{name: "Content-Length超过上限", path: "/", body: strings.Repeat("x", 20), contentLength: 20, blocked: true, oversize: true},
+		{name: "超过上限时放行不检查", path: "/pass", body: "ATTACK" + strings.Repeat("x", 20), contentLength: 26, partial: true},
+		{name: "检测窗口内命中", path: "/", body: "ATTACK", contentLength: 6, blocked: true},
+		{name: "命中在检测窗口之外", path: "/", body: "xxxxxATTACK", contentLength: -1, partial: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+		req.ContentLength = tt.contentLength
+		result, _ := ruleset.checkRequest(req, CheckOptions{})
+		if result.Blocked != tt.blocked || result.Oversize != tt.oversize || result.PartiallyInspected != tt.partial {
+			t.Errorf("%s: 结果 %+v，期望 blocked=%v oversize=%v partial=%v", tt.name, result, tt.blocked, tt.oversize, tt.partial)
+		}
+	}
+}
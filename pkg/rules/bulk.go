@@ -0,0 +1,420 @@
+// pkg/rules/bulk.go
+
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 批量导入导出支持的格式
+const (
+	FormatText = "txt"  // IP名单：每行一个条目，"#" 之后为备注
+	FormatCSV  = "csv"  // IP名单：ip,comment,created_by,created_at,expires_at,disabled，首行为表头
+	FormatYAML = "yaml" // 拦截规则
+	FormatJSON = "json" // 拦截规则
+)
+
+// ipCSVHeader CSV格式IP名单的表头，导入时 ip 之后的列均可省略
+var ipCSVHeader = []string{"ip", "comment", "created_by", "created_at", "expires_at", "disabled"}
+
+// IPListDiff 导入IP名单前后的差异
+type IPListDiff struct {
+	Added   []IPEntry `json:"added"`
+	Removed []IPEntry `json:"removed"`
+	Changed []IPEntry `json:"changed"` // 备注、过期时间或启用状态有变化的条目
+}
+
+// RulesDiff 导入拦截规则前后的差异，按规则名称比较
+type RulesDiff struct {
+	Added   []Pattern `json:"added"`
+	Removed []Pattern `json:"removed"`
+	Changed []Pattern `json:"changed"`
+}
+
+// ParseIPList 解析批量导入的IP名单，校验并规范化每个条目，错误信息中带有行号
+func ParseIPList(format string, r io.Reader) ([]IPEntry, error) {
+	var entries []IPEntry
+	var errs []error
+	add := func(line int, entry IPEntry) {
+		ip, _, err := ParseIPEntry(entry.IP)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("第 %d 行: %w", line, err))
+			return
+		}
+		entry.IP = ip
+		entries = append(entries, entry)
+	}
+
+	switch format {
+	case FormatText:
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			ip, comment, _ := strings.Cut(scanner.Text(), "#")
+			if ip = strings.TrimSpace(ip); ip == "" {
+				continue
+			}
+			add(line, IPEntry{IP: ip, Comment: strings.TrimSpace(comment)})
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.Comment = '#'
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidIPEntry, err)
+		}
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "ip") {
+				continue // 表头
+			}
+			entry, err := ipEntryFromRecord(record)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("第 %d 行: %w", i+1, err))
+				continue
+			}
+			add(i+1, entry)
+		}
+	default:
+		return nil, fmt.Errorf("%w: 不支持的IP名单格式 %q", ErrInvalidIPEntry, format)
+	}
+
+	// 每个错误都包含 ErrInvalidIPEntry，合并后仍可用 errors.Is 判断
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return entries, nil
+}
+
+// ipEntryFromRecord 将CSV的一行转换为名单条目
+func ipEntryFromRecord(record []string) (IPEntry, error) {
+	field := func(i int) string {
+		if i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	timeField := func(i int) (*time.Time, error) {
+		if field(i) == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, field(i))
+		if err != nil {
+			return nil, fmt.Errorf("%w: 无效的时间 %q", ErrInvalidIPEntry, field(i))
+		}
+		return &t, nil
+	}
+
+	entry := IPEntry{IP: field(0), Comment: field(1), CreatedBy: field(2)}
+	var err error
+	if entry.CreatedAt, err = timeField(3); err != nil {
+		return IPEntry{}, err
+	}
+	if entry.ExpiresAt, err = timeField(4); err != nil {
+		return IPEntry{}, err
+	}
+	if field(5) != "" {
+		if entry.Disabled, err = strconv.ParseBool(field(5)); err != nil {
+			return IPEntry{}, fmt.Errorf("%w: 无效的停用状态 %q", ErrInvalidIPEntry, field(5))
+		}
+	}
+	return entry, nil
+}
+
+// FormatIPList 按指定格式导出IP名单
+func FormatIPList(format string, entries []IPEntry, w io.Writer) error {
+	switch format {
+	case FormatText:
+		for _, entry := range entries {
+			line := entry.IP
+			if entry.Comment != "" {
+				line += " # " + strings.ReplaceAll(entry.Comment, "\n", " ")
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(ipCSVHeader)
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.UTC().Format(time.RFC3339)
+		}
+		for _, entry := range entries {
+			writer.Write([]string{entry.IP, entry.Comment, entry.CreatedBy, formatTime(entry.CreatedAt), formatTime(entry.ExpiresAt), strconv.FormatBool(entry.Disabled)})
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("%w: 不支持的IP名单格式 %q", ErrInvalidIPEntry, format)
+	}
+}
+
+// ParseInterceptionRules 解析批量导入的拦截规则，支持规则数组或 {rules: [...]} 两种结构
+func ParseInterceptionRules(format string, r io.Reader) ([]Pattern, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var unmarshal func([]byte, interface{}) error
+	switch format {
+	case FormatYAML:
+		unmarshal = yaml.Unmarshal
+	case FormatJSON:
+		unmarshal = json.Unmarshal
+	default:
+		return nil, fmt.Errorf("%w: 不支持的规则格式 %q", ErrInvalidRule, format)
+	}
+
+	var patterns []Pattern
+	if err := unmarshal(data, &patterns); err != nil {
+		var wrapped InterceptionRules
+		if err := unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("%w: 解析规则失败: %v", ErrInvalidRule, err)
+		}
+		patterns = wrapped.Rules
+	}
+	return patterns, nil
+}
+
+// FormatInterceptionRules 按指定格式导出拦截规则，导出结果可以直接重新导入
+func FormatInterceptionRules(format string, patterns []Pattern, w io.Writer) error {
+	wrapped := InterceptionRules{Rules: patterns}
+	switch format {
+	case FormatYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(wrapped); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(wrapped)
+	default:
+		return fmt.Errorf("%w: 不支持的规则格式 %q", ErrInvalidRule, format)
+	}
+}
+
+// ReplaceIPList 用导入的条目整体替换白名单或黑名单，dryRun 为true时只返回差异
+// 已存在的条目保留创建者和创建时间，新增条目记为 createdBy 在当前时间添加
+// 导入已过期的条目会被拒绝，否则它们会在下次清理时被静默删除
+func ReplaceIPList(listType string, entries []IPEntry, createdBy string, dryRun bool) (IPListDiff, error) {
+	if listType != "whitelist" && listType != "blacklist" {
+		return IPListDiff{}, fmt.Errorf("%w: 无效的IP规则类型", ErrInvalidIPEntry)
+	}
+
+	// 去重，同一条目出现多次时以最后一次为准
+	now := time.Now()
+	position := make(map[string]int, len(entries))
+	deduped := make([]IPEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Expired(now) {
+			return IPListDiff{}, fmt.Errorf("%w: 条目 %q 已于 %s 过期", ErrInvalidIPEntry, entry.IP, entry.ExpiresAt.Format(time.RFC3339))
+		}
+		if i, found := position[entry.IP]; found {
+			deduped[i] = entry
+			continue
+		}
+		position[entry.IP] = len(deduped)
+		deduped = append(deduped, entry)
+	}
+
+	// 在加锁前构建前缀树，替换时不阻塞请求检查
+	set, errs := newIPSet(ipList(deduped))
+	if len(errs) > 0 {
+		return IPListDiff{}, errors.Join(errs...)
+	}
+
+	if !dryRun {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+	}
+
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil && !dryRun {
+		rulesMutex.Unlock()
+		return IPListDiff{}, err
+	}
+
+	list := &ipControlRules.Blacklist
+	if listType == "whitelist" {
+		list = &ipControlRules.Whitelist
+	}
+
+	existing := make(map[string]IPEntry, len(*list))
+	for _, entry := range *list {
+		existing[entry.IP] = entry
+	}
+	for i, entry := range deduped {
+		old, found := existing[entry.IP]
+		switch {
+		case !found:
+			if entry.CreatedAt == nil {
+				entry.CreatedBy, entry.CreatedAt = createdBy, &now
+			}
+		case old.CreatedAt != nil:
+			entry.CreatedBy, entry.CreatedAt = old.CreatedBy, old.CreatedAt
+		}
+		deduped[i] = entry
+	}
+	diff := diffIPList(*list, deduped)
+
+	if dryRun {
+		rulesMutex.Unlock()
+		return diff, nil
+	}
+
+	setIPList(listType == "whitelist", deduped, set)
+	bumpVersion()
+	whitelist, blacklist := ipControlRules.Whitelist, ipControlRules.Blacklist
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的IP控制规则
+	if err := saveIPControlRules(whitelist, blacklist); err != nil {
+		return diff, err
+	}
+	var versionDiff VersionDiff
+	*versionDiff.ipList(listType) = diff
+	recordVersion(createdBy, "import "+listType, versionDiff, nil)
+	return diff, nil
+}
+
+// diffIPList 按条目比较修改前后的两份名单，备注或过期时间不同的条目记为修改
+func diffIPList(before, after []IPEntry) IPListDiff {
+	var diff IPListDiff
+	existing := make(map[string]IPEntry, len(before))
+	for _, entry := range before {
+		existing[entry.IP] = entry
+	}
+	kept := make(map[string]bool, len(after))
+	for _, entry := range after {
+		kept[entry.IP] = true
+		previous, found := existing[entry.IP]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, entry)
+		case entry.Comment != previous.Comment || entry.Disabled != previous.Disabled || !sameTime(entry.ExpiresAt, previous.ExpiresAt):
+			diff.Changed = append(diff.Changed, entry)
+		}
+	}
+	for _, entry := range before {
+		if !kept[entry.IP] {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+	return diff
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ReplaceInterceptionRules 用导入的规则整体替换拦截规则，dryRun 为true时只返回差异
+// 全部规则编译通过后才会替换，任何一条无效时不做修改
+// 导入空规则集会删除全部规则，只有 allowEmpty 为true时才允许
+func ReplaceInterceptionRules(patterns []Pattern, author string, dryRun, allowEmpty bool) (RulesDiff, error) {
+	if len(patterns) == 0 && !allowEmpty {
+		return RulesDiff{}, fmt.Errorf("%w: 导入的规则为空，清空全部规则需要显式确认", ErrInvalidRule)
+	}
+
+	names := make(map[string]bool, len(patterns))
+	for _, pattern := range patterns {
+		if pattern.Name == "" {
+			return RulesDiff{}, fmt.Errorf("%w: 规则名称不能为空", ErrInvalidRule)
+		}
+		if names[pattern.Name] {
+			return RulesDiff{}, fmt.Errorf("%w: 规则名称 %q 重复", ErrInvalidRule, pattern.Name)
+		}
+		names[pattern.Name] = true
+	}
+
+	ruleset, err := CompileRuleset(patterns)
+	if err != nil {
+		return RulesDiff{}, err
+	}
+
+	if !dryRun {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+	}
+
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil && !dryRun {
+		rulesMutex.Unlock()
+		return RulesDiff{}, err
+	}
+
+	diff := diffInterceptionRules(interceptionRules.Rules, patterns)
+	if dryRun {
+		rulesMutex.Unlock()
+		return diff, nil
+	}
+
+	interceptionRules.Rules = patterns
+	activeRuleset.Store(ruleset)
+	bumpVersion()
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的拦截规则
+	if err := saveInterceptionRules(patterns); err != nil {
+		return diff, err
+	}
+	recordVersion(author, "import interception rules", VersionDiff{Interception: diff}, ruleOrder(patterns))
+	return diff, nil
+}
+
+// diffInterceptionRules 按规则名称比较修改前后的两组规则
+func diffInterceptionRules(before, after []Pattern) RulesDiff {
+	var diff RulesDiff
+	existing := make(map[string]Pattern, len(before))
+	for _, pattern := range before {
+		existing[pattern.Name] = pattern
+	}
+	kept := make(map[string]bool, len(after))
+	for _, pattern := range after {
+		kept[pattern.Name] = true
+		previous, found := existing[pattern.Name]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, pattern)
+		case !samePattern(previous, pattern):
+			diff.Changed = append(diff.Changed, pattern)
+		}
+	}
+	for _, pattern := range before {
+		if !kept[pattern.Name] {
+			diff.Removed = append(diff.Removed, pattern)
+		}
+	}
+	return diff
+}
+
+// samePattern 比较两条规则的全部字段
+func samePattern(a, b Pattern) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
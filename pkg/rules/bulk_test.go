@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIPList(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    []IPEntry
+		errLine string // 期望错误信息中的行号
+	}{
+		{
+			name:   "文本格式",
+			format: FormatText,
+			input:  "10.0.0.1 # 扫描器\n\n# 整行注释\n10.0.0.0/8\n",
+			want:   []IPEntry{{IP: "10.0.0.1", Comment: "扫描器"}, {IP: "10.0.0.0/8"}},
+		},
+		{
+			name:   "CSV省略后面的列",
+			format: FormatCSV,
+			input:  "ip,comment,created_by,created_at,expires_at\n10.0.0.1-10.0.0.3,批量,alice,,2030-01-02T03:04:05Z\n::ffff:1.2.3.4\n",
+			want:   []IPEntry{{IP: "10.0.0.1-10.0.0.3", Comment: "批量", CreatedBy: "alice", ExpiresAt: &expires}, {IP: "1.2.3.4"}},
+		},
+		{name: "文本格式无效条目", format: FormatText, input: "10.0.0.1\nbad\n", errLine: "第 2 行"},
+		{
+			name:   "CSV停用状态",
+			format: FormatCSV,
+			input:  "ip,comment,created_by,created_at,expires_at,disabled\n10.0.0.1,,,,,true\n10.0.0.2,,,,,false\n10.0.0.3,,,,,\n",
+			want:   []IPEntry{{IP: "10.0.0.1", Disabled: true}, {IP: "10.0.0.2"}, {IP: "10.0.0.3"}},
+		},
+		{name: "CSV无效时间", format: FormatCSV, input: "ip\n10.0.0.1,,,,tomorrow\n", errLine: "第 2 行"},
+		{name: "CSV无效停用状态", format: FormatCSV, input: "ip\n10.0.0.1\n10.0.0.2,,,,,maybe\n", errLine: "第 3 行"},
+		{name: "不支持的格式", format: "xml", input: "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		entries, err := ParseIPList(tt.format, strings.NewReader(tt.input))
+		if tt.want == nil {
+			if !errors.Is(err, ErrInvalidIPEntry) || !strings.Contains(err.Error(), tt.errLine) {
+				t.Errorf("%s: 期望包含 %q 的 ErrInvalidIPEntry，结果 %v", tt.name, tt.errLine, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(entries, tt.want) {
+			t.Errorf("%s: 解析结果 %+v(%v)，期望 %+v", tt.name, entries, err, tt.want)
+		}
+	}
+}
+
+func TestFormatIPListRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	entries := []IPEntry{
+		{IP: "10.0.0.1", Comment: "逗号, 和\"引号\"", CreatedBy: "bob", CreatedAt: &created},
+		{IP: "2001:db8::/32", Disabled: true},
+	}
+	for _, format := range []string{FormatText, FormatCSV} {
+		var buf bytes.Buffer
+		if err := FormatIPList(format, entries, &buf); err != nil {
+			t.Fatalf("%s: 导出失败: %v", format, err)
+		}
+		parsed, err := ParseIPList(format, &buf)
+		if err != nil {
+			t.Fatalf("%s: 重新导入失败: %v", format, err)
+		}
+		want := entries
+		if format == FormatText {
+			// 文本格式只保留IP和备注
+			want = []IPEntry{{IP: "10.0.0.1", Comment: entries[0].Comment}, {IP: "2001:db8::/32"}}
+		}
+		if !reflect.DeepEqual(parsed, want) {
+			t.Errorf("%s: 重新导入 %+v，期望 %+v", format, parsed, want)
+		}
+	}
+}
+
+func TestReplaceIPListDryRun(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := []IPEntry{
+		{IP: "10.0.0.1", Comment: "保留", CreatedBy: "alice", CreatedAt: &created},
+		{IP: "10.0.0.2", Comment: "删除"},
+		{IP: "10.0.0.3", Comment: "旧备注"},
+		{IP: "10.0.0.4"},
+	}
+	set, _ := newIPSet(current)
+	setIPList(false, current, set)
+	defer setIPList(false, nil, &ipSet{})
+
+	imported := []IPEntry{
+		{IP: "10.0.0.1", Comment: "保留"},
+		{IP: "10.0.0.3", Comment: "新备注"},
+		{IP: "10.0.0.4", ExpiresAt: &expires},
+		{IP: "10.0.0.5"},
+		{IP: "10.0.0.5", Comment: "重复时以最后一次为准"},
+	}
+	diff, err := ReplaceIPList("blacklist", imported, "bob", true)
+	if err != nil {
+		t.Fatalf("试运行失败: %v", err)
+	}
+
+	ips := func(entries []IPEntry) []string {
+		var list []string
+		for _, entry := range entries {
+			list = append(list, entry.IP)
+		}
+		return list
+	}
+	tests := []struct {
+		name string
+		got  []IPEntry
+		want []string
+	}{
+		{name: "新增", got: diff.Added, want: []string{"10.0.0.5"}},
+		{name: "删除", got: diff.Removed, want: []string{"10.0.0.2"}},
+		{name: "修改", got: diff.Changed, want: []string{"10.0.0.3", "10.0.0.4"}},
+	}
+	for _, tt := range tests {
+		if !reflect.DeepEqual(ips(tt.got), tt.want) {
+			t.Errorf("%s: %v，期望 %v", tt.name, ips(tt.got), tt.want)
+		}
+	}
+	if added := diff.Added[0]; added.Comment != "重复时以最后一次为准" || added.CreatedBy != "bob" || added.CreatedAt == nil {
+		t.Errorf("新增条目 %+v 应取最后一次出现的内容并记录导入者", added)
+	}
+
+	// 试运行不修改名单
+	if !reflect.DeepEqual(ipControlRules.Blacklist, current) {
+		t.Errorf("试运行后名单被修改: %+v", ipControlRules.Blacklist)
+	}
+
+	if _, err := ReplaceIPList("graylist", imported, "bob", true); !errors.Is(err, ErrInvalidIPEntry) {
+		t.Errorf("无效的名单类型应返回 ErrInvalidIPEntry，结果 %v", err)
+	}
+
+	// 已过期的条目导入后会被立即清理，直接拒绝
+	expired := time.Now().Add(-time.Minute)
+	withExpired := append(imported, IPEntry{IP: "10.0.0.6", ExpiresAt: &expired})
+	if _, err := ReplaceIPList("blacklist", withExpired, "bob", true); !errors.Is(err, ErrInvalidIPEntry) || !strings.Contains(err.Error(), "10.0.0.6") {
+		t.Errorf("已过期的条目应返回包含IP的 ErrInvalidIPEntry，结果 %v", err)
+	}
+}
+
+func TestImportInterceptionRules(t *testing.T) {
+	inputs := []struct {
+		format string
+		data   string
+	}{
+		{format: FormatYAML, data: "- name: a\n  regex: x\n- name: b\n  regex: y\n  action: log\n"},
+		{format: FormatYAML, data: "rules:\n  - name: a\n    regex: x\n  - name: b\n    regex: y\n    action: log\n"},
+		{format: FormatJSON, data: `[{"name":"a","regex":"x"},{"name":"b","regex":"y","action":"log"}]`},
+		{format: FormatJSON, data: `{"rules":[{"name":"a","regex":"x"},{"name":"b","regex":"y","action":"log"}]}`},
+	}
+	want := []Pattern{{Name: "a", Regex: "x"}, {Name: "b", Regex: "y", Action: ActionLog}}
+	for _, input := range inputs {
+		patterns, err := ParseInterceptionRules(input.format, strings.NewReader(input.data))
+		if err != nil || !reflect.DeepEqual(patterns, want) {
+			t.Errorf("%s %q: 解析结果 %+v(%v)", input.format, input.data, patterns, err)
+		}
+	}
+
+	interceptionRules = InterceptionRules{Rules: []Pattern{{Name: "a", Regex: "x"}, {Name: "c", Regex: "z"}}}
+	defer func() { interceptionRules = InterceptionRules{} }()
+
+	diff, err := ReplaceInterceptionRules([]Pattern{{Name: "a", Regex: "x2"}, {Name: "b", Regex: "y"}}, "alice", true, false)
+	if err != nil {
+		t.Fatalf("试运行失败: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Name != "b" || len(diff.Removed) != 1 || diff.Removed[0].Name != "c" ||
+		len(diff.Changed) != 1 || diff.Changed[0].Name != "a" {
+		t.Errorf("差异 %+v，期望新增 b、删除 c、修改 a", diff)
+	}
+
+	invalid := [][]Pattern{
+		{{Name: "", Regex: "x"}},
+		{{Name: "a", Regex: "x"}, {Name: "a", Regex: "y"}},
+		{{Name: "a", Regex: "("}},
+	}
+	for _, patterns := range invalid {
+		if _, err := ReplaceInterceptionRules(patterns, "alice", true, false); err == nil {
+			t.Errorf("%+v: 应当被拒绝", patterns)
+		}
+	}
+
+	// 空规则集会删除全部规则，需要显式允许
+	if _, err := ReplaceInterceptionRules(nil, "alice", true, false); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("未允许时空规则集应返回 ErrInvalidRule，结果 %v", err)
+	}
+	diff, err = ReplaceInterceptionRules(nil, "alice", true, true)
+	if err != nil || len(diff.Removed) != 2 {
+		t.Errorf("允许后空规则集应删除全部规则，结果 %+v %v", diff, err)
+	}
+}
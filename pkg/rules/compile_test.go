@@ -0,0 +1,92 @@
+package rules
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// blocked 使用规则集检查请求，返回请求是否被拦截
+func blocked(ruleset *Ruleset, req *http.Request, body string) bool {
+	return ruleset.Check(req, []byte(body), CheckOptions{}).Blocked
+}
+
+func TestCompileRuleset(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []Pattern
+		invalid  string // 导致编译失败的规则名称，为空表示全部有效
+		compiled int    // 跳过无效规则后编译成功的规则数
+	}{
+		{name: "空规则集"},
+		{name: "全部有效", patterns: []Pattern{{Name: "a", Regex: `union\s+select`}, {Name: "b", Regex: `(?i)<script`, Method: "POST"}}, compiled: 2},
+		{name: "无效的正则表达式", patterns: []Pattern{{Name: "a", Regex: `union\s+select`}, {Name: "bad", Regex: `(select`}}, invalid: "bad", compiled: 1},
+		{name: "不支持的语法", patterns: []Pattern{{Name: "lookahead", Regex: `a(?=b)`}}, invalid: "lookahead"},
+	}
+	for _, tt := range tests {
+		ruleset, err := CompileRuleset(tt.patterns)
+		if tt.invalid == "" {
+			if err != nil || ruleset == nil {
+				t.Errorf("%s: 编译失败: %v", tt.name, err)
+			}
+		} else if !errors.Is(err, ErrInvalidRule) || !strings.Contains(err.Error(), `"`+tt.invalid+`"`) {
+			t.Errorf("%s: 期望规则 %q 的 ErrInvalidRule，结果 %v", tt.name, tt.invalid, err)
+		}
+
+		// 加载规则时跳过无效的规则，其余规则照常生效
+		partial, errs := compileRuleset(tt.patterns)
+		if len(partial.rules) != tt.compiled || (len(errs) == 0) != (tt.invalid == "") {
+			t.Errorf("%s: 编译了 %d 条规则，错误 %v，期望 %d 条", tt.name, len(partial.rules), errs, tt.compiled)
+		}
+	}
+}
+
+func TestRulesetCheck(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "sqli", Regex: `(?i)union\s+select`},
+		{Name: "upload", Regex: `\.php$`, Method: "PUT"},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		header  string
+		body    string
+		blocked bool
+	}{
+		{name: "正常请求", method: "GET", target: "/index.html"},
+		{name: "路径", method: "GET", target: "/q/UNION%20SELECT", blocked: true},
+		{name: "包体", method: "POST", target: "/", body: "id=1 union select password", blocked: true},
+		{name: "请求头", method: "GET", target: "/", header: "1 union  select 2", blocked: true},
+		{name: "方法不匹配", method: "GET", target: "/shell.php"},
+		{name: "方法匹配", method: "PUT", target: "/shell.php", blocked: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.header != "" {
+			req.Header.Set("X-Search", tt.header)
+		}
+		if got := blocked(ruleset, req, tt.body); got != tt.blocked {
+			t.Errorf("%s: 拦截 %v，期望 %v", tt.name, got, tt.blocked)
+		}
+	}
+}
+
+func TestAddInterceptionRuleRejectsInvalid(t *testing.T) {
+	active := activeRuleset.Load()
+	count := len(interceptionRules.Rules)
+
+	err := AddInterceptionRule(Pattern{Name: "bad", Regex: `([a-z]`, Method: "GET"}, "admin")
+	if !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("期望 ErrInvalidRule，结果 %v", err)
+	}
+	if activeRuleset.Load() != active || len(interceptionRules.Rules) != count {
+		t.Error("无效的规则不应加入规则集")
+	}
+}
@@ -0,0 +1,96 @@
+package rules
+
+import (
+	"bufio"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readCorpus 读取测试语料，每行一条，忽略空行和 "#" 开头的注释
+func readCorpus(t *testing.T, name string) []string {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("打开语料 %s 失败: %v", name, err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("读取语料 %s 失败: %v", name, err)
+	}
+	return lines
+}
+
+func TestIsSQLiCorpus(t *testing.T) {
+	for _, payload := range readCorpus(t, "sqli_payloads.txt") {
+		if !IsSQLi(payload) {
+			t.Errorf("未识别SQL注入载荷 %q（指纹: %q / %q / %q）", payload,
+				sqlFingerprint(payload, 0), sqlFingerprint(payload, '\''), sqlFingerprint(payload, '"'))
+		}
+	}
+	for _, input := range readCorpus(t, "sqli_benign.txt") {
+		if IsSQLi(input) {
+			t.Errorf("正常输入被误判为SQL注入 %q（指纹: %q / %q / %q）", input,
+				sqlFingerprint(input, 0), sqlFingerprint(input, '\''), sqlFingerprint(input, '"'))
+		}
+	}
+}
+
+func TestIsXSSCorpus(t *testing.T) {
+	for _, payload := range readCorpus(t, "xss_payloads.txt") {
+		if !IsXSS(payload) {
+			t.Errorf("未识别XSS载荷 %q", payload)
+		}
+	}
+	for _, input := range readCorpus(t, "xss_benign.txt") {
+		if IsXSS(input) {
+			t.Errorf("正常输入被误判为XSS %q", input)
+		}
+	}
+}
+
+func TestDetectorOperators(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "sqli", Operator: OperatorSQLi, Targets: []string{"args"}, Transforms: []string{TransformURLDecodeRecursive}},
+		{Name: "xss", Operator: OperatorXSS, Targets: []string{"args"}},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		query string
+		rule  string
+	}{
+		{query: "id=" + url.QueryEscape(url.QueryEscape("1' or '1'='1")), rule: "sqli"},
+		{query: "q=" + url.QueryEscape("<img src=x onerror=alert(1)>"), rule: "xss"},
+		{query: "q=" + url.QueryEscape("O'Reilly <b>books</b>"), rule: ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/search?"+tt.query, nil)
+		result := ruleset.Check(req, nil, CheckOptions{})
+		switch {
+		case tt.rule == "" && result.Blocked:
+			t.Errorf("%s: 不应被拦截，命中规则 %q", tt.query, result.Match.Rule)
+		case tt.rule != "" && (!result.Blocked || result.Match.Rule != tt.rule):
+			t.Errorf("%s: 期望被规则 %q 拦截，结果 %+v", tt.query, tt.rule, result)
+		}
+	}
+
+	if _, err := CompileRuleset([]Pattern{{Name: "bad", Operator: "unknown"}}); err == nil {
+		t.Error("未知运算符应当被拒绝")
+	}
+}
@@ -0,0 +1,390 @@
+// pkg/rules/engine.go
+
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// 命中片段的截取长度：匹配内容前后各保留 snippetContext 字节，匹配内容最多保留 snippetMaxLen 字节
+const (
+	snippetContext = 32
+	snippetMaxLen  = 256
+)
+
+// ErrInvalidRule 拦截规则定义无效
+var ErrInvalidRule = errors.New("无效的拦截规则")
+
+// 规则命中后的动作
+const (
+	ActionBlock = "block" // 拦截请求（默认）
+	ActionLog   = "log"   // 仅记录，请求继续转发
+)
+
+// 规则运算符
+const (
+	OperatorRegex = "regex" // 正则表达式（默认）
+	OperatorSQLi  = "sqli"  // 基于词法指纹的SQL注入检测
+	OperatorXSS   = "xss"   // 基于HTML解析的跨站脚本检测
+)
+
+// ModeDetect 检测模式，所有命中只记录不拦截
+const ModeDetect = "detect"
+
+// detectOnly 全局检测模式开关
+var detectOnly atomic.Bool
+
+// anomalyThreshold 入站异常评分阈值，为0时使用传统模式（第一条命中的规则即拦截）
+var anomalyThreshold atomic.Int64
+
+// SetMode 设置防火墙模式，detect 为检测模式，其他值为拦截模式
+func SetMode(mode string) {
+	detectOnly.Store(mode == ModeDetect)
+}
+
+// DetectOnly 当前是否处于检测模式
+func DetectOnly() bool {
+	return detectOnly.Load()
+}
+
+// SetAnomalyThreshold 设置入站异常评分阈值，0 表示关闭异常评分模式
+func SetAnomalyThreshold(threshold int) {
+	anomalyThreshold.Store(int64(threshold))
+}
+
+// AnomalyThreshold 当前的入站异常评分阈值
+func AnomalyThreshold() int {
+	return int(anomalyThreshold.Load())
+}
+
+// CheckOptions 规则检查选项
+type CheckOptions struct {
+	DetectOnly       bool // 检测模式，命中只记录不拦截
+	AnomalyThreshold int  // 入站异常评分阈值，0 表示第一条命中的规则即拦截
+}
+
+// currentOptions 返回当前全局配置对应的检查选项
+func currentOptions() CheckOptions {
+	return CheckOptions{DetectOnly: DetectOnly(), AnomalyThreshold: AnomalyThreshold()}
+}
+
+// compiledRule 预编译后的拦截规则
+type compiledRule struct {
+	pattern    Pattern
+	matcher    matcher
+	targets    []target
+	transforms []transformFunc
+	score      int
+}
+
+// matcher 规则运算符，返回命中内容在取值中的起止位置，未命中时返回nil
+type matcher func(value string) []int
+
+// detectorMatcher 将整段取值作为检测对象的运算符
+func detectorMatcher(detect func(string) bool) matcher {
+	return func(value string) []int {
+		if detect(value) {
+			return []int{0, len(value)}
+		}
+		return nil
+	}
+}
+
+// compileMatcher 根据规则的运算符编译匹配函数
+func compileMatcher(pattern Pattern) (matcher, error) {
+	switch pattern.Operator {
+	case "", OperatorRegex:
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("正则表达式无效: %v", err)
+		}
+		return re.FindStringIndex, nil
+	case OperatorSQLi:
+		return detectorMatcher(IsSQLi), nil
+	case OperatorXSS:
+		return detectorMatcher(IsXSS), nil
+	default:
+		return nil, fmt.Errorf("未知的运算符 %q", pattern.Operator)
+	}
+}
+
+// Match 规则命中详情
+type Match struct {
+	Rule     string `bson:"rule" json:"rule"`         // 命中的规则名称
+	Location string `bson:"location" json:"location"` // 命中位置，例如 "path"、"headers:Referer"
+	Snippet  string `bson:"snippet" json:"snippet"`   // 命中内容附近的片段
+	Score    int    `bson:"score" json:"score"`       // 规则的异常评分
+}
+
+// Result 规则检查结果
+type Result struct {
+	Blocked    bool    // 请求需要被拦截
+	Match      *Match  // 拦截请求的规则，读取请求失败时为nil
+	Matches    []Match // 计入异常评分的全部命中
+	Score      int     // 累计的异常评分
+	Detections []Match // 本应拦截但仅记录的命中：log动作的规则，或检测模式下的全部命中
+
+	Oversize           bool // 请求体超过大小上限，应以413拒绝
+	PartiallyInspected bool // 请求体超出检测窗口或超过上限后放行，只检查了部分内容
+	InspectedBytes     int  // 实际检查的请求体字节数
+}
+
+// Ruleset 预编译的拦截规则集，创建后只读，可在多个goroutine间共享
+type Ruleset struct {
+	rules []compiledRule
+
+	// 有规则匹配表单、JSON或上传文件时才解析结构化包体，包体超出解析限制时命中内置规则 BodyLimitRule
+	structured    bool
+	skipBodyLimit bool // 规则选择排除了 BodyLimitRule
+}
+
+// activeRuleset 当前生效的规则集，规则变更时整体替换
+var activeRuleset atomic.Pointer[Ruleset]
+
+func init() {
+	activeRuleset.Store(&Ruleset{})
+}
+
+// ValidatePattern 校验拦截规则是否可以被编译
+func ValidatePattern(pattern Pattern) error {
+	_, err := compilePattern(pattern)
+	return err
+}
+
+// compilePattern 编译单条拦截规则
+func compilePattern(pattern Pattern) (compiledRule, error) {
+	m, err := compileMatcher(pattern)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("%w: 规则 %q: %v", ErrInvalidRule, pattern.Name, err)
+	}
+	switch pattern.Action {
+	case "", ActionBlock, ActionLog:
+	default:
+		return compiledRule{}, fmt.Errorf("%w: 规则 %q 的动作 %q 无效，必须为 %q 或 %q", ErrInvalidRule, pattern.Name, pattern.Action, ActionBlock, ActionLog)
+	}
+	targets, err := parseTargets(pattern.Targets)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("%w: 规则 %q: %v", ErrInvalidRule, pattern.Name, err)
+	}
+	transforms, err := parseTransforms(pattern.Transforms)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("%w: 规则 %q: %v", ErrInvalidRule, pattern.Name, err)
+	}
+	score, err := ruleScore(pattern)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("%w: 规则 %q: %v", ErrInvalidRule, pattern.Name, err)
+	}
+	return compiledRule{pattern: pattern, matcher: m, targets: targets, transforms: transforms, score: score}, nil
+}
+
+// CompileRuleset 编译规则集，任意一条规则无效时返回错误
+func CompileRuleset(patterns []Pattern) (*Ruleset, error) {
+	ruleset, errs := compileRuleset(patterns)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return ruleset, nil
+}
+
+// compileRuleset 编译规则集，跳过无效规则并返回对应错误
+func compileRuleset(patterns []Pattern) (*Ruleset, []error) {
+	ruleset := &Ruleset{rules: make([]compiledRule, 0, len(patterns))}
+	var errs []error
+	for _, pattern := range patterns {
+		rule, err := compilePattern(pattern)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// 停用的规则同样需要校验，启用时不会因规则无效而失败
+		if pattern.Disabled {
+			continue
+		}
+		ruleset.rules = append(ruleset.rules, rule)
+		ruleset.structured = ruleset.structured || rule.structured()
+	}
+	return ruleset, errs
+}
+
+// ActiveRuleset 返回当前生效的规则集
+func ActiveRuleset() *Ruleset {
+	return activeRuleset.Load()
+}
+
+// Select 返回只包含部分规则的规则集，include 为空时保留全部规则，再去掉 exclude 中的规则
+// 内置规则 BodyLimitRule 同样按名称选择
+// 返回的规则集与原规则集共享已编译的规则
+func (rs *Ruleset) Select(include, exclude []string) *Ruleset {
+	if len(include) == 0 && len(exclude) == 0 {
+		return rs
+	}
+	included := make(map[string]bool, len(include))
+	for _, name := range include {
+		included[name] = true
+	}
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+
+	selects := func(name string) bool {
+		return (len(include) == 0 || included[name]) && !excluded[name]
+	}
+
+	selected := &Ruleset{rules: make([]compiledRule, 0, len(rs.rules)), skipBodyLimit: rs.skipBodyLimit || !selects(BodyLimitRule)}
+	for _, rule := range rs.rules {
+		if selects(rule.pattern.Name) {
+			selected.rules = append(selected.rules, rule)
+			selected.structured = selected.structured || rule.structured()
+		}
+	}
+	return selected
+}
+
+// swapRuleset 根据当前拦截规则重新编译并替换生效的规则集，调用方需持有写锁
+func swapRuleset() {
+	ruleset, _ := compileRuleset(interceptionRules.Rules)
+	activeRuleset.Store(ruleset)
+	bumpVersion()
+}
+
+// Check 按规则声明的匹配位置检查请求
+// 传统模式下遇到第一条拦截规则即返回；异常评分模式下评估全部规则，累计评分达到阈值时拦截
+// log动作的规则以及检测模式下本应拦截的命中记录在 Detections 中
+func (rs *Ruleset) Check(req *http.Request, body []byte, opts CheckOptions) Result {
+	var result Result
+	rd := newRequestData(req, body)
+
+	// 结构化包体超出解析限制时按内置规则处理，与普通规则一样参与拦截和评分
+	if match := rs.bodyLimitMatch(rd); match != nil {
+		result.Matches = append(result.Matches, *match)
+		result.Score += match.Score
+		if opts.DetectOnly && opts.AnomalyThreshold == 0 {
+			result.Detections = append(result.Detections, result.Matches[0])
+		} else if opts.AnomalyThreshold == 0 {
+			result.Blocked = true
+			result.Match = &result.Matches[0]
+			return result
+		}
+	}
+
+	for _, rule := range rs.rules {
+		// 检查HTTP方法
+		if rule.pattern.Method != "" && rule.pattern.Method != req.Method {
+			continue
+		}
+
+		match := rule.match(rd)
+		if match == nil {
+			continue
+		}
+		if rule.pattern.Action == ActionLog {
+			result.Detections = append(result.Detections, *match)
+			continue
+		}
+
+		result.Matches = append(result.Matches, *match)
+		result.Score += match.Score
+		if opts.AnomalyThreshold > 0 {
+			continue
+		}
+		if opts.DetectOnly {
+			result.Detections = append(result.Detections, *match)
+			continue
+		}
+		result.Blocked = true
+		result.Match = &result.Matches[0]
+		return result
+	}
+
+	if opts.AnomalyThreshold > 0 && len(result.Matches) > 0 && result.Score >= opts.AnomalyThreshold {
+		if opts.DetectOnly {
+			result.Detections = append(result.Detections, result.Matches...)
+		} else {
+			result.Blocked = true
+			result.Match = &result.Matches[0]
+		}
+	}
+
+	return result
+}
+
+// bodyLimitMatch 结构化包体超出解析限制时返回内置规则的命中
+// 没有规则匹配表单、JSON或上传文件时不解析包体，也就不会命中
+func (rs *Ruleset) bodyLimitMatch(rd *requestData) *Match {
+	if !rs.structured || rs.skipBodyLimit {
+		return nil
+	}
+	err := rd.structuredBody().err
+	if !errors.Is(err, ErrBodyLimit) {
+		return nil
+	}
+	return &Match{
+		Rule:     BodyLimitRule,
+		Location: TargetBody,
+		Snippet:  err.Error(),
+		Score:    severityScores[SeverityCritical],
+	}
+}
+
+// structured 规则是否匹配需要解析包体的位置
+func (rule compiledRule) structured() bool {
+	for _, t := range rule.targets {
+		if t.structured() {
+			return true
+		}
+	}
+	return false
+}
+
+// match 返回规则在请求中的第一处命中，未命中时返回nil
+func (rule compiledRule) match(rd *requestData) *Match {
+	var first *Match
+	rule.eachMatch(rd, func(m Match) bool {
+		first = &m
+		return false
+	})
+	return first
+}
+
+// eachMatch 依次对规则在每个匹配位置的命中调用 fn，fn 返回false时停止
+// 取值先经过规则声明的转换链再匹配，命中片段取自转换后的值
+func (rule compiledRule) eachMatch(rd *requestData, fn func(Match) bool) {
+	for _, t := range rule.targets {
+		for _, v := range t.values(rd) {
+			value := applyTransforms(v.value, rule.transforms)
+			if loc := rule.matcher(value); loc != nil {
+				next := fn(Match{
+					Rule:     rule.pattern.Name,
+					Location: v.location,
+					Snippet:  snippet(value, loc[0], loc[1]),
+					Score:    rule.score,
+				})
+				if !next {
+					return
+				}
+			}
+		}
+	}
+}
+
+// snippet 截取命中内容及其前后少量上下文
+func snippet(value string, start, end int) string {
+	if end-start > snippetMaxLen {
+		end = start + snippetMaxLen
+	}
+	from := start - snippetContext
+	if from < 0 {
+		from = 0
+	}
+	to := end + snippetContext
+	if to > len(value) {
+		to = len(value)
+	}
+	return strings.ToValidUTF8(value[from:to], "")
+}
@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckReportsMatch(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "路径遍历", Regex: `\.\./`, Targets: []string{"path"}},
+		{Name: "恶意Referer", Regex: `evil\.example`, Targets: []string{"headers:Referer"}},
+		{Name: "包体关键字", Regex: `DROP TABLE`},
+		{Name: "仅POST", Regex: `admin`, Method: "POST", Targets: []string{"args"}},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		referer  string
+		body     string
+		rule     string
+		location string
+		snippet  string
+	}{
+		{name: "路径", method: "GET", target: "/static/../etc/passwd", rule: "路径遍历", location: "path", snippet: "/static/../etc/passwd"},
+		{name: "指定请求头", method: "GET", target: "/", referer: "https://evil.example/a", rule: "恶意Referer", location: "headers:Referer", snippet: "https://evil.example/a"},
+		{name: "默认位置包含包体", method: "POST", target: "/", body: "name=x; DROP TABLE users", rule: "包体关键字", location: "body", snippet: "name=x; DROP TABLE users"},
+		{name: "方法不匹配", method: "GET", target: "/?user=admin"},
+		{name: "方法匹配", method: "POST", target: "/?user=admin", rule: "仅POST", location: "args:user", snippet: "admin"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+		result := ruleset.Check(req, []byte(tt.body), CheckOptions{})
+		if tt.rule == "" {
+			if result.Blocked {
+				t.Errorf("%s: 不应被拦截，命中 %+v", tt.name, result.Match)
+			}
+			continue
+		}
+		if !result.Blocked || result.Match == nil {
+			t.Errorf("%s: 期望被规则 %q 拦截，结果 %+v", tt.name, tt.rule, result)
+			continue
+		}
+		got := *result.Match
+		if got.Rule != tt.rule || got.Location != tt.location || got.Snippet != tt.snippet {
+			t.Errorf("%s: 命中 %+v，期望规则 %q 位置 %q 片段 %q", tt.name, got, tt.rule, tt.location, tt.snippet)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a", 100) + "<script>" + strings.Repeat("b", 100)
+	tests := []struct {
+		name       string
+		value      string
+		start, end int
+		want       string
+	}{
+		{name: "短取值", value: "id=1 or 1=1", start: 5, end: 11, want: "id=1 or 1=1"},
+		{name: "前后各保留上下文", value: long, start: 100, end: 108,
+			want: strings.Repeat("a", snippetContext) + "<script>" + strings.Repeat("b", snippetContext)},
+		{name: "过长的命中被截断", value: strings.Repeat("x", 1000), start: 0, end: 1000,
+			want: strings.Repeat("x", snippetMaxLen+snippetContext)},
+		{name: "去掉被截断的多字节字符", value: "中文" + strings.Repeat("x", 30), start: 33, end: 36,
+			want: "文" + strings.Repeat("x", 30)},
+	}
+	for _, tt := range tests {
+		if got := snippet(tt.value, tt.start, tt.end); got != tt.want {
+			t.Errorf("%s: 片段 %q，期望 %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectModeAndLogAction(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "观察", Regex: `probe`, Targets: []string{"args"}, Action: ActionLog},
+		{Name: "拦截", Regex: `attack`, Targets: []string{"args"}},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		detectOnly bool
+		blocked    bool
+		detections []string
+	}{
+		{name: "log动作只记录", query: "q=probe", detections: []string{"观察"}},
+		{name: "log动作与拦截规则同时命中", query: "q=probe&r=attack", blocked: true, detections: []string{"观察"}},
+		{name: "检测模式不拦截", query: "r=attack", detectOnly: true, detections: []string{"拦截"}},
+		{name: "检测模式记录全部命中", query: "q=probe&r=attack", detectOnly: true, detections: []string{"观察", "拦截"}},
+		{name: "未命中", query: "q=hello", detectOnly: true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+		result := ruleset.Check(req, nil, CheckOptions{DetectOnly: tt.detectOnly})
+		if result.Blocked != tt.blocked {
+			t.Errorf("%s: 拦截 %v，期望 %v", tt.name, result.Blocked, tt.blocked)
+		}
+		var detections []string
+		for _, detection := range result.Detections {
+			detections = append(detections, detection.Rule)
+		}
+		if strings.Join(detections, ",") != strings.Join(tt.detections, ",") {
+			t.Errorf("%s: 记录的命中 %v，期望 %v", tt.name, detections, tt.detections)
+		}
+	}
+
+	if _, err := CompileRuleset([]Pattern{{Name: "bad", Regex: "x", Action: "drop"}}); err == nil {
+		t.Error("未知动作应当被拒绝")
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	defer SetMode("")
+	SetMode(ModeDetect)
+	if !DetectOnly() || !currentOptions().DetectOnly {
+		t.Error("设置 detect 后应处于检测模式")
+	}
+	SetMode("block")
+	if DetectOnly() {
+		t.Error("设置 block 后应处于拦截模式")
+	}
+}
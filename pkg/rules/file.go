@@ -0,0 +1,219 @@
+// pkg/rules/file.go
+
+package rules
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// 规则来源
+const (
+	SourceDatabase          = "database"            // 规则保存在MongoDB中，通过接口修改（默认）
+	SourceFile              = "file"                // 规则只来自规则文件，接口只读
+	SourceFileSeedsDatabase = "file_seeds_database" // 规则文件变化时覆盖MongoDB中的规则，接口仍可修改
+)
+
+// ErrRulesReadOnly 规则由规则文件管理，不能通过接口修改
+var ErrRulesReadOnly = errors.New("规则由规则文件管理，请修改规则文件")
+
+// reloadDelay 规则文件变化后等待的时间，编辑器保存时通常会连续产生多个事件
+const reloadDelay = 300 * time.Millisecond
+
+// RulesFile 规则文件的结构
+type RulesFile struct {
+	Interception []Pattern `yaml:"interception"`
+	IPControl    struct {
+		Whitelist []IPEntry `yaml:"whitelist"`
+		Blacklist []IPEntry `yaml:"blacklist"`
+	} `yaml:"ip_control"`
+}
+
+var (
+	rulesSource  = SourceDatabase // 由 rulesMutex 保护
+	fileMutex    sync.Mutex
+	fileChecksum string // 最近一次加载的规则文件摘要
+)
+
+// SetRulesSource 设置规则来源，未知的值按 database 处理
+func SetRulesSource(source string) {
+	if source != SourceFile && source != SourceFileSeedsDatabase {
+		source = SourceDatabase
+	}
+	rulesMutex.Lock()
+	rulesSource = source
+	rulesMutex.Unlock()
+}
+
+// RulesSource 返回当前的规则来源
+func RulesSource() string {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+	return rulesSource
+}
+
+// RulesFileChecksum 返回最近一次加载的规则文件的SHA-256摘要，未加载时为空
+func RulesFileChecksum() string {
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	return fileChecksum
+}
+
+// checkWritable 规则来源为 file 时拒绝通过接口修改，调用方需持有锁
+func checkWritable() error {
+	if rulesSource == SourceFile {
+		return ErrRulesReadOnly
+	}
+	return nil
+}
+
+// ParseRulesFile 解析规则文件，校验全部拦截规则和IP条目
+func ParseRulesFile(data []byte) (*RulesFile, *Ruleset, error) {
+	var file RulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, nil, fmt.Errorf("%w: 解析规则文件失败: %v", ErrInvalidRule, err)
+	}
+
+	names := make(map[string]bool, len(file.Interception))
+	for _, pattern := range file.Interception {
+		if names[pattern.Name] {
+			return nil, nil, fmt.Errorf("%w: 规则名称 %q 重复", ErrInvalidRule, pattern.Name)
+		}
+		names[pattern.Name] = true
+	}
+	ruleset, err := CompileRuleset(file.Interception)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, list := range [][]IPEntry{file.IPControl.Whitelist, file.IPControl.Blacklist} {
+		for i, entry := range list {
+			ip, _, err := ParseIPEntry(entry.IP)
+			if err != nil {
+				return nil, nil, err
+			}
+			list[i].IP = ip
+		}
+	}
+	return &file, ruleset, nil
+}
+
+// LoadRulesFile 加载规则文件并替换当前规则，文件无效时保留当前规则
+// 规则来源为 file_seeds_database 时同时写回MongoDB
+func LoadRulesFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取规则文件失败: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	fileMutex.Lock()
+	defer fileMutex.Unlock()
+	if checksum == fileChecksum {
+		return nil
+	}
+
+	file, ruleset, err := ParseRulesFile(data)
+	if err != nil {
+		return err
+	}
+
+	// 在加锁前构建前缀树，替换时不阻塞请求检查
+	now := time.Now()
+	whitelist := unexpired(file.IPControl.Whitelist, now)
+	blacklist := unexpired(file.IPControl.Blacklist, now)
+	whitelistTrie, _ := newIPSet(ipList(whitelist))
+	blacklistTrie, _ := newIPSet(ipList(blacklist))
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	rulesMutex.Lock()
+	before := currentSnapshot()
+	interceptionRules = InterceptionRules{Rules: file.Interception}
+	activeRuleset.Store(ruleset)
+	setIPList(true, whitelist, whitelistTrie)
+	setIPList(false, blacklist, blacklistTrie)
+	bumpVersion()
+	source := rulesSource
+	snapshot := currentSnapshot()
+	rulesMutex.Unlock()
+
+	logging.LogInfo(fmt.Sprintf("已加载规则文件 %s：%d 条拦截规则，%d 条白名单，%d 条黑名单",
+		path, len(file.Interception), len(whitelist), len(blacklist)))
+
+	if source != SourceFileSeedsDatabase {
+		fileChecksum = checksum
+		return nil
+	}
+
+	// 释放锁后再写入MongoDB，写入成功后才记录摘要，失败时文件下次变化或重启时会重新写入
+	if err := saveInterceptionRules(snapshot.Interception); err != nil {
+		return fmt.Errorf("规则文件写入MongoDB失败: %w", err)
+	}
+	if err := saveIPControlRules(snapshot.Whitelist, snapshot.Blacklist); err != nil {
+		return fmt.Errorf("规则文件写入MongoDB失败: %w", err)
+	}
+	fileChecksum = checksum
+
+	recordVersion(SystemAuthor, "load rules file "+filepath.Base(path), diffSnapshots(before, snapshot), ruleOrder(snapshot.Interception))
+	return nil
+}
+
+// WatchRulesFile 监视规则文件，变化后重新加载，ctx 取消时停止
+// 监视的是文件所在目录，以兼容编辑器先写临时文件再重命名、以及通过符号链接替换文件的情况
+func WatchRulesFile(ctx context.Context, path string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建规则文件监视器失败: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("监视规则文件失败: %w", err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				// 合并短时间内的多个事件，文件内容未变化时 LoadRulesFile 直接返回
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, func() {
+					if err := LoadRulesFile(path); err != nil {
+						logging.LogError(fmt.Errorf("重新加载规则文件失败，继续使用当前规则: %w", err))
+					}
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logging.LogError(fmt.Errorf("监视规则文件出错: %w", err))
+			}
+		}
+	}()
+	return nil
+}
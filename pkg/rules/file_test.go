@@ -0,0 +1,268 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+const testRulesFile = `
+interception:
+  - name: 路径遍历
+    regex: '\.\./'
+    targets: [path]
+ip_control:
+  whitelist:
+    - 10.0.0.1
+  blacklist:
+    - ip: 192.168.0.0/16
+      comment: 内网扫描
+`
+
+// writeRulesFile 写入规则文件并返回路径
+func writeRulesFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("写入规则文件失败: %v", err)
+	}
+	return path
+}
+
+// resetRules 恢复规则来源和规则的初始状态，避免影响其他测试
+func resetRules() {
+	rulesMutex.Lock()
+	rulesSource = SourceDatabase
+	interceptionRules = InterceptionRules{}
+	activeRuleset.Store(&Ruleset{})
+	ipControlRules = IPControlRules{}
+	rebuildIPSets()
+	rulesMutex.Unlock()
+
+	fileMutex.Lock()
+	fileChecksum = ""
+	fileMutex.Unlock()
+	mongoCollection = nil
+}
+
+// waitFor 等待条件成立，超时返回false
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return false
+}
+
+func TestLoadRulesFile(t *testing.T) {
+	defer resetRules()
+	SetRulesSource(SourceFile)
+	path := writeRulesFile(t, t.TempDir(), testRulesFile)
+
+	if err := LoadRulesFile(path); err != nil {
+		t.Fatalf("加载规则文件失败: %v", err)
+	}
+	if rules := GetInterceptionRules(); len(rules.Rules) != 1 || rules.Rules[0].Name != "路径遍历" {
+		t.Errorf("拦截规则 %+v，期望只有规则文件中的一条", rules)
+	}
+	if !CheckRequest(httptest.NewRequest("GET", "/a/../b", nil)).Blocked {
+		t.Error("规则文件中的拦截规则应当生效")
+	}
+	if allowed, inWhitelist := IsAllowed("10.0.0.1"); !allowed || !inWhitelist {
+		t.Error("规则文件中的白名单应当生效")
+	}
+	if allowed, _ := IsAllowed("192.168.3.4"); allowed {
+		t.Error("规则文件中的黑名单应当生效")
+	}
+	checksum := RulesFileChecksum()
+	if len(checksum) != 64 {
+		t.Errorf("应记录规则文件的SHA-256摘要，实际 %q", checksum)
+	}
+
+	// 无效的规则文件不替换当前规则
+	writeRulesFile(t, filepath.Dir(path), "interception:\n  - name: bad\n    regex: '('\n")
+	if err := LoadRulesFile(path); err == nil {
+		t.Error("无效的规则文件应当返回错误")
+	}
+	if rules := GetInterceptionRules(); len(rules.Rules) != 1 || RulesFileChecksum() != checksum {
+		t.Errorf("加载失败后应保留当前规则，实际 %+v", rules)
+	}
+}
+
+func TestWatchRulesFile(t *testing.T) {
+	defer resetRules()
+	SetRulesSource(SourceFile)
+	dir := t.TempDir()
+	path := writeRulesFile(t, dir, testRulesFile)
+	if err := LoadRulesFile(path); err != nil {
+		t.Fatalf("加载规则文件失败: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := WatchRulesFile(ctx, path); err != nil {
+		t.Fatalf("监视规则文件失败: %v", err)
+	}
+
+	// 直接写入文件
+	writeRulesFile(t, dir, "ip_control:\n  blacklist: [10.0.0.1]\n")
+	if !waitFor(func() bool { allowed, _ := IsAllowed("10.0.0.1"); return !allowed }) {
+		t.Fatal("写入规则文件后应重新加载")
+	}
+	if len(GetInterceptionRules().Rules) != 0 {
+		t.Error("重新加载后应删除文件中已不存在的拦截规则")
+	}
+
+	// 编辑器先写临时文件再重命名
+	temp := filepath.Join(dir, ".rules.yaml.swp")
+	if err := os.WriteFile(temp, []byte("ip_control:\n  whitelist: [10.0.0.2]\n"), 0o644); err != nil {
+		t.Fatalf("写入临时文件失败: %v", err)
+	}
+	if err := os.Rename(temp, path); err != nil {
+		t.Fatalf("重命名失败: %v", err)
+	}
+	if !waitFor(func() bool { _, inWhitelist := IsAllowed("10.0.0.2"); return inWhitelist }) {
+		t.Fatal("重命名替换规则文件后应重新加载")
+	}
+	if allowed, _ := IsAllowed("10.0.0.1"); !allowed {
+		t.Error("重命名替换后旧的黑名单不应继续生效")
+	}
+}
+
+func TestRulesSource(t *testing.T) {
+	defer resetRules()
+	tests := []struct {
+		source   string
+		want     string
+		readOnly bool
+	}{
+		{source: SourceDatabase, want: SourceDatabase},
+		{source: SourceFile, want: SourceFile, readOnly: true},
+		{source: SourceFileSeedsDatabase, want: SourceFileSeedsDatabase},
+		{source: "", want: SourceDatabase},
+		{source: "files", want: SourceDatabase},
+	}
+	for _, tt := range tests {
+		SetRulesSource(tt.source)
+		if got := RulesSource(); got != tt.want {
+			t.Errorf("%q: 规则来源 %q，期望 %q", tt.source, got, tt.want)
+		}
+		if err := checkWritable(); errors.Is(err, ErrRulesReadOnly) != tt.readOnly {
+			t.Errorf("%q: checkWritable 返回 %v，期望只读 %v", tt.source, err, tt.readOnly)
+		}
+	}
+}
+
+func TestFileSourceReadOnlyAPI(t *testing.T) {
+	defer resetRules()
+	SetRulesSource(SourceFile)
+	if err := LoadRulesFile(writeRulesFile(t, t.TempDir(), testRulesFile)); err != nil {
+		t.Fatalf("加载规则文件失败: %v", err)
+	}
+
+	// 未设置MongoDB集合，任何写入MongoDB的尝试都会失败
+	writes := map[string]func() error{
+		"AddIPRule":    func() error { return AddIPRule(IPControlRule{IPEntry: IPEntry{IP: "1.1.1.1"}, Type: "blacklist"}) },
+		"DeleteIPRule": func() error { return DeleteIPRule("10.0.0.1", "alice") },
+		"UpdateIPRule": func() error {
+			_, err := UpdateIPRule("10.0.0.1", IPControlRule{Type: "whitelist"}, "", "alice")
+			return err
+		},
+		"AddInterceptionRule":    func() error { return AddInterceptionRule(Pattern{Name: "x", Regex: "x"}, "alice") },
+		"DeleteInterceptionRule": func() error { return DeleteInterceptionRule("路径遍历", "alice") },
+		"UpdateInterceptionRule": func() error {
+			_, err := UpdateInterceptionRule("路径遍历", Pattern{Regex: "x"}, "", "alice")
+			return err
+		},
+		"ReplaceIPList": func() error {
+			_, err := ReplaceIPList("whitelist", nil, "alice", false)
+			return err
+		},
+		"ReplaceInterceptionRules": func() error {
+			_, err := ReplaceInterceptionRules(nil, "alice", false, true)
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(); !errors.Is(err, ErrRulesReadOnly) {
+			t.Errorf("%s: 期望 ErrRulesReadOnly，结果 %v", name, err)
+		}
+	}
+
+	// 试运行只比较差异，文件模式下同样允许
+	if diff, err := ReplaceIPList("whitelist", nil, "alice", true); err != nil || len(diff.Removed) != 1 {
+		t.Errorf("文件模式下应允许试运行，结果 %+v %v", diff, err)
+	}
+	if rules := GetIPControlRules(); len(rules.Whitelist) != 1 || len(GetInterceptionRules().Rules) != 1 {
+		t.Errorf("只读模式下规则不应被修改: %+v", rules)
+	}
+}
+
+func TestFileSeedsDatabase(t *testing.T) {
+	defer resetRules()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("写入成功后记录摘要", func(mt *mtest.T) {
+		resetRules()
+		SetRulesSource(SourceFileSeedsDatabase)
+		SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+		)
+		if err := LoadRulesFile(writeRulesFile(mt.T, mt.T.TempDir(), testRulesFile)); err != nil {
+			mt.Fatalf("加载规则文件失败: %v", err)
+		}
+		if RulesFileChecksum() == "" {
+			mt.Error("写入MongoDB成功后应记录摘要")
+		}
+
+		// 拦截规则和名单分别写入
+		var collections []string
+		for _, event := range mt.GetAllStartedEvents() {
+			if event.CommandName == "update" {
+				collections = append(collections, event.Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q", "type").StringValue())
+			}
+		}
+		if len(collections) != 2 || collections[0] != "interception" || collections[1] != "ip_control" {
+			mt.Errorf("写入的规则 %v，期望 interception 和 ip_control", collections)
+		}
+	})
+
+	mt.Run("写入失败时不记录摘要", func(mt *mtest.T) {
+		resetRules()
+		SetRulesSource(SourceFileSeedsDatabase)
+		SetMongoCollection(mt.Coll)
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}))
+		path := writeRulesFile(mt.T, mt.T.TempDir(), testRulesFile)
+		if err := LoadRulesFile(path); err == nil {
+			mt.Fatal("写入MongoDB失败时应返回错误")
+		}
+		if RulesFileChecksum() != "" {
+			mt.Error("写入失败时不应记录摘要，否则文件未变化时不会重试")
+		}
+		// 规则仍然在本实例生效
+		if len(GetInterceptionRules().Rules) != 1 {
+			mt.Error("写入失败时规则文件仍应在本实例生效")
+		}
+
+		// 再次加载同一文件时重新写入
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		if err := LoadRulesFile(path); err != nil || RulesFileChecksum() == "" {
+			mt.Errorf("重试写入失败: %v", err)
+		}
+	})
+}
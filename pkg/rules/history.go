@@ -0,0 +1,463 @@
+// pkg/rules/history.go
+
+package rules
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SystemAuthor 过期清理、规则文件等非人工修改在版本历史中记录的作者
+const SystemAuthor = "system"
+
+// ErrVersionNotFound 规则版本不存在
+var ErrVersionNotFound = errors.New("规则版本不存在")
+
+// RulesSnapshot 某个版本的全部拦截规则和IP控制规则
+type RulesSnapshot struct {
+	Interception []Pattern `bson:"interception" json:"interception"`
+	Whitelist    []IPEntry `bson:"whitelist" json:"whitelist"`
+	Blacklist    []IPEntry `bson:"blacklist" json:"blacklist"`
+}
+
+// VersionDiff 两个版本之间的差异
+type VersionDiff struct {
+	Interception RulesDiff  `bson:"interception" json:"interception"`
+	Whitelist    IPListDiff `bson:"whitelist" json:"whitelist"`
+	Blacklist    IPListDiff `bson:"blacklist" json:"blacklist"`
+}
+
+// Empty 判断两个版本的规则是否相同
+func (d VersionDiff) Empty() bool {
+	return len(d.Interception.Added)+len(d.Interception.Removed)+len(d.Interception.Changed)+
+		len(d.Whitelist.Added)+len(d.Whitelist.Removed)+len(d.Whitelist.Changed)+
+		len(d.Blacklist.Added)+len(d.Blacklist.Removed)+len(d.Blacklist.Changed) == 0
+}
+
+// ipList 返回差异中白名单或黑名单的部分
+func (d *VersionDiff) ipList(listType string) *IPListDiff {
+	if listType == "whitelist" {
+		return &d.Whitelist
+	}
+	return &d.Blacklist
+}
+
+// RuleVersion 规则的一个历史版本，写入后不再修改
+// 每个版本只保存与上一个版本的差异，每隔 checkpointInterval 个版本保存一次全部规则作为检查点
+type RuleVersion struct {
+	Version   int64          `bson:"version" json:"version"`
+	Author    string         `bson:"author" json:"author"`
+	Action    string         `bson:"action" json:"action"` // 产生该版本的操作，例如 "add interception rule SQL注入"
+	CreatedAt time.Time      `bson:"created_at" json:"created_at"`
+	Diff      VersionDiff    `bson:"diff" json:"diff"`                             // 与上一个版本的差异
+	RuleOrder []string       `bson:"rule_order,omitempty" json:"-"`                // 拦截规则有变化时记录修改后的规则顺序，差异中不包含顺序
+	Snapshot  *RulesSnapshot `bson:"snapshot,omitempty" json:"snapshot,omitempty"` // 检查点版本保存的全部规则，查询单个版本时还原
+}
+
+const (
+	maxVersionRetries  = 5  // 多个实例同时写入同一版本号时的重试次数
+	checkpointInterval = 50 // 每隔多少个版本保存一次全部规则
+)
+
+var (
+	historyCollection *mongo.Collection
+	historyHead       *RuleVersion // 本实例已知的最新版本及其全部规则，避免每次写入都从检查点还原，由 writeMutex 保护
+)
+
+// SetHistoryCollection 设置保存规则版本历史的MongoDB集合，未设置时不记录历史
+func SetHistoryCollection(collection *mongo.Collection) {
+	historyCollection = collection
+}
+
+// LoadVersionHistory 创建版本号索引，历史为空时将当前规则记录为第一个版本
+func LoadVersionHistory(ctx context.Context) error {
+	_, err := historyCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("创建规则版本索引失败: %w", err)
+	}
+
+	count, err := historyCollection.CountDocuments(ctx, bson.M{})
+	if err != nil || count > 0 {
+		return err
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+	rulesMutex.RLock()
+	snapshot := currentSnapshot()
+	rulesMutex.RUnlock()
+	_, err = insertVersion(ctx, SystemAuthor, "initial", diffSnapshots(RulesSnapshot{}, snapshot), ruleOrder(snapshot.Interception))
+	return err
+}
+
+// currentSnapshot 返回当前规则的副本，调用方需持有锁
+func currentSnapshot() RulesSnapshot {
+	return RulesSnapshot{
+		Interception: append([]Pattern{}, interceptionRules.Rules...),
+		Whitelist:    append([]IPEntry{}, ipControlRules.Whitelist...),
+		Blacklist:    append([]IPEntry{}, ipControlRules.Blacklist...),
+	}
+}
+
+// diffSnapshots 比较两个版本的规则
+func diffSnapshots(before, after RulesSnapshot) VersionDiff {
+	return VersionDiff{
+		Interception: diffInterceptionRules(before.Interception, after.Interception),
+		Whitelist:    diffIPList(before.Whitelist, after.Whitelist),
+		Blacklist:    diffIPList(before.Blacklist, after.Blacklist),
+	}
+}
+
+// ruleOrder 返回拦截规则的名称顺序，规则按顺序检查，差异中不包含顺序
+func ruleOrder(patterns []Pattern) []string {
+	order := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		order = append(order, pattern.Name)
+	}
+	return order
+}
+
+// applyDiff 在规则上应用一个版本的差异，返回新的规则，不修改传入的规则
+func applyDiff(base RulesSnapshot, diff VersionDiff, order []string) RulesSnapshot {
+	return RulesSnapshot{
+		Interception: applyRulesDiff(base.Interception, diff.Interception, order),
+		Whitelist:    applyIPListDiff(base.Whitelist, diff.Whitelist),
+		Blacklist:    applyIPListDiff(base.Blacklist, diff.Blacklist),
+	}
+}
+
+// applyRulesDiff 按名称应用拦截规则的差异，order 不为空时按其排列
+func applyRulesDiff(base []Pattern, diff RulesDiff, order []string) []Pattern {
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 && order == nil {
+		return base
+	}
+	updated := make(map[string]Pattern, len(diff.Added)+len(diff.Changed))
+	for _, pattern := range append(append([]Pattern{}, diff.Changed...), diff.Added...) {
+		updated[pattern.Name] = pattern
+	}
+	removed := make(map[string]bool, len(diff.Removed))
+	for _, pattern := range diff.Removed {
+		removed[pattern.Name] = true
+	}
+
+	result := make([]Pattern, 0, len(base)+len(diff.Added))
+	for _, pattern := range base {
+		if removed[pattern.Name] {
+			continue
+		}
+		if replacement, found := updated[pattern.Name]; found {
+			pattern = replacement
+			delete(updated, pattern.Name)
+		}
+		result = append(result, pattern)
+	}
+	for _, pattern := range diff.Added {
+		if _, found := updated[pattern.Name]; found {
+			result = append(result, pattern)
+		}
+	}
+	if order == nil {
+		return result
+	}
+
+	position := make(map[string]int, len(order))
+	for i, name := range order {
+		position[name] = i
+	}
+	slices.SortStableFunc(result, func(a, b Pattern) int {
+		pa, foundA := position[a.Name]
+		pb, foundB := position[b.Name]
+		switch {
+		case !foundA || !foundB:
+			return boolCompare(foundB, foundA) // 不在顺序中的规则排在最后
+		default:
+			return pa - pb
+		}
+	})
+	return result
+}
+
+// boolCompare 用于排序，true 排在 false 之前
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// applyIPListDiff 按条目应用名单的差异，保留未修改条目的顺序
+func applyIPListDiff(base []IPEntry, diff IPListDiff) []IPEntry {
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		return base
+	}
+	updated := make(map[string]IPEntry, len(diff.Added)+len(diff.Changed))
+	for _, entry := range append(append([]IPEntry{}, diff.Changed...), diff.Added...) {
+		updated[entry.IP] = entry
+	}
+	removed := make(map[string]bool, len(diff.Removed))
+	for _, entry := range diff.Removed {
+		removed[entry.IP] = true
+	}
+
+	result := make([]IPEntry, 0, len(base)+len(diff.Added))
+	for _, entry := range base {
+		if removed[entry.IP] {
+			continue
+		}
+		if replacement, found := updated[entry.IP]; found {
+			entry = replacement
+			delete(updated, entry.IP)
+		}
+		result = append(result, entry)
+	}
+	for _, entry := range diff.Added {
+		if _, found := updated[entry.IP]; found {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// recordVersion 修改写入MongoDB后记录新版本，失败时只记录日志
+// 调用方需持有 writeMutex 且不能持有 rulesMutex，写入历史期间不阻塞请求检查
+func recordVersion(author, action string, diff VersionDiff, order []string) {
+	if historyCollection == nil {
+		return
+	}
+	if _, err := insertVersion(context.Background(), author, action, diff, order); err != nil {
+		logging.LogError(fmt.Errorf("记录规则版本失败: %w", err))
+	}
+}
+
+// insertVersion 在最新版本上应用差异写入新版本，规则没有变化时不写入并返回nil，调用方需持有 writeMutex
+// order 为修改后的拦截规则顺序，拦截规则未修改时为nil
+// 版本号取最新版本加一，其他实例同时写入相同版本号时重新读取最新版本后重试
+func insertVersion(ctx context.Context, author, action string, diff VersionDiff, order []string) (*RuleVersion, error) {
+	for attempt := 0; attempt < maxVersionRetries; attempt++ {
+		latest, err := latestVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if diff.Empty() && (order == nil || slices.Equal(order, ruleOrder(latest.Snapshot.Interception))) {
+			return nil, nil
+		}
+
+		snapshot := applyDiff(*latest.Snapshot, diff, order)
+		version := RuleVersion{
+			Version:   latest.Version + 1,
+			Author:    author,
+			Action:    action,
+			CreatedAt: time.Now(),
+			Diff:      diff,
+			RuleOrder: order,
+		}
+		if version.Version == 1 || version.Version%checkpointInterval == 0 {
+			version.Snapshot = &snapshot
+		}
+
+		_, err = historyCollection.InsertOne(ctx, version)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		version.Snapshot = &snapshot
+		historyHead = &version
+		return &version, nil
+	}
+	return nil, errors.New("写入规则版本冲突次数过多")
+}
+
+// latestVersion 返回最新版本及其全部规则，还没有任何版本时返回版本号为0的空规则，调用方需持有 writeMutex
+// 最新版本由本实例写入时直接使用缓存，由其他实例写入时从检查点还原
+func latestVersion(ctx context.Context) (*RuleVersion, error) {
+	var latest RuleVersion
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}).SetProjection(bson.M{"version": 1})
+	err := historyCollection.FindOne(ctx, bson.M{}, opts).Decode(&latest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &RuleVersion{Snapshot: &RulesSnapshot{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if historyHead != nil && historyHead.Version == latest.Version {
+		return historyHead, nil
+	}
+
+	if latest.Snapshot, err = loadSnapshot(ctx, latest.Version); err != nil {
+		return nil, err
+	}
+	historyHead = &latest
+	return &latest, nil
+}
+
+// loadSnapshot 从不晚于该版本的最近一个检查点开始依次应用差异，还原该版本的全部规则
+func loadSnapshot(ctx context.Context, version int64) (*RulesSnapshot, error) {
+	var checkpoint RuleVersion
+	err := historyCollection.FindOne(ctx,
+		bson.M{"version": bson.M{"$lte": version}, "snapshot": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}}),
+	).Decode(&checkpoint)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d 之前没有检查点", ErrVersionNotFound, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	snapshot := *checkpoint.Snapshot
+	if checkpoint.Version == version {
+		return &snapshot, nil
+	}
+
+	cursor, err := historyCollection.Find(ctx,
+		bson.M{"version": bson.M{"$gt": checkpoint.Version, "$lte": version}},
+		options.Find().SetSort(bson.D{{Key: "version", Value: 1}}).SetProjection(bson.M{"snapshot": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var next RuleVersion
+		if err := cursor.Decode(&next); err != nil {
+			return nil, err
+		}
+		snapshot = applyDiff(snapshot, next.Diff, next.RuleOrder)
+	}
+	return &snapshot, cursor.Err()
+}
+
+// ListVersions 按版本号倒序分页返回历史版本，不包含规则快照
+func ListVersions(ctx context.Context, page, pageSize int) ([]RuleVersion, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 20
+	}
+
+	total, err := historyCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "version", Value: -1}}).
+		SetSkip(int64((page - 1) * pageSize)).
+		SetLimit(int64(pageSize)).
+		SetProjection(bson.M{"snapshot": 0})
+	cursor, err := historyCollection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	versions := []RuleVersion{}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, 0, err
+	}
+	return versions, total, nil
+}
+
+// GetVersion 返回指定版本，包含该版本的全部规则，非检查点版本由最近的检查点和之后的差异还原
+func GetVersion(ctx context.Context, version int64) (*RuleVersion, error) {
+	var result RuleVersion
+	err := historyCollection.FindOne(ctx, bson.M{"version": version}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Snapshot == nil {
+		if result.Snapshot, err = loadSnapshot(ctx, version); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
+
+// DiffVersions 比较两个版本，返回从 from 变为 to 的差异
+func DiffVersions(ctx context.Context, from, to int64) (VersionDiff, error) {
+	before, err := GetVersion(ctx, from)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	after, err := GetVersion(ctx, to)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	return diffSnapshots(*before.Snapshot, *after.Snapshot), nil
+}
+
+// Rollback 将规则恢复为指定版本并立即生效，回滚本身记录为一个新版本
+// 返回新版本，规则与当前相同时返回nil；回滚期间已过期的IP条目不会恢复
+func Rollback(ctx context.Context, version int64, author string) (*RuleVersion, error) {
+	target, err := GetVersion(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// 在加锁前编译规则和构建前缀树，替换时不阻塞请求检查
+	snapshot := target.Snapshot
+	ruleset, err := CompileRuleset(snapshot.Interception)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	whitelist := unexpired(snapshot.Whitelist, now)
+	blacklist := unexpired(snapshot.Blacklist, now)
+	whitelistTrie, _ := newIPSet(ipList(whitelist))
+	blacklistTrie, _ := newIPSet(ipList(blacklist))
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil {
+		rulesMutex.Unlock()
+		return nil, err
+	}
+	// 替换后旧的名单不再被修改，释放锁后再与新规则比较
+	before := RulesSnapshot{Interception: interceptionRules.Rules, Whitelist: ipControlRules.Whitelist, Blacklist: ipControlRules.Blacklist}
+	interceptionRules = InterceptionRules{Rules: snapshot.Interception}
+	activeRuleset.Store(ruleset)
+	setIPList(true, whitelist, whitelistTrie)
+	setIPList(false, blacklist, blacklistTrie)
+	bumpVersion()
+	rulesMutex.Unlock()
+
+	after := RulesSnapshot{Interception: snapshot.Interception, Whitelist: whitelist, Blacklist: blacklist}
+	if err := saveInterceptionRules(after.Interception); err != nil {
+		return nil, err
+	}
+	if err := saveIPControlRules(after.Whitelist, after.Blacklist); err != nil {
+		return nil, err
+	}
+	return insertVersion(ctx, author, fmt.Sprintf("rollback to version %d", version), diffSnapshots(before, after), ruleOrder(after.Interception))
+}
+
+// DiffWithCurrent 比较指定版本与当前生效的规则
+func DiffWithCurrent(ctx context.Context, from int64) (VersionDiff, error) {
+	before, err := GetVersion(ctx, from)
+	if err != nil {
+		return VersionDiff{}, err
+	}
+	rulesMutex.RLock()
+	current := currentSnapshot()
+	rulesMutex.RUnlock()
+	return diffSnapshots(*before.Snapshot, current), nil
+}
@@ -0,0 +1,244 @@
+package rules
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// patterns 按名称构造拦截规则，Regex 取名称
+func patterns(names ...string) []Pattern {
+	result := make([]Pattern, 0, len(names))
+	for _, name := range names {
+		result = append(result, Pattern{Name: name, Regex: name})
+	}
+	return result
+}
+
+// snapshotNames 返回规则的名称和名单条目，便于比较
+func snapshotNames(snapshot RulesSnapshot) [3][]string {
+	var names [3][]string
+	names[0] = ruleOrder(snapshot.Interception)
+	for _, entry := range snapshot.Whitelist {
+		names[1] = append(names[1], entry.IP)
+	}
+	for _, entry := range snapshot.Blacklist {
+		names[2] = append(names[2], entry.IP)
+	}
+	return names
+}
+
+// versionDoc 将版本转换为模拟的MongoDB文档
+func versionDoc(t *testing.T, version RuleVersion) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(version)
+	if err != nil {
+		t.Fatalf("序列化版本失败: %v", err)
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("反序列化版本失败: %v", err)
+	}
+	return doc
+}
+
+func TestApplyDiff(t *testing.T) {
+	base := RulesSnapshot{
+		Interception: patterns("a", "b", "c"),
+		Whitelist:    ipEntries("10.0.0.1", "10.0.0.2"),
+	}
+	tests := []struct {
+		name  string
+		diff  VersionDiff
+		order []string
+		want  [3][]string
+	}{
+		{name: "没有差异", want: [3][]string{{"a", "b", "c"}, {"10.0.0.1", "10.0.0.2"}, nil}},
+		{name: "新增规则排在最后", diff: VersionDiff{Interception: RulesDiff{Added: patterns("d")}}, order: []string{"a", "b", "c", "d"},
+			want: [3][]string{{"a", "b", "c", "d"}, {"10.0.0.1", "10.0.0.2"}, nil}},
+		{name: "删除和修改规则", diff: VersionDiff{Interception: RulesDiff{Removed: patterns("a"), Changed: []Pattern{{Name: "c", Regex: "x"}}}}, order: []string{"b", "c"},
+			want: [3][]string{{"b", "c"}, {"10.0.0.1", "10.0.0.2"}, nil}},
+		{name: "只调整顺序", order: []string{"c", "a", "b"}, want: [3][]string{{"c", "a", "b"}, {"10.0.0.1", "10.0.0.2"}, nil}},
+		{name: "改名后保持位置", diff: VersionDiff{Interception: RulesDiff{Added: patterns("b2"), Removed: patterns("b")}}, order: []string{"a", "b2", "c"},
+			want: [3][]string{{"a", "b2", "c"}, {"10.0.0.1", "10.0.0.2"}, nil}},
+		{name: "名单条目在两个名单之间移动", diff: VersionDiff{Whitelist: IPListDiff{Removed: ipEntries("10.0.0.1")}, Blacklist: IPListDiff{Added: ipEntries("10.0.0.1")}},
+			want: [3][]string{{"a", "b", "c"}, {"10.0.0.2"}, {"10.0.0.1"}}},
+	}
+	for _, tt := range tests {
+		got := applyDiff(base, tt.diff, tt.order)
+		if names := snapshotNames(got); !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("%s: 结果 %v，期望 %v", tt.name, names, tt.want)
+		}
+	}
+	if ruleOrder(base.Interception)[0] != "a" || len(base.Whitelist) != 2 {
+		t.Error("应用差异不应修改传入的规则")
+	}
+	if got := applyDiff(base, VersionDiff{Interception: RulesDiff{Changed: []Pattern{{Name: "c", Regex: "x"}}}}, nil); got.Interception[2].Regex != "x" {
+		t.Errorf("修改的规则应替换原规则，结果 %+v", got.Interception[2])
+	}
+}
+
+func TestApplyDiffReplaysHistory(t *testing.T) {
+	// 依次记录每一步与上一步的差异，从空规则重放后应得到每一步的规则
+	steps := []RulesSnapshot{
+		{Interception: patterns("a", "b"), Whitelist: ipEntries("10.0.0.1")},
+		{Interception: patterns("b", "a", "c"), Whitelist: ipEntries("10.0.0.1", "10.0.0.2")},
+		{Interception: patterns("c"), Blacklist: ipEntries("10.0.0.1")},
+		{Interception: patterns("d", "c"), Whitelist: ipEntries("10.0.0.3"), Blacklist: ipEntries("10.0.0.1", "10.0.0.4")},
+		{},
+	}
+	var previous, replayed RulesSnapshot
+	for i, step := range steps {
+		diff := diffSnapshots(previous, step)
+		replayed = applyDiff(replayed, diff, ruleOrder(step.Interception))
+		if got, want := snapshotNames(replayed), snapshotNames(step); !reflect.DeepEqual(got, want) {
+			t.Errorf("第 %d 步: 重放结果 %v，期望 %v", i, got, want)
+		}
+		previous = step
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	defer SetHistoryCollection(nil)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("从检查点还原", func(mt *mtest.T) {
+		SetHistoryCollection(mt.Coll)
+		checkpoint := RuleVersion{Version: 50, Snapshot: &RulesSnapshot{Interception: patterns("a"), Whitelist: ipEntries("10.0.0.1")}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, versionDoc(t, checkpoint)),
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch,
+				versionDoc(t, RuleVersion{Version: 51, Diff: VersionDiff{Interception: RulesDiff{Added: patterns("b")}}, RuleOrder: []string{"b", "a"}}),
+				versionDoc(t, RuleVersion{Version: 52, Diff: VersionDiff{Whitelist: IPListDiff{Removed: ipEntries("10.0.0.1")}, Blacklist: IPListDiff{Added: ipEntries("10.0.0.2")}}}),
+			),
+		)
+		snapshot, err := loadSnapshot(context.Background(), 52)
+		if err != nil {
+			mt.Fatalf("还原版本失败: %v", err)
+		}
+		want := [3][]string{{"b", "a"}, nil, {"10.0.0.2"}}
+		if got := snapshotNames(*snapshot); !reflect.DeepEqual(got, want) {
+			mt.Errorf("还原结果 %v，期望 %v", got, want)
+		}
+	})
+	mt.Run("检查点版本不需要应用差异", func(mt *mtest.T) {
+		SetHistoryCollection(mt.Coll)
+		checkpoint := RuleVersion{Version: 1, Snapshot: &RulesSnapshot{Interception: patterns("a")}}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, versionDoc(t, checkpoint)))
+		snapshot, err := loadSnapshot(context.Background(), 1)
+		if err != nil || len(snapshot.Interception) != 1 {
+			mt.Errorf("还原结果 %+v(%v)，期望检查点的规则", snapshot, err)
+		}
+	})
+}
+
+func TestInsertVersion(t *testing.T) {
+	defer func() {
+		SetHistoryCollection(nil)
+		historyHead = nil
+	}()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name       string
+		head       int64
+		rules      []string // 最新版本的拦截规则
+		diff       VersionDiff
+		order      []string
+		inserted   bool
+		checkpoint bool
+	}{
+		{name: "规则没有变化", head: 3, rules: []string{"a"}, order: []string{"a"}},
+		{name: "只保存差异", head: 3, rules: []string{"a"}, diff: VersionDiff{Interception: RulesDiff{Added: patterns("b")}}, order: []string{"a", "b"}, inserted: true},
+		{name: "只调整顺序也记录版本", head: 3, rules: []string{"b", "a"}, order: []string{"a", "b"}, inserted: true},
+		{name: "定期保存检查点", head: checkpointInterval - 1, rules: []string{"a"}, diff: VersionDiff{Blacklist: IPListDiff{Added: ipEntries("10.0.0.1")}}, inserted: true, checkpoint: true},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			SetHistoryCollection(mt.Coll)
+			// 最新版本由本实例写入，不需要从检查点还原
+			head := RulesSnapshot{Interception: patterns(tt.rules...)}
+			historyHead = &RuleVersion{Version: tt.head, Snapshot: &head}
+			mt.AddMockResponses(
+				mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, bson.D{{Key: "version", Value: tt.head}}),
+				mtest.CreateSuccessResponse(),
+			)
+
+			version, err := insertVersion(context.Background(), "alice", tt.name, tt.diff, tt.order)
+			if err != nil {
+				mt.Fatalf("写入版本失败: %v", err)
+			}
+			if (version != nil) != tt.inserted {
+				mt.Fatalf("写入了版本 %+v，期望写入 %v", version, tt.inserted)
+			}
+			if !tt.inserted {
+				return
+			}
+			if version.Version != tt.head+1 || historyHead != version {
+				mt.Errorf("新版本号 %d，期望 %d，并成为最新版本", version.Version, tt.head+1)
+			}
+			if want := snapshotNames(applyDiff(head, tt.diff, tt.order)); !reflect.DeepEqual(snapshotNames(*version.Snapshot), want) {
+				mt.Errorf("新版本的规则 %v，期望 %v", snapshotNames(*version.Snapshot), want)
+			}
+
+			event := mt.GetStartedEvent()
+			for event != nil && event.CommandName != "insert" {
+				event = mt.GetStartedEvent()
+			}
+			if event == nil {
+				mt.Fatal("没有写入版本")
+			}
+			doc := event.Command.Lookup("documents").Array().Index(0).Value().Document()
+			if _, err := doc.LookupErr("snapshot"); (err == nil) != tt.checkpoint {
+				mt.Errorf("写入的版本包含全部规则 %v，期望 %v", err == nil, tt.checkpoint)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	defer func() {
+		resetRules()
+		SetHistoryCollection(nil)
+		historyHead = nil
+	}()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("回滚后记录差异", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		SetHistoryCollection(mt.Coll)
+		rulesMutex.Lock()
+		interceptionRules = InterceptionRules{Rules: patterns("a", "b")}
+		ipControlRules = IPControlRules{Blacklist: ipEntries("10.0.0.1")}
+		rebuildIPSets()
+		rulesMutex.Unlock()
+		historyHead = &RuleVersion{Version: 2, Snapshot: &RulesSnapshot{Interception: patterns("a", "b"), Blacklist: ipEntries("10.0.0.1")}}
+
+		target := RuleVersion{Version: 1, Snapshot: &RulesSnapshot{Interception: patterns("a"), Whitelist: ipEntries("10.0.0.2")}}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, versionDoc(t, target)),
+			mtest.CreateSuccessResponse(), // 拦截规则
+			mtest.CreateSuccessResponse(), // IP控制规则
+			mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch, bson.D{{Key: "version", Value: int64(2)}}),
+			mtest.CreateSuccessResponse(),
+		)
+		version, err := Rollback(context.Background(), 1, "alice")
+		if err != nil {
+			mt.Fatalf("回滚失败: %v", err)
+		}
+		if version == nil || version.Version != 3 {
+			mt.Fatalf("回滚应记录为版本3，结果 %+v", version)
+		}
+		diff := version.Diff
+		if len(diff.Interception.Removed) != 1 || len(diff.Whitelist.Added) != 1 || len(diff.Blacklist.Removed) != 1 {
+			mt.Errorf("回滚的差异 %+v，期望删除规则b、添加白名单、删除黑名单", diff)
+		}
+		if allowed, inWhitelist := IsAllowed("10.0.0.2"); !allowed || !inWhitelist {
+			mt.Error("回滚后目标版本的白名单应立即生效")
+		}
+		if allowed, _ := IsAllowed("10.0.0.1"); !allowed {
+			mt.Error("回滚后当前黑名单应被移除")
+		}
+	})
+}
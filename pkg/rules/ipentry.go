@@ -0,0 +1,137 @@
+// pkg/rules/ipentry.go
+
+package rules
+
+import (
+	"Stone/pkg/logging"
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"gopkg.in/yaml.v3"
+)
+
+// IPEntry 白名单或黑名单中的一个条目，IP 可以是单个IP、CIDR或地址段
+type IPEntry struct {
+	IP        string     `bson:"ip" json:"ip" yaml:"ip"`
+	Comment   string     `bson:"comment,omitempty" json:"comment,omitempty" yaml:"comment,omitempty"`          // 添加原因
+	CreatedBy string     `bson:"created_by,omitempty" json:"created_by,omitempty" yaml:"created_by,omitempty"` // 添加该条目的账号
+	CreatedAt *time.Time `bson:"created_at,omitempty" json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	ExpiresAt *time.Time `bson:"expires_at,omitempty" json:"expires_at,omitempty" yaml:"expires_at,omitempty"` // 过期时间，为空表示永久有效
+	Disabled  bool       `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"`       // 停用的条目保留在名单中但不生效
+}
+
+// UnmarshalBSONValue 兼容旧版本以字符串保存的名单条目
+func (e *IPEntry) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.String {
+		var ip string
+		if err := bson.UnmarshalValue(t, data, &ip); err != nil {
+			return err
+		}
+		*e = IPEntry{IP: ip}
+		return nil
+	}
+
+	type plain IPEntry // 避免递归调用 UnmarshalBSONValue
+	return bson.UnmarshalValue(t, data, (*plain)(e))
+}
+
+// UnmarshalYAML 规则文件中的条目可以只写IP字符串
+func (e *IPEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*e = IPEntry{IP: node.Value}
+		return nil
+	}
+
+	type plain IPEntry // 避免递归调用 UnmarshalYAML
+	return node.Decode((*plain)(e))
+}
+
+// Expired 条目是否已过期
+func (e IPEntry) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// ipList 返回名单中启用的IP条目
+func ipList(entries []IPEntry) []IPEntry {
+	list := make([]IPEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Disabled {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// unexpired 返回名单中未过期的条目
+func unexpired(entries []IPEntry, now time.Time) []IPEntry {
+	kept := make([]IPEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Expired(now) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// StartIPSweeper 定期删除已过期的白名单和黑名单条目，ctx 取消时停止
+func StartIPSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := sweepExpiredIPEntries(time.Now()); err != nil {
+					logging.LogError(fmt.Errorf("清理过期的IP控制规则失败: %w", err))
+				}
+			}
+		}
+	}()
+}
+
+// sweepExpiredIPEntries 从名单和MongoDB中删除已过期的条目，过期条目在查找时已被跳过，这里只清理存储
+// 规则来源为 file 时只从内存中删除，规则文件保持不变
+// 每个实例都会清理同样的条目，只有实际修改了MongoDB文档的实例记录版本，避免重复的版本
+func sweepExpiredIPEntries(now time.Time) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	rulesMutex.Lock()
+	var changes []ipEntryChange
+	var diff VersionDiff
+	for _, listType := range []string{"whitelist", "blacklist"} {
+		allowed := listType == "whitelist"
+		list, _, _ := ipListState(allowed)
+		var expired []IPEntry
+		for _, entry := range *list {
+			if entry.Expired(now) {
+				expired = append(expired, entry)
+			}
+		}
+		for _, entry := range expired {
+			removeIPEntry(allowed, entry.IP)
+			changes = append(changes, ipEntryChange{List: listType, IP: entry.IP})
+		}
+		diff.ipList(listType).Removed = expired
+	}
+	if len(changes) > 0 {
+		bumpVersion()
+	}
+	source := rulesSource
+	rulesMutex.Unlock()
+
+	if len(changes) == 0 || source == SourceFile {
+		return nil
+	}
+	modified, err := updateIPEntries(changes)
+	if err != nil || !modified {
+		return err
+	}
+	recordVersion(SystemAuthor, "expire ip entries", diff, nil)
+	return nil
+}
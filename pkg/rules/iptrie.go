@@ -0,0 +1,326 @@
+// pkg/rules/iptrie.go
+
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ErrInvalidIPEntry IP规则条目格式无效
+var ErrInvalidIPEntry = errors.New("无效的IP规则条目")
+
+// trieNode 压缩前缀树节点，entries 非空表示该前缀本身属于这些规则
+// 同一前缀可能来自多个条目，例如 10.0.0.0/8 和包含该网段的地址段，删除其中一个时另一个仍然生效
+type trieNode struct {
+	prefix  netip.Prefix
+	entries []string
+	child   [2]*trieNode
+}
+
+// ipSet 按地址族分别保存的前缀树，用于快速判断IP是否命中名单
+type ipSet struct {
+	v4      *trieNode
+	v6      *trieNode
+	expires map[string]time.Time // 条目的过期时间，查找时跳过已过期的条目
+}
+
+// ParseIPEntry 解析IP规则条目，支持单个IP、CIDR以及 "起始IP-结束IP" 形式的地址段
+// 返回规范化后的条目及其覆盖的前缀列表
+func ParseIPEntry(entry string) (string, []netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return "", nil, fmt.Errorf("%w: 条目为空", ErrInvalidIPEntry)
+	}
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %q 不是有效的CIDR", ErrInvalidIPEntry, entry)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), unmappedBits(prefix)).Masked()
+		return prefix.String(), []netip.Prefix{prefix}, nil
+	}
+
+	if start, end, found := strings.Cut(entry, "-"); found {
+		startAddr, err := netip.ParseAddr(strings.TrimSpace(start))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %q 的起始地址无效", ErrInvalidIPEntry, entry)
+		}
+		endAddr, err := netip.ParseAddr(strings.TrimSpace(end))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %q 的结束地址无效", ErrInvalidIPEntry, entry)
+		}
+		startAddr, endAddr = startAddr.Unmap(), endAddr.Unmap()
+		if startAddr.Is4() != endAddr.Is4() {
+			return "", nil, fmt.Errorf("%w: %q 的起止地址不属于同一地址族", ErrInvalidIPEntry, entry)
+		}
+		if startAddr.Compare(endAddr) > 0 {
+			return "", nil, fmt.Errorf("%w: %q 的起始地址大于结束地址", ErrInvalidIPEntry, entry)
+		}
+		return startAddr.String() + "-" + endAddr.String(), rangeToPrefixes(startAddr, endAddr), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %q 不是有效的IP地址", ErrInvalidIPEntry, entry)
+	}
+	addr = addr.Unmap()
+	return addr.String(), []netip.Prefix{netip.PrefixFrom(addr, addr.BitLen())}, nil
+}
+
+// IPSet 由IP规则条目组成的只读集合，供站点路由等其他模块使用
+type IPSet struct {
+	set *ipSet
+}
+
+// NewIPSet 根据条目构建IP集合，任意条目无效时返回错误
+func NewIPSet(entries []string) (*IPSet, error) {
+	list := make([]IPEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, IPEntry{IP: entry})
+	}
+	set, errs := newIPSet(list)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &IPSet{set: set}, nil
+}
+
+// Contains 判断IP是否命中集合中的任一条目，无法解析的IP视为未命中
+func (s *IPSet) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	_, found := s.set.lookup(addr, time.Now())
+	return found
+}
+
+// newIPSet 根据名单条目构建前缀树，返回无法解析的条目错误
+func newIPSet(entries []IPEntry) (*ipSet, []error) {
+	set := &ipSet{}
+	var errs []error
+	for _, entry := range entries {
+		if err := set.add(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return set, errs
+}
+
+// add 向前缀树中加入一条名单条目，并记录其过期时间
+func (s *ipSet) add(entry IPEntry) error {
+	_, prefixes, err := ParseIPEntry(entry.IP)
+	if err != nil {
+		return err
+	}
+	for _, prefix := range prefixes {
+		insertPrefix(s.root(prefix.Addr()), prefix, entry.IP)
+	}
+	if entry.ExpiresAt != nil {
+		if s.expires == nil {
+			s.expires = make(map[string]time.Time)
+		}
+		s.expires[entry.IP] = *entry.ExpiresAt
+	}
+	return nil
+}
+
+// remove 从前缀树中删除一条名单条目，只删除该条目自身的前缀，不影响其他条目
+func (s *ipSet) remove(entry string) {
+	_, prefixes, err := ParseIPEntry(entry)
+	if err != nil {
+		return
+	}
+	for _, prefix := range prefixes {
+		removePrefix(s.root(prefix.Addr()), prefix, entry)
+	}
+	delete(s.expires, entry)
+}
+
+// expired 条目在 now 时是否已过期，过期的条目在被清理任务删除之前仍留在前缀树中
+func (s *ipSet) expired(entry string, now time.Time) bool {
+	expiresAt, found := s.expires[entry]
+	return found && !now.Before(expiresAt)
+}
+
+// root 返回地址所属地址族的前缀树
+func (s *ipSet) root(addr netip.Addr) **trieNode {
+	if addr.Is4() {
+		return &s.v4
+	}
+	return &s.v6
+}
+
+// lookup 查找包含该地址且在 now 时未过期的名单条目
+func (s *ipSet) lookup(addr netip.Addr, now time.Time) (string, bool) {
+	if s == nil || !addr.IsValid() {
+		return "", false
+	}
+	addr = addr.Unmap()
+	node := *s.root(addr)
+
+	for node != nil && node.prefix.Contains(addr) {
+		for _, entry := range node.entries {
+			if !s.expired(entry, now) {
+				return entry, true
+			}
+		}
+		if node.prefix.Bits() >= addr.BitLen() {
+			break
+		}
+		node = node.child[addrBit(addr, node.prefix.Bits())]
+	}
+	return "", false
+}
+
+// insertPrefix 将前缀插入压缩前缀树，必要时分裂已有节点
+// 被更短前缀覆盖的前缀同样保留，删除较短的前缀后仍然生效
+func insertPrefix(slot **trieNode, prefix netip.Prefix, entry string) {
+	for {
+		node := *slot
+		if node == nil {
+			*slot = &trieNode{prefix: prefix, entries: []string{entry}}
+			return
+		}
+
+		common := commonPrefixLen(node.prefix, prefix)
+		if common == node.prefix.Bits() && common == prefix.Bits() {
+			for _, existing := range node.entries {
+				if existing == entry {
+					return
+				}
+			}
+			node.entries = append(node.entries, entry)
+			return
+		}
+
+		if common == node.prefix.Bits() {
+			// 已有节点是新前缀的祖先，继续向下查找
+			slot = &node.child[addrBit(prefix.Addr(), common)]
+			continue
+		}
+
+		// 在分叉处创建新的中间节点
+		parent := &trieNode{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked()}
+		parent.child[addrBit(node.prefix.Addr(), common)] = node
+		if common == prefix.Bits() {
+			parent.entries = []string{entry}
+		} else {
+			parent.child[addrBit(prefix.Addr(), common)] = &trieNode{prefix: prefix, entries: []string{entry}}
+		}
+		*slot = parent
+		return
+	}
+}
+
+// removePrefix 从前缀节点中删除条目，节点不再属于任何条目时合并或删除，保持树的压缩形态
+func removePrefix(slot **trieNode, prefix netip.Prefix, entry string) {
+	node := *slot
+	if node == nil || commonPrefixLen(node.prefix, prefix) < node.prefix.Bits() {
+		return
+	}
+
+	if node.prefix.Bits() == prefix.Bits() {
+		for i, existing := range node.entries {
+			if existing == entry {
+				node.entries = append(node.entries[:i], node.entries[i+1:]...)
+				break
+			}
+		}
+	} else if node.prefix.Bits() < prefix.Bits() {
+		removePrefix(&node.child[addrBit(prefix.Addr(), node.prefix.Bits())], prefix, entry)
+	}
+
+	if len(node.entries) > 0 {
+		return
+	}
+	switch {
+	case node.child[0] == nil:
+		*slot = node.child[1]
+	case node.child[1] == nil:
+		*slot = node.child[0]
+	}
+}
+
+// commonPrefixLen 计算两个同族前缀的公共前缀长度
+func commonPrefixLen(a, b netip.Prefix) int {
+	limit := a.Bits()
+	if b.Bits() < limit {
+		limit = b.Bits()
+	}
+
+	aBytes, bBytes := a.Addr().AsSlice(), b.Addr().AsSlice()
+	n := 0
+	for i := range aBytes {
+		if x := aBytes[i] ^ bBytes[i]; x != 0 {
+			n += bits.LeadingZeros8(x)
+			break
+		}
+		n += 8
+	}
+	if n > limit {
+		n = limit
+	}
+	return n
+}
+
+// addrBit 返回地址第 i 位（从最高位开始计数）
+func addrBit(addr netip.Addr, i int) int {
+	if addr.Is4() {
+		b := addr.As4()
+		return int(b[i/8]>>(7-i%8)) & 1
+	}
+	b := addr.As16()
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// unmappedBits 将IPv4映射地址的前缀长度换算为IPv4前缀长度
+func unmappedBits(prefix netip.Prefix) int {
+	if prefix.Addr().Is4In6() {
+		if prefix.Bits() < 96 {
+			return 0
+		}
+		return prefix.Bits() - 96
+	}
+	return prefix.Bits()
+}
+
+// lastAddr 返回前缀覆盖的最后一个地址
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Masked().Addr().AsSlice()
+	for i := prefix.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// rangeToPrefixes 将地址段拆分为最少数量的前缀
+func rangeToPrefixes(start, end netip.Addr) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for {
+		length := start.BitLen()
+		for length > 0 {
+			candidate := netip.PrefixFrom(start, length-1).Masked()
+			if candidate.Addr() != start || lastAddr(candidate).Compare(end) > 0 {
+				break
+			}
+			length--
+		}
+
+		prefix := netip.PrefixFrom(start, length)
+		prefixes = append(prefixes, prefix)
+
+		last := lastAddr(prefix)
+		if last.Compare(end) >= 0 {
+			return prefixes
+		}
+		start = last.Next()
+	}
+}
@@ -0,0 +1,334 @@
+package rules
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestParseIPEntry(t *testing.T) {
+	tests := []struct {
+		entry    string
+		want     string
+		prefixes []string
+	}{
+		{entry: " 10.1.2.3 ", want: "10.1.2.3", prefixes: []string{"10.1.2.3/32"}},
+		{entry: "2001:db8::1", want: "2001:db8::1", prefixes: []string{"2001:db8::1/128"}},
+		{entry: "10.1.2.3/8", want: "10.0.0.0/8", prefixes: []string{"10.0.0.0/8"}},
+		{entry: "2001:db8::1/32", want: "2001:db8::/32", prefixes: []string{"2001:db8::/32"}},
+		{entry: "::ffff:192.168.1.1", want: "192.168.1.1", prefixes: []string{"192.168.1.1/32"}},
+		{entry: "::ffff:10.0.0.0/104", want: "10.0.0.0/8", prefixes: []string{"10.0.0.0/8"}},
+		{entry: "10.0.0.1-10.0.0.6", want: "10.0.0.1-10.0.0.6",
+			prefixes: []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{entry: "10.0.0.0 - 10.0.1.255", want: "10.0.0.0-10.0.1.255", prefixes: []string{"10.0.0.0/23"}},
+		{entry: "::ffff:1.1.1.1-1.1.1.1", want: "1.1.1.1-1.1.1.1", prefixes: []string{"1.1.1.1/32"}},
+		{entry: "2001:db8::-2001:db8::ffff", want: "2001:db8::-2001:db8::ffff", prefixes: []string{"2001:db8::/112"}},
+	}
+	for _, tt := range tests {
+		got, prefixes, err := ParseIPEntry(tt.entry)
+		if err != nil {
+			t.Errorf("%q: 解析失败: %v", tt.entry, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: 规范化结果 %q，期望 %q", tt.entry, got, tt.want)
+		}
+		if !reflect.DeepEqual(prefixStrings(prefixes), tt.prefixes) {
+			t.Errorf("%q: 前缀 %v，期望 %v", tt.entry, prefixes, tt.prefixes)
+		}
+	}
+
+	for _, entry := range []string{"", "10.0.0", "10.0.0.0/33", "10.0.0.9-10.0.0.1", "10.0.0.1-::1", "a-b"} {
+		if _, _, err := ParseIPEntry(entry); !errors.Is(err, ErrInvalidIPEntry) {
+			t.Errorf("%q: 期望 ErrInvalidIPEntry，结果 %v", entry, err)
+		}
+	}
+}
+
+func TestRangeToPrefixes(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       []string
+	}{
+		{start: "0.0.0.0", end: "255.255.255.255", want: []string{"0.0.0.0/0"}},
+		{start: "10.0.0.255", end: "10.0.2.0", want: []string{"10.0.0.255/32", "10.0.1.0/24", "10.0.2.0/32"}},
+		{start: "192.168.0.7", end: "192.168.0.7", want: []string{"192.168.0.7/32"}},
+		{start: "::", end: "::3", want: []string{"::/126"}},
+		{start: "::1", end: "::4", want: []string{"::1/128", "::2/127", "::4/128"}},
+	}
+	for _, tt := range tests {
+		got := rangeToPrefixes(netip.MustParseAddr(tt.start), netip.MustParseAddr(tt.end))
+		if !reflect.DeepEqual(prefixStrings(got), tt.want) {
+			t.Errorf("%s-%s: 前缀 %v，期望 %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestIPSetLookup(t *testing.T) {
+	set, errs := newIPSet(ipEntries(
+		"10.0.0.0/24",
+		"10.0.1.0/24", // 与上一条在 10.0.0.0/23 处分叉
+		"10.0.0.128/25",
+		"172.16.0.1-172.16.0.6",
+		"2001:db8::/32",
+		"192.168.1.1",
+	))
+	if len(errs) > 0 {
+		t.Fatalf("构建前缀树失败: %v", errs)
+	}
+
+	tests := []struct {
+		ip    string
+		entry string
+	}{
+		{ip: "10.0.0.5", entry: "10.0.0.0/24"},
+		{ip: "10.0.0.200", entry: "10.0.0.0/24"}, // 较短的前缀优先
+		{ip: "10.0.1.255", entry: "10.0.1.0/24"},
+		{ip: "10.0.2.1", entry: ""},
+		{ip: "172.16.0.0", entry: ""},
+		{ip: "172.16.0.3", entry: "172.16.0.1-172.16.0.6"},
+		{ip: "172.16.0.7", entry: ""},
+		{ip: "2001:db8:ffff::1", entry: "2001:db8::/32"},
+		{ip: "2001:db9::1", entry: ""},
+		{ip: "::ffff:192.168.1.1", entry: "192.168.1.1"},
+		{ip: "192.168.1.2", entry: ""},
+	}
+	for _, tt := range tests {
+		entry, found := set.lookup(netip.MustParseAddr(tt.ip), time.Now())
+		if found != (tt.entry != "") || entry != tt.entry {
+			t.Errorf("%s: 命中 %q(%v)，期望 %q", tt.ip, entry, found, tt.entry)
+		}
+	}
+}
+
+func TestInsertPrefixSplit(t *testing.T) {
+	set, _ := newIPSet(ipEntries("10.0.0.0/24", "10.0.1.0/24"))
+	root := set.v4
+	if root.prefix.String() != "10.0.0.0/23" || len(root.entries) != 0 {
+		t.Fatalf("分叉处应创建不属于任何条目的 10.0.0.0/23 节点，实际 %v %v", root.prefix, root.entries)
+	}
+	if root.child[0].prefix.String() != "10.0.0.0/24" || root.child[1].prefix.String() != "10.0.1.0/24" {
+		t.Errorf("子节点 %v %v，期望 10.0.0.0/24 和 10.0.1.0/24", root.child[0].prefix, root.child[1].prefix)
+	}
+
+	// 插入分叉点本身时中间节点变为条目节点
+	insertPrefix(&set.v4, netip.MustParsePrefix("10.0.0.0/23"), "10.0.0.0/23")
+	if set.v4 != root || !reflect.DeepEqual(root.entries, []string{"10.0.0.0/23"}) {
+		t.Errorf("10.0.0.0/23 应写入已有的中间节点，实际 %v %v", set.v4.prefix, set.v4.entries)
+	}
+
+	// 删除两个子节点之一后，中间节点与剩下的子节点合并
+	set.remove("10.0.0.0/23")
+	set.remove("10.0.1.0/24")
+	if set.v4.prefix.String() != "10.0.0.0/24" {
+		t.Errorf("合并后根节点应为 10.0.0.0/24，实际 %v", set.v4.prefix)
+	}
+}
+
+func TestIPSetRemove(t *testing.T) {
+	set, _ := newIPSet(ipEntries("10.0.0.0/8", "10.1.0.0/16", "10.1.0.0-10.1.255.255", "10.2.3.4"))
+
+	// 删除较短的前缀后，被它覆盖的条目仍然生效
+	set.remove("10.0.0.0/8")
+	if _, found := set.lookup(netip.MustParseAddr("10.3.0.1"), time.Now()); found {
+		t.Error("10.0.0.0/8 删除后 10.3.0.1 不应命中")
+	}
+	if entry, _ := set.lookup(netip.MustParseAddr("10.2.3.4"), time.Now()); entry != "10.2.3.4" {
+		t.Errorf("10.2.3.4 命中 %q，期望仍然命中自身", entry)
+	}
+
+	// 同一前缀属于两个条目，删除其中一个后另一个仍然生效
+	set.remove("10.1.0.0/16")
+	if entry, _ := set.lookup(netip.MustParseAddr("10.1.2.3"), time.Now()); entry != "10.1.0.0-10.1.255.255" {
+		t.Errorf("10.1.2.3 命中 %q，期望命中地址段", entry)
+	}
+
+	set.remove("10.1.0.0-10.1.255.255")
+	set.remove("10.2.3.4")
+	set.remove("10.9.9.9") // 不存在的条目
+	if set.v4 != nil {
+		t.Errorf("删除全部条目后前缀树应为空，实际 %+v", set.v4)
+	}
+}
+
+func TestIPListIndex(t *testing.T) {
+	whitelist := []IPEntry{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}, {IP: "10.0.0.3", Disabled: true}}
+	set, _ := newIPSet(ipList(whitelist))
+	setIPList(true, whitelist, set)
+	defer setIPList(true, nil, &ipSet{})
+
+	removeIPEntry(true, "10.0.0.1")
+	putIPEntry(true, IPEntry{IP: "10.0.0.4"})
+	putIPEntry(true, IPEntry{IP: "10.0.0.3"}) // 启用已有条目
+
+	for i, entry := range ipControlRules.Whitelist {
+		if whitelistIndex[entry.IP] != i {
+			t.Errorf("条目 %s 的索引为 %d，实际位置 %d", entry.IP, whitelistIndex[entry.IP], i)
+		}
+	}
+	if len(whitelistIndex) != 3 || len(ipControlRules.Whitelist) != 3 {
+		t.Errorf("名单应有3个条目，索引 %v，名单 %v", whitelistIndex, ipControlRules.Whitelist)
+	}
+
+	tests := []struct {
+		ip    string
+		found bool
+	}{
+		{ip: "10.0.0.1", found: false},
+		{ip: "10.0.0.2", found: true},
+		{ip: "10.0.0.3", found: true},
+		{ip: "10.0.0.4", found: true},
+	}
+	for _, tt := range tests {
+		if _, found := whitelistSet.lookup(netip.MustParseAddr(tt.ip), time.Now()); found != tt.found {
+			t.Errorf("%s: 命中 %v，期望 %v", tt.ip, found, tt.found)
+		}
+	}
+
+	putIPEntry(true, IPEntry{IP: "10.0.0.2", Disabled: true})
+	if _, found := whitelistSet.lookup(netip.MustParseAddr("10.0.0.2"), time.Now()); found {
+		t.Error("停用的条目不应命中")
+	}
+}
+
+func TestIPSetExpiry(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	set, _ := newIPSet([]IPEntry{
+		{IP: "10.0.0.0/8", ExpiresAt: &past},
+		{IP: "10.1.0.0/16"},
+		{IP: "10.2.0.0/16", ExpiresAt: &future},
+		{IP: "10.3.0.0-10.3.255.255", ExpiresAt: &past},
+		{IP: "10.3.0.0/16", ExpiresAt: &future}, // 与过期的地址段共用同一前缀
+	})
+
+	tests := []struct {
+		ip    string
+		at    time.Time
+		entry string
+	}{
+		{ip: "10.9.0.1", at: now, entry: ""},                              // 覆盖它的条目已过期
+		{ip: "10.1.0.1", at: now, entry: "10.1.0.0/16"},                   // 较短的前缀过期后继续查找更长的前缀
+		{ip: "10.2.0.1", at: now, entry: "10.2.0.0/16"},                   // 尚未过期
+		{ip: "10.2.0.1", at: future, entry: ""},                           // 到达过期时间即失效
+		{ip: "10.3.0.1", at: now, entry: "10.3.0.0/16"},                   // 同一节点跳过过期的条目
+		{ip: "10.9.0.1", at: past.Add(-time.Second), entry: "10.0.0.0/8"}, // 过期之前仍然生效
+	}
+	for _, tt := range tests {
+		entry, found := set.lookup(netip.MustParseAddr(tt.ip), tt.at)
+		if found != (tt.entry != "") || entry != tt.entry {
+			t.Errorf("%s@%s: 命中 %q(%v)，期望 %q", tt.ip, tt.at.Format(time.TimeOnly), entry, found, tt.entry)
+		}
+	}
+
+	// 删除后重新添加不带过期时间的条目
+	set.remove("10.0.0.0/8")
+	set.add(IPEntry{IP: "10.0.0.0/8"})
+	if entry, _ := set.lookup(netip.MustParseAddr("10.9.0.1"), now); entry != "10.0.0.0/8" {
+		t.Errorf("重新添加的永久条目应当生效，实际命中 %q", entry)
+	}
+}
+
+func TestSweepExpiredIPEntries(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	defer func() {
+		rulesSource = SourceDatabase
+		ipControlRules = IPControlRules{}
+		rebuildIPSets()
+	}()
+	rulesSource = SourceFile
+	ipControlRules = IPControlRules{
+		Whitelist: []IPEntry{{IP: "10.0.0.1", ExpiresAt: &past}, {IP: "10.0.0.2"}, {IP: "10.0.0.3", ExpiresAt: &past}},
+		Blacklist: []IPEntry{{IP: "10.0.0.4", ExpiresAt: &future}, {IP: "10.0.0.5", ExpiresAt: &past}},
+	}
+	rebuildIPSets()
+
+	// 清理之前过期的条目已经不再生效
+	if allowed, inWhitelist := IsAllowed("10.0.0.1"); !allowed || inWhitelist {
+		t.Error("过期的白名单条目不应生效")
+	}
+	if allowed, _ := IsAllowed("10.0.0.5"); !allowed {
+		t.Error("过期的黑名单条目不应生效")
+	}
+	if allowed, _ := IsAllowed("10.0.0.4"); allowed {
+		t.Error("未过期的黑名单条目应当生效")
+	}
+
+	if err := sweepExpiredIPEntries(now); err != nil {
+		t.Fatalf("清理失败: %v", err)
+	}
+	rules := GetIPControlRules()
+	if len(rules.Whitelist) != 1 || rules.Whitelist[0].IP != "10.0.0.2" || len(rules.Blacklist) != 1 || rules.Blacklist[0].IP != "10.0.0.4" {
+		t.Errorf("清理后名单 %+v，期望只剩未过期的条目", rules)
+	}
+	if len(whitelistIndex) != 1 || len(blacklistIndex) != 1 {
+		t.Errorf("清理后索引 %v %v 与名单不一致", whitelistIndex, blacklistIndex)
+	}
+}
+
+func TestSweepRecordsVersionOnce(t *testing.T) {
+	defer resetRules()
+	defer SetHistoryCollection(nil)
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name     string
+		modified int
+		recorded bool
+	}{
+		{name: "本实例删除了条目", modified: 1, recorded: true},
+		{name: "条目已被其他实例删除", modified: 0, recorded: false},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			resetRules()
+			SetMongoCollection(mt.Coll)
+			SetHistoryCollection(mt.Coll)
+			past := time.Now().Add(-time.Minute)
+			ipControlRules = IPControlRules{Blacklist: []IPEntry{{IP: "10.0.0.1", ExpiresAt: &past}}}
+			rebuildIPSets()
+
+			mt.AddMockResponses(
+				mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: tt.modified}),
+				mtest.CreateCursorResponse(0, "stone.history", mtest.FirstBatch,
+					versionDoc(t, RuleVersion{Version: 1, Snapshot: &RulesSnapshot{Blacklist: ipControlRules.Blacklist}})),
+				mtest.CreateSuccessResponse(),
+			)
+			if err := sweepExpiredIPEntries(time.Now()); err != nil {
+				mt.Fatalf("清理失败: %v", err)
+			}
+			// 名单写入之后的命令都来自记录版本
+			events := mt.GetAllStartedEvents()
+			if recorded := len(events) > 1; recorded != tt.recorded {
+				mt.Errorf("记录版本 %v，期望 %v", recorded, tt.recorded)
+			}
+			if len(GetIPControlRules().Blacklist) != 0 {
+				mt.Error("过期条目应从内存中删除")
+			}
+		})
+	}
+}
+
+// ipEntries 由IP字符串构建不带附加信息的名单条目
+func ipEntries(ips ...string) []IPEntry {
+	entries := make([]IPEntry, 0, len(ips))
+	for _, ip := range ips {
+		entries = append(entries, IPEntry{IP: ip})
+	}
+	return entries
+}
+
+// prefixStrings 将前缀转换为字符串，便于比较
+func prefixStrings(prefixes []netip.Prefix) []string {
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, prefix.String())
+	}
+	return result
+}
@@ -3,36 +3,48 @@
 package rules
 
 import (
+	"Stone/pkg/logging"
 	"context"
 	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"io/ioutil"
 	"net/http"
-	"regexp"
-	"strings"
+	"net/netip"
 	"sync"
+	"time"
 )
 
 type Pattern struct {
-	Name   string `bson:"name" json:"name"`
-	Regex  string `bson:"regex" json:"regex"`
-	Method string `bson:"method" json:"method"` // 添加HTTP请求方法
+	Name     string   `bson:"name" json:"name" yaml:"name"`
+	Operator string   `bson:"operator,omitempty" json:"operator,omitempty" yaml:"operator,omitempty"` // 运算符：regex（默认）、sqli 或 xss
+	Regex    string   `bson:"regex" json:"regex" yaml:"regex,omitempty"`
+	Method   string   `bson:"method" json:"method" yaml:"method,omitempty"`                        // 添加HTTP请求方法
+	Targets  []string `bson:"targets,omitempty" json:"targets,omitempty" yaml:"targets,omitempty"` // 匹配位置，为空时匹配URL路径、包体和全部请求头
+	Action   string   `bson:"action,omitempty" json:"action,omitempty" yaml:"action,omitempty"`    // 命中后的动作：block（默认）或 log
+
+	// 异常评分：Score 大于0时直接使用，否则按 Severity 取默认分值，两者都未设置时按 critical 计分
+	Severity string `bson:"severity,omitempty" json:"severity,omitempty" yaml:"severity,omitempty"`
+	Score    int    `bson:"score,omitempty" json:"score,omitempty" yaml:"score,omitempty"`
+
+	// 匹配前依次对取值执行的转换，例如 ["url_decode_recursive", "normalize_path", "lowercase"]
+	Transforms []string `bson:"transforms,omitempty" json:"transforms,omitempty" yaml:"transforms,omitempty"`
+
+	Disabled bool `bson:"disabled,omitempty" json:"disabled,omitempty" yaml:"disabled,omitempty"` // 停用的规则保留但不参与检查
 }
 
 // InterceptionRules 用于存储拦截规则
 type InterceptionRules struct {
-	Rules []Pattern `bson:"rules" json:"rules"`
+	Rules []Pattern `bson:"rules" json:"rules" yaml:"rules"`
 }
 
 // IPControlRules 用于存储IP控制规则
 type IPControlRules struct {
-	Whitelist []string `mapstructure:"whitelist"`
-	Blacklist []string `mapstructure:"blacklist"`
+	Whitelist []IPEntry `mapstructure:"whitelist"`
+	Blacklist []IPEntry `mapstructure:"blacklist"`
 }
 
 type IPControlRule struct {
-	IP        string `bson:"ip" json:"ip"`
+	IPEntry   `bson:",inline"`
 	IsAllowed bool   `bson:"is_allowed" json:"-"`
 	Type      string `json:"type"` // 用于解析请求体中的类型
 }
@@ -40,7 +52,12 @@ type IPControlRule struct {
 var (
 	interceptionRules InterceptionRules
 	ipControlRules    IPControlRules
+	whitelistSet      = &ipSet{}
+	blacklistSet      = &ipSet{}
+	whitelistIndex    = map[string]int{} // 条目在白名单中的位置
+	blacklistIndex    = map[string]int{} // 条目在黑名单中的位置
 	rulesMutex        sync.RWMutex
+	writeMutex        sync.Mutex        // 串行化规则修改及其MongoDB写入，写入时不持有 rulesMutex，请求检查不被阻塞
 	mongoCollection   *mongo.Collection // 假设已初始化
 )
 
@@ -57,8 +74,16 @@ func LoadInterceptionRules(ctx context.Context) (*InterceptionRules, error) {
 		return nil, fmt.Errorf("从MongoDB读取拦截规则失败: %w", err)
 	}
 
+	// 预编译规则，无效的规则记录日志后跳过
+	ruleset, errs := compileRuleset(rules.Rules)
+	for _, err := range errs {
+		logging.LogError(fmt.Errorf("忽略拦截规则: %w", err))
+	}
+
 	rulesMutex.Lock()
 	interceptionRules = rules
+	activeRuleset.Store(ruleset)
+	bumpVersion()
 	rulesMutex.Unlock()
 
 	return &rules, nil
@@ -72,97 +97,229 @@ func LoadIPControlRules(ctx context.Context) (*IPControlRules, error) {
 		return nil, fmt.Errorf("从MongoDB读取IP控制规则失败: %w", err)
 	}
 
+	// 停机期间过期的条目在查找时跳过，由清理任务从MongoDB中删除
 	rulesMutex.Lock()
 	ipControlRules = rules
+	rebuildIPSets()
 	rulesMutex.Unlock()
 
 	return &rules, nil
 }
 
-// CheckRequest 检查请求的URL、包体和头部
-func CheckRequest(req *http.Request) bool {
-	rulesMutex.RLock()
-	defer rulesMutex.RUnlock()
-
-	// 读取请求体
-	var body []byte
-	if req.Body != nil {
-		var err error
-		body, err = ioutil.ReadAll(req.Body)
-		if err != nil {
-			return false
-		}
-		req.Body.Close() // 关闭后重新设置Body，以便后续使用
-		req.Body = ioutil.NopCloser(strings.NewReader(string(body)))
-	}
+// CheckRequest 检查请求的URL、包体和头部，返回是否拦截及命中的规则
+// 只读取检测窗口内的请求体，其余部分在转发时以流的方式透传
+func CheckRequest(req *http.Request) Result {
+	result, _ := activeRuleset.Load().checkRequest(req, currentOptions())
+	return result
+}
 
-	// 检查规则
-	for _, pattern := range interceptionRules.Rules {
-		// 检查HTTP方法
-		if pattern.Method != "" && pattern.Method != req.Method {
-			continue
-		}
+// CheckRequestWith 使用指定的规则集检查请求，用于站点路由选择了部分规则的情况
+func CheckRequestWith(req *http.Request, ruleset *Ruleset) Result {
+	result, _ := ruleset.checkRequest(req, currentOptions())
+	return result
+}
 
-		// 检查URL
-		matched, err := regexp.MatchString(pattern.Regex, req.URL.Path)
-		if err != nil {
-			continue // 如果正则表达式有问题，跳过此规则
-		}
-		if matched {
-			return false
-		}
+// checkRequest 读取检测窗口内的请求体后检查请求，同时返回实际检查的包体
+func (rs *Ruleset) checkRequest(req *http.Request, opts CheckOptions) (Result, []byte) {
+	limit := BodySizeLimitFor(req.Host, req.URL.Path)
 
-		// 检查包体
-		if len(body) > 0 {
-			matched, err = regexp.MatchString(pattern.Regex, string(body))
-			if err != nil {
-				continue // 如果正则表达式有问题，跳过此规则
-			}
-			if matched {
-				return false
-			}
+	// Content-Length 已超过上限时不再读取包体
+	if limit.MaxBodySize > 0 && req.ContentLength > limit.MaxBodySize {
+		if limit.Action == OversizeBlock {
+			return Result{Blocked: true, Oversize: true}, nil
 		}
+		result := rs.Check(req, nil, opts)
+		result.PartiallyInspected = true
+		return result, nil
+	}
 
-		// 检查头部
-		for _, values := range req.Header {
-			for _, value := range values {
-				matched, err = regexp.MatchString(pattern.Regex, value)
-				if err != nil {
-					continue // 如果正则表达式有问题，跳过此规则
-				}
-				if matched {
-					return false
-				}
-			}
+	// 读取检测窗口内的请求体
+	var body []byte
+	var partial bool
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, req.Body, partial, err = inspectBody(req.Body, limit)
+		if err != nil {
+			return Result{Blocked: true}, nil
 		}
 	}
 
-	return true
+	result := rs.Check(req, body, opts)
+	result.PartiallyInspected = partial
+	result.InspectedBytes = len(body)
+	return result, body
 }
 
 // IsAllowed 检查IP是否被允许
 func IsAllowed(ip string) (allowed bool, inWhitelist bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return true, false
+	}
+
+	now := time.Now()
 	rulesMutex.RLock()
 	defer rulesMutex.RUnlock()
 
 	// 检查黑名单
-	for _, blockedIP := range ipControlRules.Blacklist {
-		if blockedIP == ip {
-			return false, false
-		}
+	if _, found := blacklistSet.lookup(addr, now); found {
+		return false, false
 	}
 
 	// 检查白名单
-	for _, allowedIP := range ipControlRules.Whitelist {
-		if allowedIP == ip {
-			return true, true
-		}
+	if _, found := whitelistSet.lookup(addr, now); found {
+		return true, true
 	}
 
 	// 如果不在白名单或黑名单中，返回中性结果
 	return true, false
 }
 
+// rebuildIPSets 根据当前名单重建索引和前缀树，用于整体加载名单，调用方需持有写锁
+func rebuildIPSets() {
+	bumpVersion()
+	var errs []error
+	whitelistSet, errs = newIPSet(ipList(ipControlRules.Whitelist))
+	for _, err := range errs {
+		logging.LogError(fmt.Errorf("忽略白名单条目: %w", err))
+	}
+	blacklistSet, errs = newIPSet(ipList(ipControlRules.Blacklist))
+	for _, err := range errs {
+		logging.LogError(fmt.Errorf("忽略黑名单条目: %w", err))
+	}
+	whitelistIndex = indexIPList(ipControlRules.Whitelist)
+	blacklistIndex = indexIPList(ipControlRules.Blacklist)
+}
+
+// setIPList 用已经构建好前缀树的条目替换整个白名单或黑名单，调用方需持有写锁
+func setIPList(allowed bool, entries []IPEntry, set *ipSet) {
+	if allowed {
+		ipControlRules.Whitelist, whitelistIndex, whitelistSet = entries, indexIPList(entries), set
+	} else {
+		ipControlRules.Blacklist, blacklistIndex, blacklistSet = entries, indexIPList(entries), set
+	}
+}
+
+// indexIPList 建立条目到其在名单中位置的索引
+func indexIPList(entries []IPEntry) map[string]int {
+	index := make(map[string]int, len(entries))
+	for i, entry := range entries {
+		index[entry.IP] = i
+	}
+	return index
+}
+
+// ipListState 返回白名单或黑名单的条目、索引和前缀树，调用方需持有锁
+func ipListState(allowed bool) (*[]IPEntry, map[string]int, *ipSet) {
+	if allowed {
+		return &ipControlRules.Whitelist, whitelistIndex, whitelistSet
+	}
+	return &ipControlRules.Blacklist, blacklistIndex, blacklistSet
+}
+
+// findIPEntry 按规范化后的条目查找名单中的条目，调用方需持有锁
+func findIPEntry(allowed bool, ip string) (IPEntry, bool) {
+	list, index, _ := ipListState(allowed)
+	if i, found := index[ip]; found {
+		return (*list)[i], true
+	}
+	return IPEntry{}, false
+}
+
+// putIPEntry 添加条目，条目已存在时替换，只更新前缀树中该条目自身的前缀，调用方需持有写锁
+func putIPEntry(allowed bool, entry IPEntry) {
+	list, index, set := ipListState(allowed)
+	if i, found := index[entry.IP]; found {
+		if !(*list)[i].Disabled {
+			set.remove(entry.IP)
+		}
+		(*list)[i] = entry
+	} else {
+		index[entry.IP] = len(*list)
+		*list = append(*list, entry)
+	}
+	if !entry.Disabled {
+		set.add(entry)
+	}
+}
+
+// removeIPEntry 删除条目并返回被删除的条目，名单最后一个条目移到被删除的位置，调用方需持有写锁
+func removeIPEntry(allowed bool, ip string) (IPEntry, bool) {
+	list, index, set := ipListState(allowed)
+	i, found := index[ip]
+	if !found {
+		return IPEntry{}, false
+	}
+	removed := (*list)[i]
+	last := len(*list) - 1
+	(*list)[i] = (*list)[last]
+	index[(*list)[i].IP] = i
+	*list = (*list)[:last]
+	delete(index, ip)
+	if !removed.Disabled {
+		set.remove(ip)
+	}
+	return removed, true
+}
+
+// ipEntryChange MongoDB中名单的一个条目的修改，Entry 为nil时表示删除
+type ipEntryChange struct {
+	List  string // whitelist 或 blacklist
+	IP    string
+	Entry *IPEntry
+}
+
+// saveIPEntryChanges 只修改MongoDB名单中的指定条目，不重写整个名单，调用方需持有锁
+// 修改后的条目移到名单末尾，旧版本以字符串保存的同一条目一并替换
+func saveIPEntryChanges(changes ...ipEntryChange) error {
+	_, err := updateIPEntries(changes)
+	return err
+}
+
+// updateIPEntries 按条目修改MongoDB中的名单，返回文档是否被本次写入修改
+func updateIPEntries(changes []ipEntryChange) (bool, error) {
+	set := bson.M{}
+	for _, change := range changes {
+		kept := bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$" + change.List, bson.A{}}},
+			"cond": bson.M{"$and": bson.A{
+				bson.M{"$ne": bson.A{"$$this", change.IP}},
+				bson.M{"$ne": bson.A{"$$this.ip", change.IP}},
+			}},
+		}}
+		if change.Entry == nil {
+			set[change.List] = kept
+		} else {
+			// $literal 避免备注等字段中以 "$" 开头的内容被当作字段路径
+			set[change.List] = bson.M{"$concatArrays": bson.A{kept, bson.A{bson.M{"$literal": change.Entry}}}}
+		}
+	}
+	result, err := mongoCollection.UpdateOne(
+		context.Background(),
+		bson.M{"type": "ip_control"},
+		mongo.Pipeline{{{Key: "$set", Value: set}}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount > 0, nil
+}
+
+// saveIPControlRules 将整个白名单和黑名单写回MongoDB，写入期间传入的名单不能被修改
+func saveIPControlRules(whitelist, blacklist []IPEntry) error {
+	_, err := mongoCollection.UpdateOne(
+		context.Background(),
+		bson.M{"type": "ip_control"},
+		bson.M{
+			"$set": bson.M{
+				"whitelist": whitelist,
+				"blacklist": blacklist,
+			},
+		},
+	)
+	return err
+}
+
 // GetInterceptionRules 获取当前拦截规则
 func GetInterceptionRules() InterceptionRules {
 	rulesMutex.RLock()
@@ -170,96 +327,143 @@ func GetInterceptionRules() InterceptionRules {
 	return interceptionRules
 }
 
-// GetIPControlRules 获取当前IP控制规则
+// GetIPControlRules 获取当前IP控制规则的副本，名单会被原地修改，不能直接返回
 func GetIPControlRules() IPControlRules {
 	rulesMutex.RLock()
 	defer rulesMutex.RUnlock()
-	return ipControlRules
+	return IPControlRules{
+		Whitelist: append([]IPEntry{}, ipControlRules.Whitelist...),
+		Blacklist: append([]IPEntry{}, ipControlRules.Blacklist...),
+	}
 }
 
-// GetIPRule 获取特定IP的规则
+// GetIPRule 获取特定IP的规则，参数可以是名单中的条目，也可以是被某个条目覆盖的地址
 func GetIPRule(ip string) (IPControlRule, bool) {
 	rulesMutex.RLock()
 	defer rulesMutex.RUnlock()
 
-	for _, allowedIP := range ipControlRules.Whitelist {
-		if allowedIP == ip {
-			return IPControlRule{IP: ip, IsAllowed: true}, true
+	if entry, _, err := ParseIPEntry(ip); err == nil {
+		if item, found := findIPEntry(true, entry); found {
+			return IPControlRule{IPEntry: item, IsAllowed: true, Type: "whitelist"}, true
 		}
-	}
-	for _, blockedIP := range ipControlRules.Blacklist {
-		if blockedIP == ip {
-			return IPControlRule{IP: ip, IsAllowed: false}, true
+		if item, found := findIPEntry(false, entry); found {
+			return IPControlRule{IPEntry: item, IsAllowed: false, Type: "blacklist"}, true
 		}
 	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return IPControlRule{}, false
+	}
+	now := time.Now()
+	if entry, found := whitelistSet.lookup(addr, now); found {
+		item, _ := findIPEntry(true, entry)
+		return IPControlRule{IPEntry: item, IsAllowed: true, Type: "whitelist"}, true
+	}
+	if entry, found := blacklistSet.lookup(addr, now); found {
+		item, _ := findIPEntry(false, entry)
+		return IPControlRule{IPEntry: item, IsAllowed: false, Type: "blacklist"}, true
+	}
 	return IPControlRule{}, false
 }
 
-// AddIPRule 添加新的IP规则
-func AddIPRule(rule IPControlRule) error {
-	rulesMutex.Lock()
-	defer rulesMutex.Unlock()
+// ValidateIPEntry 校验IP规则条目格式
+func ValidateIPEntry(entry string) error {
+	_, _, err := ParseIPEntry(entry)
+	return err
+}
 
+// AddIPRule 添加新的IP规则，条目已存在时更新其备注和过期时间
+func AddIPRule(rule IPControlRule) error {
 	// 根据类型设置IsAllowed
 	if rule.Type == "whitelist" {
 		rule.IsAllowed = true
 	} else if rule.Type == "blacklist" {
 		rule.IsAllowed = false
 	} else {
-		return fmt.Errorf("无效的IP规则类型")
+		return fmt.Errorf("%w: 无效的IP规则类型", ErrInvalidIPEntry)
 	}
 
-	if rule.IsAllowed {
-		ipControlRules.Whitelist = append(ipControlRules.Whitelist, rule.IP)
-	} else {
-		ipControlRules.Blacklist = append(ipControlRules.Blacklist, rule.IP)
+	entry, _, err := ParseIPEntry(rule.IP)
+	if err != nil {
+		return err
 	}
+	now := time.Now()
+	if rule.Expired(now) {
+		return fmt.Errorf("%w: 过期时间已过", ErrInvalidIPEntry)
+	}
+	rule.IP = entry
+	rule.CreatedAt = &now
+	author := rule.CreatedBy
 
-	// 更新MongoDB中的IP控制规则
-	_, err := mongoCollection.UpdateOne(
-		context.Background(),
-		bson.M{"type": "ip_control"},
-		bson.M{
-			"$set": bson.M{
-				"whitelist": ipControlRules.Whitelist,
-				"blacklist": ipControlRules.Blacklist,
-			},
-		},
-	)
-	return err
-}
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
-// DeleteIPRule 删除特定IP的规则
-func DeleteIPRule(ip string) error {
 	rulesMutex.Lock()
-	defer rulesMutex.Unlock()
+	if err := checkWritable(); err != nil {
+		rulesMutex.Unlock()
+		return err
+	}
+	// 保留条目最初的创建者和创建时间，旧版本的条目没有这些信息时记为本次添加
+	existing, found := findIPEntry(rule.IsAllowed, entry)
+	if found && existing.CreatedAt != nil {
+		rule.CreatedBy, rule.CreatedAt = existing.CreatedBy, existing.CreatedAt
+	}
+	putIPEntry(rule.IsAllowed, rule.IPEntry)
+	bumpVersion()
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的IP控制规则
+	if err := saveIPEntryChanges(ipEntryChange{List: rule.Type, IP: entry, Entry: &rule.IPEntry}); err != nil {
+		return err
+	}
+	var diff VersionDiff
+	if found {
+		diff.ipList(rule.Type).Changed = []IPEntry{rule.IPEntry}
+	} else {
+		diff.ipList(rule.Type).Added = []IPEntry{rule.IPEntry}
+	}
+	recordVersion(author, "add "+rule.Type+" "+entry, diff, nil)
+	return nil
+}
+
+// DeleteIPRule 删除特定IP的规则，author 记录在规则版本历史中
+func DeleteIPRule(ip, author string) error {
+	if entry, _, err := ParseIPEntry(ip); err == nil {
+		ip = entry
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil {
+		rulesMutex.Unlock()
+		return err
+	}
 	// 从白名单或黑名单中删除IP
-	for i, allowedIP := range ipControlRules.Whitelist {
-		if allowedIP == ip {
-			ipControlRules.Whitelist = append(ipControlRules.Whitelist[:i], ipControlRules.Whitelist[i+1:]...)
-			break
+	var changes []ipEntryChange
+	var diff VersionDiff
+	for _, listType := range []string{"whitelist", "blacklist"} {
+		if removed, found := removeIPEntry(listType == "whitelist", ip); found {
+			changes = append(changes, ipEntryChange{List: listType, IP: ip})
+			diff.ipList(listType).Removed = []IPEntry{removed}
 		}
 	}
-	for i, blockedIP := range ipControlRules.Blacklist {
-		if blockedIP == ip {
-			ipControlRules.Blacklist = append(ipControlRules.Blacklist[:i], ipControlRules.Blacklist[i+1:]...)
-			break
-		}
+	if len(changes) > 0 {
+		bumpVersion()
+	}
+	rulesMutex.Unlock()
+	if len(changes) == 0 {
+		return nil
 	}
 
-	// 更新MongoDB中的IP控制规则
-	_, err := mongoCollection.UpdateOne(
-		context.Background(),
-		bson.M{"type": "ip_control"},
-		bson.M{
-			"$set": bson.M{
-				"whitelist": ipControlRules.Whitelist,
-				"blacklist": ipControlRules.Blacklist,
-			},
-		},
-	)
-	return err
+	// 释放锁后更新MongoDB中的IP控制规则
+	if err := saveIPEntryChanges(changes...); err != nil {
+		return err
+	}
+	recordVersion(author, "delete ip "+ip, diff, nil)
+	return nil
 }
 
 // GetInterceptionRule 获取特定名称的规则
@@ -295,45 +499,78 @@ func GetInterceptionRulesWithPagination(page, pageSize int) ([]Pattern, int) {
 	return interceptionRules.Rules[startIndex:endIndex], totalCount
 }
 
-// AddInterceptionRule 添加新的拦截规则
-func AddInterceptionRule(rule Pattern) error {
-	rulesMutex.Lock()
-	defer rulesMutex.Unlock()
+// AddInterceptionRule 添加新的拦截规则，规则名称不能与已有规则重复，author 记录在规则版本历史中
+func AddInterceptionRule(rule Pattern, author string) error {
+	if err := ValidatePattern(rule); err != nil {
+		return err
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil {
+		rulesMutex.Unlock()
+		return err
+	}
+	for _, existing := range interceptionRules.Rules {
+		if existing.Name == rule.Name {
+			rulesMutex.Unlock()
+			return fmt.Errorf("%w: %q", ErrRuleExists, rule.Name)
+		}
+	}
 	interceptionRules.Rules = append(interceptionRules.Rules, rule)
+	swapRuleset()
+	updated := interceptionRules.Rules
+	rulesMutex.Unlock()
 
-	// 更新MongoDB中的拦截规则
-	_, err := mongoCollection.UpdateOne(
-		context.Background(),
-		bson.M{"type": "interception"},
-		bson.M{
-			"$set": bson.M{
-				"rules": interceptionRules.Rules,
-			},
-		},
-	)
-	return err
+	// 释放锁后更新MongoDB中的拦截规则
+	if err := saveInterceptionRules(updated); err != nil {
+		return err
+	}
+	recordVersion(author, "add interception rule "+rule.Name, VersionDiff{Interception: RulesDiff{Added: []Pattern{rule}}}, ruleOrder(updated))
+	return nil
 }
 
-// DeleteInterceptionRule 删除特定名称的规则
-func DeleteInterceptionRule(name string) error {
-	rulesMutex.Lock()
-	defer rulesMutex.Unlock()
+// DeleteInterceptionRule 删除特定名称的规则，author 记录在规则版本历史中
+func DeleteInterceptionRule(name, author string) error {
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
 
+	rulesMutex.Lock()
+	if err := checkWritable(); err != nil {
+		rulesMutex.Unlock()
+		return err
+	}
+	var diff VersionDiff
 	for i, rule := range interceptionRules.Rules {
 		if rule.Name == name {
-			interceptionRules.Rules = append(interceptionRules.Rules[:i], interceptionRules.Rules[i+1:]...)
+			// 替换副本，已经通过 GetInterceptionRules 取得规则的调用方不受影响
+			interceptionRules.Rules = append(interceptionRules.Rules[:i:i], interceptionRules.Rules[i+1:]...)
+			diff.Interception.Removed = []Pattern{rule}
 			break
 		}
 	}
+	swapRuleset()
+	updated := interceptionRules.Rules
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的拦截规则
+	if err := saveInterceptionRules(updated); err != nil {
+		return err
+	}
+	recordVersion(author, "delete interception rule "+name, diff, ruleOrder(updated))
+	return nil
+}
 
-	// 更新MongoDB中的拦截规则
+// saveInterceptionRules 将拦截规则写回MongoDB，写入期间传入的规则不能被修改
+func saveInterceptionRules(rules []Pattern) error {
 	_, err := mongoCollection.UpdateOne(
 		context.Background(),
 		bson.M{"type": "interception"},
 		bson.M{
 			"$set": bson.M{
-				"rules": interceptionRules.Rules,
+				"rules": rules,
 			},
 		},
 	)
@@ -0,0 +1,134 @@
+// pkg/rules/sandbox.go
+
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrInvalidTestRequest 沙盒中的原始HTTP请求无法解析
+var ErrInvalidTestRequest = errors.New("无效的测试请求")
+
+// TestResult 沙盒中一个请求的检查结果
+type TestResult struct {
+	Method   string  `json:"method"`
+	URL      string  `json:"url"`
+	Blocked  bool    `json:"blocked"`            // 按当前防火墙模式和异常评分阈值是否会被拦截
+	Oversize bool    `json:"oversize,omitempty"` // 请求体超过大小上限，会以413拒绝
+	Rule     string  `json:"rule,omitempty"`     // 拦截请求的规则
+	Score    int     `json:"score"`              // 累计的异常评分
+	Matches  []Match `json:"matches"`            // 全部规则在全部位置的命中，包括 log 动作的规则
+}
+
+// ParseRawRequests 解析一个或多个原始HTTP请求，多个请求之间可以用空行分隔
+// 请求体按 Content-Length 或分块编码读取，行尾可以是 CRLF 或 LF
+func ParseRawRequests(r io.Reader) ([]*http.Request, error) {
+	reader := bufio.NewReader(r)
+	var requests []*http.Request
+	for {
+		// 跳过请求之间的空行
+		for {
+			b, err := reader.Peek(1)
+			if err == io.EOF {
+				return requests, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if b[0] != '\r' && b[0] != '\n' && b[0] != ' ' && b[0] != '\t' {
+				break
+			}
+			reader.ReadByte()
+		}
+
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 第 %d 个请求: %v", ErrInvalidTestRequest, len(requests)+1, err)
+		}
+		// 读完请求体后才能解析下一个请求
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 第 %d 个请求的请求体: %v", ErrInvalidTestRequest, len(requests)+1, err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		requests = append(requests, req)
+	}
+}
+
+// CandidateRuleset 构建沙盒使用的规则集
+// patterns 不为空时只使用这些规则；rule 不为空时在当前规则的基础上加入该规则，同名规则被替换；都为空时使用当前生效的规则
+func CandidateRuleset(rule *Pattern, patterns []Pattern) (*Ruleset, error) {
+	if len(patterns) > 0 {
+		return CompileRuleset(patterns)
+	}
+	if rule == nil {
+		return activeRuleset.Load(), nil
+	}
+
+	// 测试的规则即使处于停用状态也参与检查
+	candidate := *rule
+	candidate.Disabled = false
+	if err := ValidatePattern(candidate); err != nil {
+		return nil, err
+	}
+
+	rulesMutex.RLock()
+	current := make([]Pattern, 0, len(interceptionRules.Rules)+1)
+	for _, existing := range interceptionRules.Rules {
+		if existing.Name != candidate.Name {
+			current = append(current, existing)
+		}
+	}
+	rulesMutex.RUnlock()
+
+	// 当前规则中的无效规则与正式检查时一样被跳过
+	ruleset, _ := compileRuleset(append(current, candidate))
+	return ruleset, nil
+}
+
+// TestRequests 用规则集检查请求，与 CheckRequest 使用相同的检测窗口和检查逻辑
+// 只返回检查结果，不记录日志、不更新指标，也不影响自动封禁
+func TestRequests(requests []*http.Request, ruleset *Ruleset) []TestResult {
+	opts := currentOptions()
+	results := make([]TestResult, 0, len(requests))
+	for _, req := range requests {
+		result, body := ruleset.checkRequest(req, opts)
+		test := TestResult{
+			Method:   req.Method,
+			URL:      req.URL.String(),
+			Blocked:  result.Blocked,
+			Oversize: result.Oversize,
+			Score:    result.Score,
+			Matches:  ruleset.matchAll(req, body),
+		}
+		if result.Match != nil {
+			test.Rule = result.Match.Rule
+		}
+		results = append(results, test)
+	}
+	return results
+}
+
+// matchAll 返回全部规则在请求中的全部命中，不区分规则的动作和防火墙模式
+func (rs *Ruleset) matchAll(req *http.Request, body []byte) []Match {
+	matches := []Match{}
+	rd := newRequestData(req, body)
+	if match := rs.bodyLimitMatch(rd); match != nil {
+		matches = append(matches, *match)
+	}
+	for _, rule := range rs.rules {
+		if rule.pattern.Method != "" && rule.pattern.Method != req.Method {
+			continue
+		}
+		rule.eachMatch(rd, func(m Match) bool {
+			matches = append(matches, m)
+			return true
+		})
+	}
+	return matches
+}
@@ -0,0 +1,145 @@
+package rules
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseRawRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		urls   []string
+		bodies []string
+		err    string // 期望错误信息中的内容
+	}{
+		{
+			name:   "CRLF单个请求",
+			input:  "GET /a?q=1 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			urls:   []string{"/a?q=1"},
+			bodies: []string{""},
+		},
+		{
+			name: "LF分隔的多个请求",
+			input: "POST /login HTTP/1.1\nHost: example.com\nContent-Length: 5\n\nu=abc\n\n\n" +
+				"GET /b HTTP/1.1\nHost: example.com\n\n",
+			urls:   []string{"/login", "/b"},
+			bodies: []string{"u=abc", ""},
+		},
+		{
+			name:   "分块编码的请求体",
+			input:  "POST /c HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n2\r\nde\r\n0\r\n\r\n",
+			urls:   []string{"/c"},
+			bodies: []string{"abcde"},
+		},
+		{name: "第二个请求无效", input: "GET / HTTP/1.1\nHost: a\n\nnot a request\n", err: "第 2 个请求"},
+		{name: "请求体不完整", input: "POST / HTTP/1.1\nHost: a\nContent-Length: 10\n\nabc", err: "第 1 个请求的请求体"},
+	}
+	for _, tt := range tests {
+		requests, err := ParseRawRequests(strings.NewReader(tt.input))
+		if tt.err != "" {
+			if !errors.Is(err, ErrInvalidTestRequest) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: 期望包含 %q 的 ErrInvalidTestRequest，结果 %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || len(requests) != len(tt.urls) {
+			t.Errorf("%s: 解析出 %d 个请求(%v)，期望 %d 个", tt.name, len(requests), err, len(tt.urls))
+			continue
+		}
+		for i, req := range requests {
+			body, _ := io.ReadAll(req.Body)
+			if req.URL.String() != tt.urls[i] || string(body) != tt.bodies[i] {
+				t.Errorf("%s: 第 %d 个请求 %s 请求体 %q，期望 %s %q", tt.name, i+1, req.URL, body, tt.urls[i], tt.bodies[i])
+			}
+		}
+	}
+}
+
+func TestCandidateRuleset(t *testing.T) {
+	defer resetRules()
+	rulesMutex.Lock()
+	interceptionRules = InterceptionRules{Rules: []Pattern{
+		{Name: "a", Regex: "alpha", Targets: []string{"args"}},
+		{Name: "b", Regex: "beta", Targets: []string{"args"}},
+	}}
+	swapRuleset()
+	rulesMutex.Unlock()
+
+	tests := []struct {
+		name     string
+		rule     *Pattern
+		patterns []Pattern
+		query    string
+		blocked  string // 拦截请求的规则，为空表示不拦截
+		invalid  bool
+	}{
+		{name: "当前规则", query: "q=alpha", blocked: "a"},
+		{name: "加入新规则", rule: &Pattern{Name: "c", Regex: "gamma", Targets: []string{"args"}}, query: "q=gamma", blocked: "c"},
+		{name: "替换同名规则", rule: &Pattern{Name: "a", Regex: "delta", Targets: []string{"args"}}, query: "q=alpha"},
+		{name: "停用的规则也参与测试", rule: &Pattern{Name: "c", Regex: "gamma", Targets: []string{"args"}, Disabled: true}, query: "q=gamma", blocked: "c"},
+		{name: "只使用给定的规则", patterns: []Pattern{{Name: "d", Regex: "delta", Targets: []string{"args"}}}, query: "q=beta"},
+		{name: "无效的规则", rule: &Pattern{Name: "c", Regex: "("}, invalid: true},
+	}
+	for _, tt := range tests {
+		ruleset, err := CandidateRuleset(tt.rule, tt.patterns)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%s: 无效的规则应当被拒绝", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: 构建规则集失败: %v", tt.name, err)
+			continue
+		}
+		requests, _ := ParseRawRequests(strings.NewReader("GET /?" + tt.query + " HTTP/1.1\nHost: a\n\n"))
+		result := TestRequests(requests, ruleset)[0]
+		if result.Blocked != (tt.blocked != "") || result.Rule != tt.blocked {
+			t.Errorf("%s: 拦截 %v 规则 %q，期望规则 %q", tt.name, result.Blocked, result.Rule, tt.blocked)
+		}
+	}
+	if current := GetInterceptionRules().Rules; len(current) != 2 || current[0].Regex != "alpha" {
+		t.Errorf("沙盒测试不应修改当前规则，实际 %+v", current)
+	}
+}
+
+func TestTestRequestsReportsAllMatches(t *testing.T) {
+	defer SetMode("")
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "观察", Regex: `probe`, Targets: []string{"args"}, Action: ActionLog},
+		{Name: "拦截", Regex: `attack`, Targets: []string{"args", "path"}},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+	requests, err := ParseRawRequests(strings.NewReader("GET /attack?a=probe&b=attack HTTP/1.1\nHost: a\n\n"))
+	if err != nil {
+		t.Fatalf("解析请求失败: %v", err)
+	}
+
+	tests := []struct {
+		mode    string
+		blocked bool
+	}{
+		{mode: "block", blocked: true},
+		{mode: ModeDetect},
+	}
+	for _, tt := range tests {
+		SetMode(tt.mode)
+		result := TestRequests(requests, ruleset)[0]
+		if result.Blocked != tt.blocked {
+			t.Errorf("%s: 拦截 %v，期望 %v", tt.mode, result.Blocked, tt.blocked)
+		}
+		// 全部命中与模式和动作无关
+		var locations []string
+		for _, match := range result.Matches {
+			locations = append(locations, match.Rule+"@"+match.Location)
+		}
+		if want := "观察@args:a,拦截@args:b,拦截@path"; strings.Join(locations, ",") != want {
+			t.Errorf("%s: 命中 %v，期望 %s", tt.mode, locations, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// pkg/rules/scoring.go
+
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 规则严重级别
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+	SeverityNotice   = "notice"
+)
+
+// severityScores 各严重级别对应的默认异常评分
+var severityScores = map[string]int{
+	SeverityCritical: 5,
+	SeverityError:    4,
+	SeverityWarning:  3,
+	SeverityNotice:   2,
+}
+
+// ruleScore 计算规则命中时计入的异常评分
+func ruleScore(pattern Pattern) (int, error) {
+	if pattern.Score < 0 {
+		return 0, fmt.Errorf("评分 %d 不能为负数", pattern.Score)
+	}
+
+	severity := strings.ToLower(pattern.Severity)
+	if severity == "" {
+		severity = SeverityCritical
+	}
+	score, ok := severityScores[severity]
+	if !ok {
+		return 0, fmt.Errorf("未知的严重级别 %q", pattern.Severity)
+	}
+
+	if pattern.Score > 0 {
+		return pattern.Score, nil
+	}
+	return score, nil
+}
@@ -0,0 +1,75 @@
+package rules
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRuleScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern Pattern
+		score   int
+		valid   bool
+	}{
+		{name: "未设置时按critical计分", pattern: Pattern{}, score: 5, valid: true},
+		{name: "critical", pattern: Pattern{Severity: SeverityCritical}, score: 5, valid: true},
+		{name: "error", pattern: Pattern{Severity: SeverityError}, score: 4, valid: true},
+		{name: "warning", pattern: Pattern{Severity: "WARNING"}, score: 3, valid: true},
+		{name: "notice", pattern: Pattern{Severity: SeverityNotice}, score: 2, valid: true},
+		{name: "Score覆盖严重级别", pattern: Pattern{Severity: SeverityNotice, Score: 7}, score: 7, valid: true},
+		{name: "只设置Score", pattern: Pattern{Score: 1}, score: 1, valid: true},
+		{name: "负数评分", pattern: Pattern{Score: -1}},
+		{name: "未知的严重级别", pattern: Pattern{Severity: "info", Score: 3}},
+	}
+	for _, tt := range tests {
+		score, err := ruleScore(tt.pattern)
+		if (err == nil) != tt.valid || score != tt.score {
+			t.Errorf("%s: 评分 %d(%v)，期望 %d 有效 %v", tt.name, score, err, tt.score, tt.valid)
+		}
+	}
+	if _, err := CompileRuleset([]Pattern{{Name: "bad", Regex: "x", Severity: "high"}}); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("严重级别无效的规则应编译失败，结果 %v", err)
+	}
+}
+
+func TestAnomalyThreshold(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{
+		{Name: "warning", Regex: `admin`, Targets: []string{"path"}, Severity: SeverityWarning},
+		{Name: "notice", Regex: `debug`, Targets: []string{"args"}, Severity: SeverityNotice},
+		{Name: "critical", Regex: `<script`, Targets: []string{"args"}},
+		{Name: "log", Regex: `admin`, Targets: []string{"path"}, Action: ActionLog},
+	})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		opts       CheckOptions
+		blocked    bool
+		score      int
+		matches    int
+		detections int
+	}{
+		{name: "传统模式第一条命中即拦截", target: "/admin?mode=debug", blocked: true, score: 3, matches: 1},
+		{name: "低于阈值", target: "/admin", opts: CheckOptions{AnomalyThreshold: 5}, score: 3, matches: 1, detections: 1},
+		{name: "等于阈值时拦截", target: "/admin?mode=debug", opts: CheckOptions{AnomalyThreshold: 5}, blocked: true, score: 5, matches: 2, detections: 1},
+		{name: "critical默认评分单独达到阈值", target: "/?q=<script>", opts: CheckOptions{AnomalyThreshold: 5}, blocked: true, score: 5, matches: 1},
+		{name: "超过阈值", target: "/admin?mode=debug&q=<script>", opts: CheckOptions{AnomalyThreshold: 6}, blocked: true, score: 10, matches: 3, detections: 1},
+		{name: "检测模式达到阈值只记录", target: "/admin?mode=debug", opts: CheckOptions{AnomalyThreshold: 5, DetectOnly: true}, score: 5, matches: 2, detections: 3},
+		{name: "没有命中", target: "/index.html", opts: CheckOptions{AnomalyThreshold: 5}},
+	}
+	for _, tt := range tests {
+		result := ruleset.Check(httptest.NewRequest("GET", tt.target, nil), nil, tt.opts)
+		if result.Blocked != tt.blocked || result.Score != tt.score || len(result.Matches) != tt.matches || len(result.Detections) != tt.detections {
+			t.Errorf("%s: 拦截 %v 评分 %d 命中 %d 记录 %d，期望 %v %d %d %d", tt.name, result.Blocked, result.Score, len(result.Matches), len(result.Detections), tt.blocked, tt.score, tt.matches, tt.detections)
+			continue
+		}
+		if tt.blocked && (result.Match == nil || result.Match.Rule != result.Matches[0].Rule) {
+			t.Errorf("%s: 拦截时应报告第一条计分的命中，结果 %+v", tt.name, result.Match)
+		}
+	}
+}
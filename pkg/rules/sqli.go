@@ -0,0 +1,318 @@
+// pkg/rules/sqli.go
+
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+// SQL注入检测：将输入按SQL词法切分为记号，折叠为记号类型组成的"指纹"，再与已知的注入指纹比对。
+// 输入可能被拼接在引号内或数字位置，因此分别以无引号、单引号、双引号三种上下文解析。
+//
+// 记号类型：
+//   s 字符串  1 数字/常量  n 标识符  k 关键字  U UNION  E 语句关键字
+//   f SQL函数  o 运算符  & 逻辑运算符  c 注释  v 变量  ; ( ) , 标点
+
+// sqlFingerprintLen 参与指纹比对的记号数量
+const sqlFingerprintLen = 8
+
+// sqlToken SQL词法记号
+type sqlToken struct {
+	kind byte
+	val  string
+}
+
+var sqlWordKinds = map[string]byte{
+	"and": '&', "or": '&', "xor": '&',
+	"union":  'U',
+	"select": 'E', "insert": 'E', "update": 'E', "delete": 'E', "drop": 'E', "create": 'E',
+	"alter": 'E', "truncate": 'E', "exec": 'E', "execute": 'E', "declare": 'E', "shutdown": 'E',
+	"not": 'o', "like": 'o', "rlike": 'o', "regexp": 'o', "in": 'o', "is": 'o', "between": 'o',
+	"div": 'o', "mod": 'o', "sounds": 'o',
+	"from": 'k', "where": 'k', "into": 'k', "values": 'k', "table": 'k', "order": 'k',
+	"group": 'k', "by": 'k', "having": 'k', "limit": 'k', "offset": 'k', "join": 'k',
+	"set": 'k', "case": 'k', "when": 'k', "then": 'k', "else": 'k', "end": 'k',
+	"waitfor": 'k', "delay": 'k', "procedure": 'k', "outfile": 'k', "dumpfile": 'k',
+	"database": 'k', "schema": 'k', "view": 'k', "index": 'k', "distinct": 'k', "top": 'k', "all": 'k',
+	"null": '1', "true": '1', "false": '1',
+}
+
+// sqlFunctions 常见于注入载荷的SQL函数，仅当后面紧跟 "(" 时识别为函数
+var sqlFunctions = map[string]bool{
+	"sleep": true, "benchmark": true, "pg_sleep": true, "load_file": true, "extractvalue": true,
+	"updatexml": true, "concat": true, "concat_ws": true, "group_concat": true, "char": true,
+	"chr": true, "ascii": true, "substring": true, "substr": true, "mid": true, "version": true,
+	"database": true, "user": true, "current_user": true, "system_user": true, "count": true,
+	"if": true, "ifnull": true, "convert": true, "cast": true, "hex": true, "unhex": true,
+	"length": true, "floor": true, "rand": true, "md5": true, "sha1": true, "exp": true,
+	"dbms_pipe.receive_message": true, "xp_cmdshell": true, "utl_inaddr.get_host_name": true,
+}
+
+// sqlTimeFunctions 出现在逻辑运算或拼接之后时几乎一定是注入的函数
+var sqlTimeFunctions = map[string]bool{
+	"sleep": true, "benchmark": true, "pg_sleep": true, "load_file": true, "extractvalue": true,
+	"updatexml": true, "dbms_pipe.receive_message": true, "xp_cmdshell": true,
+}
+
+// sqliFingerprints 各上下文下判定为注入的指纹
+var sqliFingerprints = map[byte][]*regexp.Regexp{
+	// 无引号上下文：数字型注入或完整语句
+	0: compileFingerprints(
+		`^1\)*&[1sv]o`, // 1 or 1=1
+		`^1\)*&[f(v]`,  // 1 and sleep(5)、1) or (1=1
+		`^1\)*&no`,     // 1 or name like ...
+		`^Eok`,         // select * from
+	),
+	// 引号上下文：输入闭合了字符串
+	'\'': quoteFingerprints,
+	'"':  quoteFingerprints,
+}
+
+var quoteFingerprints = compileFingerprints(
+	`^s\)*&[1sv(f]`, // ' or 1=1、' or 'a'='a、' and sleep(5)
+	`^s\)*&n[o(]`,   // ' or name like '%
+	`^so[1sfv(]`,    // '='、'+sleep(5)+'
+	`^s\)*c`,        // admin'--、admin')#
+	`^s\)*;`,        // '; drop table users
+	`^sk[k1n(]`,     // ' order by 1、' having 1=1、' into outfile
+)
+
+// sqliAnywhere 任意位置出现即判定为注入的指纹
+var sqliAnywhere = compileFingerprints(
+	`Uk?[E(]`,        // union select、union all select
+	`;E[kov1(]|;Enk`, // 堆叠查询：; drop table、; select *、; update users set
+)
+
+func compileFingerprints(patterns ...string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = regexp.MustCompile(pattern)
+	}
+	return compiled
+}
+
+// IsSQLi 判断输入是否为SQL注入载荷
+func IsSQLi(input string) bool {
+	for _, quote := range []byte{0, '\'', '"'} {
+		if quote != 0 && strings.IndexByte(input, quote) < 0 {
+			continue
+		}
+		if matchSQLiTokens(tokenizeSQL(input, quote), quote) {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlFingerprint 返回输入在指定上下文中的指纹，用于排查误报
+func sqlFingerprint(input string, quote byte) string {
+	return fingerprintOf(tokenizeSQL(input, quote))
+}
+
+func fingerprintOf(tokens []sqlToken) string {
+	if len(tokens) > sqlFingerprintLen {
+		tokens = tokens[:sqlFingerprintLen]
+	}
+	fp := make([]byte, len(tokens))
+	for i, token := range tokens {
+		fp[i] = token.kind
+	}
+	return string(fp)
+}
+
+func matchSQLiTokens(tokens []sqlToken, quote byte) bool {
+	fp := fingerprintOf(tokens)
+	for _, re := range sqliFingerprints[quote] {
+		if re.MatchString(fp) {
+			return true
+		}
+	}
+
+	full := make([]byte, len(tokens))
+	for i, token := range tokens {
+		full[i] = token.kind
+	}
+	for _, re := range sqliAnywhere {
+		if re.Match(full) {
+			return true
+		}
+	}
+
+	return hasTimeFunction(tokens) || hasWaitforDelay(tokens) || isDDL(tokens)
+}
+
+// hasTimeFunction 判断逻辑运算、拼接或语句之后是否调用了 sleep、benchmark 等函数
+func hasTimeFunction(tokens []sqlToken) bool {
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i].kind != 'f' || !sqlTimeFunctions[tokens[i].val] {
+			continue
+		}
+		if strings.IndexByte("&o,(EU", tokens[i-1].kind) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasWaitforDelay 判断是否包含 SQL Server 的 waitfor delay 延时语句
+func hasWaitforDelay(tokens []sqlToken) bool {
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].val == "waitfor" && tokens[i+1].val == "delay" && (tokens[i+2].kind == 's' || tokens[i+2].kind == '1') {
+			return true
+		}
+	}
+	return false
+}
+
+// isDDL 判断是否包含 drop table、insert into 这类完整的语句开头
+func isDDL(tokens []sqlToken) bool {
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].kind != 'E' || tokens[i+1].kind != 'k' || tokens[i+2].kind != 'n' {
+			continue
+		}
+		switch tokens[i].val + " " + tokens[i+1].val {
+		case "drop table", "drop database", "drop schema", "drop view", "truncate table",
+			"alter table", "create table", "insert into", "delete from":
+			return true
+		}
+	}
+	return false
+}
+
+// tokenizeSQL 将输入切分为SQL记号，quote 非0时视为输入从该引号包围的字符串内部开始
+func tokenizeSQL(input string, quote byte) []sqlToken {
+	var tokens []sqlToken
+	i := 0
+
+	if quote != 0 {
+		end := scanString(input, 0, quote)
+		tokens = append(tokens, sqlToken{kind: 's', val: input[:min(end, len(input))]})
+		if end >= len(input) {
+			return tokens
+		}
+		i = end + 1
+	}
+
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case c == '\'' || c == '"':
+			end := scanString(input, i+1, c)
+			tokens = append(tokens, sqlToken{kind: 's', val: input[i+1 : min(end, len(input))]})
+			i = end + 1
+		case c == '`':
+			end := strings.IndexByte(input[i+1:], '`')
+			if end < 0 {
+				end = len(input) - i - 1
+			}
+			tokens = append(tokens, sqlToken{kind: 'n', val: input[i+1 : i+1+end]})
+			i += end + 2
+		case c == '-' && i+1 < len(input) && input[i+1] == '-', c == '#':
+			tokens = append(tokens, sqlToken{kind: 'c'})
+			i = len(input)
+		case c == '/' && i+1 < len(input) && input[i+1] == '*':
+			if i+2 < len(input) && input[i+2] == '!' {
+				// MySQL可执行注释 /*!50000 ... */，内容按代码解析
+				i += 3
+				for i < len(input) && isDigit(input[i]) {
+					i++
+				}
+				continue
+			}
+			end := strings.Index(input[i+2:], "*/")
+			if end < 0 {
+				tokens = append(tokens, sqlToken{kind: 'c'})
+				i = len(input)
+				continue
+			}
+			i += end + 4
+		case c == '*' && i+1 < len(input) && input[i+1] == '/':
+			i += 2
+		case isDigit(c) || c == '.' && i+1 < len(input) && isDigit(input[i+1]):
+			start := i
+			for i < len(input) && (isWordByte(input[i]) || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: '1', val: input[start:i]})
+		case c == '@':
+			start := i
+			for i < len(input) && (input[i] == '@' || isWordByte(input[i]) || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 'v', val: strings.ToLower(input[start:i])})
+		case isWordByte(c) || c == '$':
+			start := i
+			for i < len(input) && (isWordByte(input[i]) || input[i] == '$' || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, classifyWord(strings.ToLower(input[start:i]), nextNonSpace(input, i)))
+		case c == '|' && i+1 < len(input) && input[i+1] == '|', c == '&' && i+1 < len(input) && input[i+1] == '&':
+			tokens = append(tokens, sqlToken{kind: '&', val: input[i : i+2]})
+			i += 2
+		case strings.IndexByte("=<>!+-*/%^~|&:", c) >= 0:
+			start := i
+			for i < len(input) && strings.IndexByte("=<>!|&", input[i]) >= 0 && i-start < 3 {
+				i++
+			}
+			if i == start {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: 'o', val: input[start:i]})
+		case c == ';' || c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, sqlToken{kind: c, val: string(c)})
+			i++
+		default:
+			i++
+		}
+	}
+
+	return tokens
+}
+
+// classifyWord 识别单词类型，next 为其后第一个非空白字符
+func classifyWord(word string, next byte) sqlToken {
+	if next == '(' && sqlFunctions[word] {
+		return sqlToken{kind: 'f', val: word}
+	}
+	if kind, ok := sqlWordKinds[word]; ok {
+		return sqlToken{kind: kind, val: word}
+	}
+	return sqlToken{kind: 'n', val: word}
+}
+
+// scanString 返回从 start 开始、以 quote 结尾的字符串的结束位置，支持反斜杠和双写引号转义
+func scanString(input string, start int, quote byte) int {
+	for i := start; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(input) && input[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(input)
+}
+
+func nextNonSpace(input string, i int) byte {
+	for ; i < len(input); i++ {
+		if c := input[i]; c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+			return c
+		}
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c >= 0x80
+}
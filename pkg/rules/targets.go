@@ -0,0 +1,227 @@
+// pkg/rules/targets.go
+
+package rules
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// 规则可以匹配的请求位置，集合类位置支持 "名称:键" 的形式只匹配其中一项，例如 "headers:Referer"、"json:user.name"
+const (
+	TargetPath      = "path"       // URL路径
+	TargetQuery     = "query"      // 原始查询字符串
+	TargetArgs      = "args"       // 查询参数
+	TargetHeaders   = "headers"    // 请求头
+	TargetCookies   = "cookies"    // Cookie
+	TargetBody      = "body"       // 原始包体
+	TargetForm      = "form"       // 表单字段
+	TargetJSON      = "json"       // JSON字段
+	TargetFiles     = "files"      // 上传文件名，键为表单字段名
+	TargetFileTypes = "file_types" // 上传文件的Content-Type，键为表单字段名
+	TargetUserAgent = "user_agent" // User-Agent
+	TargetHost      = "host"       // Host
+)
+
+// defaultTargets 未声明匹配位置的规则沿用原有行为：URL路径、包体和全部请求头
+var defaultTargets = []target{{kind: TargetPath}, {kind: TargetBody}, {kind: TargetHeaders}}
+
+// collectionTargets 支持按键选择的匹配位置
+var collectionTargets = map[string]bool{
+	TargetArgs:      true,
+	TargetHeaders:   true,
+	TargetCookies:   true,
+	TargetForm:      true,
+	TargetJSON:      true,
+	TargetFiles:     true,
+	TargetFileTypes: true,
+}
+
+// scalarTargets 不支持按键选择的匹配位置
+var scalarTargets = map[string]bool{
+	TargetPath:      true,
+	TargetQuery:     true,
+	TargetBody:      true,
+	TargetUserAgent: true,
+	TargetHost:      true,
+}
+
+// target 解析后的匹配位置
+type target struct {
+	kind string
+	key  string
+}
+
+// matchValue 从请求中取出的待匹配值及其位置
+type matchValue struct {
+	location string
+	value    string
+}
+
+// parseTarget 解析匹配位置声明
+func parseTarget(spec string) (target, error) {
+	kind, key, hasKey := strings.Cut(strings.TrimSpace(spec), ":")
+	kind = strings.ToLower(kind)
+	switch {
+	case scalarTargets[kind] && !hasKey:
+		return target{kind: kind}, nil
+	case collectionTargets[kind] && (!hasKey || key != ""):
+		if kind == TargetHeaders {
+			key = http.CanonicalHeaderKey(key)
+		}
+		return target{kind: kind, key: key}, nil
+	default:
+		return target{}, fmt.Errorf("未知的匹配位置 %q", spec)
+	}
+}
+
+// parseTargets 解析规则的全部匹配位置，为空时使用默认位置
+func parseTargets(specs []string) ([]target, error) {
+	if len(specs) == 0 {
+		return defaultTargets, nil
+	}
+	targets := make([]target, 0, len(specs))
+	for _, spec := range specs {
+		t, err := parseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// requestData 一次请求的待匹配数据，各集合在首次使用时解析
+type requestData struct {
+	req  *http.Request
+	body []byte
+
+	query   url.Values
+	cookies []*http.Cookie
+	parsed  *parsedBody
+
+	queryParsed, cookiesParsed bool
+}
+
+func newRequestData(req *http.Request, body []byte) *requestData {
+	return &requestData{req: req, body: body}
+}
+
+// structured 匹配位置的取值是否来自解析后的包体
+func (t target) structured() bool {
+	switch t.kind {
+	case TargetForm, TargetJSON, TargetFiles, TargetFileTypes:
+		return true
+	}
+	return false
+}
+
+// values 返回该匹配位置在请求中的全部取值
+func (t target) values(rd *requestData) []matchValue {
+	switch t.kind {
+	case TargetPath:
+		return []matchValue{{location: TargetPath, value: rd.req.URL.Path}}
+	case TargetQuery:
+		if rd.req.URL.RawQuery == "" {
+			return nil
+		}
+		return []matchValue{{location: TargetQuery, value: rd.req.URL.RawQuery}}
+	case TargetBody:
+		if len(rd.body) == 0 {
+			return nil
+		}
+		return []matchValue{{location: TargetBody, value: string(rd.body)}}
+	case TargetUserAgent:
+		return []matchValue{{location: TargetUserAgent, value: rd.req.UserAgent()}}
+	case TargetHost:
+		return []matchValue{{location: TargetHost, value: rd.req.Host}}
+	case TargetArgs:
+		return t.fromValues(rd.queryArgs())
+	case TargetHeaders:
+		return t.fromValues(url.Values(rd.req.Header))
+	case TargetForm:
+		return t.fromValues(rd.structuredBody().form)
+	case TargetCookies:
+		var values []matchValue
+		for _, cookie := range rd.requestCookies() {
+			if t.key == "" || t.key == cookie.Name {
+				values = append(values, matchValue{location: TargetCookies + ":" + cookie.Name, value: cookie.Value})
+			}
+		}
+		return values
+	case TargetJSON:
+		var values []matchValue
+		for _, field := range rd.structuredBody().json {
+			if t.key == "" || t.key == field.location {
+				values = append(values, matchValue{location: TargetJSON + ":" + field.location, value: field.value})
+			}
+		}
+		return values
+	case TargetFiles, TargetFileTypes:
+		var values []matchValue
+		for _, file := range rd.structuredBody().files {
+			if t.key != "" && t.key != file.field {
+				continue
+			}
+			value := file.filename
+			if t.kind == TargetFileTypes {
+				value = file.contentType
+			}
+			values = append(values, matchValue{location: t.kind + ":" + file.field, value: value})
+		}
+		return values
+	}
+	return nil
+}
+
+// fromValues 从键值集合中取出匹配的值，按键排序保证结果稳定
+func (t target) fromValues(collection url.Values) []matchValue {
+	if t.key != "" {
+		var values []matchValue
+		for _, value := range collection[t.key] {
+			values = append(values, matchValue{location: t.kind + ":" + t.key, value: value})
+		}
+		return values
+	}
+
+	keys := make([]string, 0, len(collection))
+	for key := range collection {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var values []matchValue
+	for _, key := range keys {
+		for _, value := range collection[key] {
+			values = append(values, matchValue{location: t.kind + ":" + key, value: value})
+		}
+	}
+	return values
+}
+
+func (rd *requestData) queryArgs() url.Values {
+	if !rd.queryParsed {
+		rd.query, _ = url.ParseQuery(rd.req.URL.RawQuery)
+		rd.queryParsed = true
+	}
+	return rd.query
+}
+
+func (rd *requestData) requestCookies() []*http.Cookie {
+	if !rd.cookiesParsed {
+		rd.cookies = rd.req.Cookies()
+		rd.cookiesParsed = true
+	}
+	return rd.cookies
+}
+
+// structuredBody 返回按Content-Type解析后的包体
+func (rd *requestData) structuredBody() *parsedBody {
+	if rd.parsed == nil {
+		rd.parsed = parseBody(rd.req.Header.Get("Content-Type"), rd.body, *bodyLimits.Load())
+	}
+	return rd.parsed
+}
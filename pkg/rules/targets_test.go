@@ -0,0 +1,102 @@
+package rules
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		spec  string
+		want  target
+		valid bool
+	}{
+		{spec: "path", want: target{kind: TargetPath}, valid: true},
+		{spec: " User_Agent ", want: target{kind: TargetUserAgent}, valid: true},
+		{spec: "args", want: target{kind: TargetArgs}, valid: true},
+		{spec: "args:id", want: target{kind: TargetArgs, key: "id"}, valid: true},
+		{spec: "headers:x-forwarded-for", want: target{kind: TargetHeaders, key: "X-Forwarded-For"}, valid: true}, // 请求头名称按规范形式保存
+		{spec: "cookies:SID", want: target{kind: TargetCookies, key: "SID"}, valid: true},                         // Cookie名称区分大小写
+		{spec: "json:user.name", want: target{kind: TargetJSON, key: "user.name"}, valid: true},
+		{spec: "form:a:b", want: target{kind: TargetForm, key: "a:b"}, valid: true}, // 只按第一个冒号拆分
+		{spec: "path:x"},   // 单值位置不支持键
+		{spec: "headers:"}, // 键不能为空
+		{spec: "referer"},  // 未知的位置
+		{spec: ""},
+	}
+	for _, tt := range tests {
+		got, err := parseTarget(tt.spec)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: 结果 %v，期望有效 %v", tt.spec, err, tt.valid)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: 解析为 %+v，期望 %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	// 未声明位置的规则沿用原有行为
+	if targets, err := parseTargets(nil); err != nil || !reflect.DeepEqual(targets, []target{{kind: TargetPath}, {kind: TargetBody}, {kind: TargetHeaders}}) {
+		t.Errorf("默认位置为 %+v(%v)，期望路径、包体和全部请求头", targets, err)
+	}
+	if targets, err := parseTargets([]string{"query", "headers:Referer"}); err != nil || len(targets) != 2 {
+		t.Errorf("解析结果 %+v(%v)，期望2个位置", targets, err)
+	}
+	if _, err := parseTargets([]string{"path", "unknown"}); err == nil {
+		t.Error("包含未知位置时应返回错误")
+	}
+	if _, err := CompileRuleset([]Pattern{{Name: "bad", Regex: "x", Targets: []string{"header"}}}); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("位置无效的规则应编译失败，结果 %v", err)
+	}
+}
+
+func TestTargetValues(t *testing.T) {
+	tests := []struct {
+		spec        string
+		target      string
+		contentType string
+		body        string
+		want        []string // "位置=取值"
+	}{
+		{spec: "path", target: "/a%20b/c?x=1", want: []string{"path=/a b/c"}},
+		{spec: "query", target: "/?b=2&a=1", want: []string{"query=b=2&a=1"}},
+		{spec: "query", target: "/"},
+		{spec: "args", target: "/?b=2&a=1&a=3", want: []string{"args:a=1", "args:a=3", "args:b=2"}},
+		{spec: "args:b", target: "/?b=2&a=1", want: []string{"args:b=2"}},
+		{spec: "headers:referer", target: "/", want: []string{"headers:Referer=https://example.com/"}},
+		{spec: "cookies:sid", target: "/", want: []string{"cookies:sid=abc"}},
+		{spec: "user_agent", target: "/", want: []string{"user_agent=stone-test"}},
+		{spec: "host", target: "http://www.example.com/", want: []string{"host=www.example.com"}},
+		{spec: "body", target: "/", body: "raw", want: []string{"body=raw"}},
+		{spec: "form", target: "/", contentType: "application/x-www-form-urlencoded", body: "q=1&p=x%27", want: []string{"form:p=x'", "form:q=1"}},
+		{spec: "form", target: "/", contentType: "text/plain", body: "q=1"}, // 只解析表单类型的包体
+		{spec: "json", target: "/", contentType: "application/json", body: `{"user":{"name":"a","tags":["x",1,true]}}`, want: []string{"json:user.name=a", "json:user.tags.0=x", "json:user.tags.1=1", "json:user.tags.2=true"}},
+		{spec: "json:user.name", target: "/", contentType: "application/vnd.api+json", body: `{"user":{"name":"a","id":1}}`, want: []string{"json:user.name=a"}},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", tt.target, nil)
+		req.Header.Set("Referer", "https://example.com/")
+		req.Header.Set("User-Agent", "stone-test")
+		req.Header.Set("Cookie", "sid=abc; theme=dark")
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		target, err := parseTarget(tt.spec)
+		if err != nil {
+			t.Fatalf("%q: 解析失败: %v", tt.spec, err)
+		}
+
+		var got []string
+		for _, v := range target.values(newRequestData(req, []byte(tt.body))) {
+			got = append(got, v.location+"="+v.value)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("%q %s: 取值 %q，期望 %q", tt.spec, tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// pkg/rules/transforms.go
+
+package rules
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"path"
+	"strings"
+	"unicode"
+)
+
+// 匹配前可以对取值做的转换，按规则中声明的顺序依次执行
+const (
+	TransformURLDecode          = "url_decode"           // URL解码，"+" 视为空格
+	TransformURLDecodeRecursive = "url_decode_recursive" // 反复URL解码直到结果不再变化，用于对抗多重编码
+	TransformLowercase          = "lowercase"            // 转换为小写
+	TransformNormalizePath      = "normalize_path"       // 规范化路径，消除 "./"、"../" 和重复的 "/"
+	TransformCompressWhitespace = "compress_whitespace"  // 将连续的空白字符压缩为一个空格
+	TransformHTMLEntityDecode   = "html_entity_decode"   // 解码HTML实体
+	TransformBase64Decode       = "base64_decode"        // Base64解码，解码失败时保留原值
+	TransformRemoveNulls        = "remove_nulls"         // 删除空字节
+)
+
+// maxRecursiveDecode 递归URL解码的最大轮数
+const maxRecursiveDecode = 8
+
+// transformFunc 转换函数
+type transformFunc func(string) string
+
+var transformFuncs = map[string]transformFunc{
+	TransformURLDecode:          urlDecode,
+	TransformURLDecodeRecursive: urlDecodeRecursive,
+	TransformLowercase:          strings.ToLower,
+	TransformNormalizePath:      normalizePath,
+	TransformCompressWhitespace: compressWhitespace,
+	TransformHTMLEntityDecode:   html.UnescapeString,
+	TransformBase64Decode:       base64Decode,
+	TransformRemoveNulls:        removeNulls,
+}
+
+// parseTransforms 解析规则声明的转换链
+func parseTransforms(names []string) ([]transformFunc, error) {
+	transforms := make([]transformFunc, 0, len(names))
+	for _, name := range names {
+		fn, ok := transformFuncs[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("未知的转换 %q", name)
+		}
+		transforms = append(transforms, fn)
+	}
+	return transforms, nil
+}
+
+// applyTransforms 依次执行转换链
+func applyTransforms(value string, transforms []transformFunc) string {
+	for _, fn := range transforms {
+		value = fn(value)
+	}
+	return value
+}
+
+// urlDecode 宽松的URL解码：无效的转义序列原样保留，不会因为格式错误而放弃解码
+func urlDecode(value string) string {
+	if !strings.ContainsAny(value, "%+") {
+		return value
+	}
+
+	var b strings.Builder
+	b.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '+':
+			b.WriteByte(' ')
+		case c == '%' && i+2 < len(value) && isHex(value[i+1]) && isHex(value[i+2]):
+			b.WriteByte(unhex(value[i+1])<<4 | unhex(value[i+2]))
+			i += 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func urlDecodeRecursive(value string) string {
+	for i := 0; i < maxRecursiveDecode; i++ {
+		decoded := urlDecode(value)
+		if decoded == value {
+			break
+		}
+		value = decoded
+	}
+	return value
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// normalizePath 规范化路径，同时将反斜杠视为路径分隔符，保留末尾的 "/"
+func normalizePath(value string) string {
+	if value == "" {
+		return value
+	}
+	value = strings.ReplaceAll(value, "\\", "/")
+	cleaned := path.Clean(value)
+	if strings.HasSuffix(value, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+func compressWhitespace(value string) string {
+	var b strings.Builder
+	b.Grow(len(value))
+	inSpace := false
+	for _, r := range value {
+		if unicode.IsSpace(r) {
+			if !inSpace {
+				b.WriteByte(' ')
+			}
+			inSpace = true
+			continue
+		}
+		inSpace = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func base64Decode(value string) string {
+	trimmed := strings.TrimSpace(value)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if decoded, err := encoding.DecodeString(trimmed); err == nil {
+			return string(decoded)
+		}
+	}
+	return value
+}
+
+func removeNulls(value string) string {
+	return strings.ReplaceAll(value, "\x00", "")
+}
@@ -0,0 +1,63 @@
+package rules
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		transforms []string
+		value      string
+		want       string
+	}{
+		{transforms: []string{TransformURLDecode}, value: "a%20b+c%zz%4", want: "a b c%zz%4"},
+		{transforms: []string{TransformURLDecode}, value: "%252e%252e", want: "%2e%2e"},
+		{transforms: []string{TransformURLDecodeRecursive}, value: "%252e%252e%252f", want: "../"},
+		{transforms: []string{TransformLowercase}, value: "SeLeCt", want: "select"},
+		{transforms: []string{TransformNormalizePath}, value: "/a/./b/../../etc//passwd", want: "/etc/passwd"},
+		{transforms: []string{TransformNormalizePath}, value: `\a\..\b\`, want: "/b/"},
+		{transforms: []string{TransformCompressWhitespace}, value: "union \t\n select", want: "union select"},
+		{transforms: []string{TransformHTMLEntityDecode}, value: "&lt;script&gt;&#97;", want: "<script>a"},
+		{transforms: []string{TransformBase64Decode}, value: "PHNjcmlwdD4=", want: "<script>"},
+		{transforms: []string{TransformBase64Decode}, value: "not base64!", want: "not base64!"},
+		{transforms: []string{TransformRemoveNulls}, value: "sel\x00ect", want: "select"},
+		{transforms: []string{"URL_Decode", " lowercase "}, value: "%53ELECT", want: "select"},
+		{transforms: []string{TransformLowercase, TransformURLDecode}, value: "%3C", want: "<"},
+	}
+	for _, tt := range tests {
+		fns, err := parseTransforms(tt.transforms)
+		if err != nil {
+			t.Errorf("%v: 解析转换失败: %v", tt.transforms, err)
+			continue
+		}
+		if got := applyTransforms(tt.value, fns); got != tt.want {
+			t.Errorf("%v(%q) = %q，期望 %q", tt.transforms, tt.value, got, tt.want)
+		}
+	}
+
+	if _, err := parseTransforms([]string{"rot13"}); err == nil {
+		t.Error("未知转换应当被拒绝")
+	}
+}
+
+func TestTransformsBeforeMatching(t *testing.T) {
+	ruleset, err := CompileRuleset([]Pattern{{
+		Name:       "路径遍历",
+		Regex:      `^/etc/passwd$`,
+		Targets:    []string{"args:file"},
+		Transforms: []string{TransformURLDecodeRecursive, TransformNormalizePath, TransformLowercase},
+	}})
+	if err != nil {
+		t.Fatalf("编译规则集失败: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/download?file=%252Fvar%252F..%252FETC%252Fpasswd", nil)
+	result := ruleset.Check(req, nil, CheckOptions{})
+	if !result.Blocked {
+		t.Fatal("多重编码的路径遍历应当在转换后命中")
+	}
+	if result.Match.Snippet != "/etc/passwd" {
+		t.Errorf("命中片段应取自转换后的值，实际 %q", result.Match.Snippet)
+	}
+}
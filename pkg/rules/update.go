@@ -0,0 +1,182 @@
+// pkg/rules/update.go
+
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrRuleExists 同名的拦截规则已存在
+	ErrRuleExists = errors.New("同名规则已存在")
+	// ErrRuleNotFound 要修改的规则不存在
+	ErrRuleNotFound = errors.New("规则不存在")
+	// ErrPreconditionFailed 规则在读取后已被其他人修改
+	ErrPreconditionFailed = errors.New("规则已被修改，请重新获取后再提交")
+)
+
+// ETag 根据规则内容计算实体标签，客户端修改规则时通过 If-Match 带回，避免覆盖他人的修改
+func ETag(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// matchETag 检查 If-Match 是否与当前内容一致，为空或 "*" 时不检查
+func matchETag(ifMatch string, v interface{}) bool {
+	if ifMatch == "" || ifMatch == "*" {
+		return true
+	}
+	current := ETag(v)
+	for _, tag := range strings.Split(ifMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateInterceptionRule 原子地替换指定名称的规则，新规则可以改用其他未被使用的名称
+// ifMatch 不为空时必须与当前规则的 ETag 一致，返回修改后的规则
+func UpdateInterceptionRule(name string, rule Pattern, ifMatch, author string) (Pattern, error) {
+	if rule.Name == "" {
+		rule.Name = name
+	}
+	if err := ValidatePattern(rule); err != nil {
+		return Pattern{}, err
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	rulesMutex.Lock()
+	current, err := findRuleForUpdate(name, rule.Name, ifMatch)
+	if err != nil {
+		rulesMutex.Unlock()
+		return Pattern{}, err
+	}
+	// 替换副本，已经通过 GetInterceptionRules 取得规则的调用方不受影响
+	updated := append([]Pattern{}, interceptionRules.Rules...)
+	updated[current] = rule
+	interceptionRules.Rules = updated
+	swapRuleset()
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的拦截规则
+	if err := saveInterceptionRules(updated); err != nil {
+		return Pattern{}, err
+	}
+	diff := VersionDiff{Interception: RulesDiff{Changed: []Pattern{rule}}}
+	if rule.Name != name {
+		diff.Interception = RulesDiff{Added: []Pattern{rule}, Removed: []Pattern{{Name: name}}}
+	}
+	recordVersion(author, "update interception rule "+name, diff, ruleOrder(updated))
+	return rule, nil
+}
+
+// findRuleForUpdate 返回要修改的规则的位置，新名称不能与其他规则重复，调用方需持有锁
+func findRuleForUpdate(name, newName, ifMatch string) (int, error) {
+	if err := checkWritable(); err != nil {
+		return 0, err
+	}
+	index := -1
+	for i, existing := range interceptionRules.Rules {
+		switch {
+		case existing.Name == name && index < 0:
+			index = i
+		case existing.Name == newName:
+			return 0, fmt.Errorf("%w: %q", ErrRuleExists, newName)
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrRuleNotFound, name)
+	}
+	if !matchETag(ifMatch, interceptionRules.Rules[index]) {
+		return 0, ErrPreconditionFailed
+	}
+	return index, nil
+}
+
+// UpdateIPRule 修改名单中已有条目的备注、过期时间和启用状态，Type 不同时将条目移到另一个名单
+// 条目本身不能修改，ifMatch 不为空时必须与当前条目的 ETag 一致，返回修改后的条目
+func UpdateIPRule(ip string, rule IPControlRule, ifMatch, author string) (IPControlRule, error) {
+	entry, _, err := ParseIPEntry(ip)
+	if err != nil {
+		return IPControlRule{}, err
+	}
+	if rule.IP != "" {
+		if updated, _, err := ParseIPEntry(rule.IP); err != nil || updated != entry {
+			return IPControlRule{}, fmt.Errorf("%w: 不能修改条目的IP，请删除后重新添加", ErrInvalidIPEntry)
+		}
+	}
+	if rule.Type != "" && rule.Type != "whitelist" && rule.Type != "blacklist" {
+		return IPControlRule{}, fmt.Errorf("%w: 无效的IP规则类型", ErrInvalidIPEntry)
+	}
+	if rule.Expired(time.Now()) {
+		return IPControlRule{}, fmt.Errorf("%w: 过期时间已过", ErrInvalidIPEntry)
+	}
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	rulesMutex.Lock()
+	current, err := findIPRuleForUpdate(entry, ifMatch)
+	if err != nil {
+		rulesMutex.Unlock()
+		return IPControlRule{}, err
+	}
+	if rule.Type == "" {
+		rule.Type = current.Type
+	}
+	rule.IsAllowed = rule.Type == "whitelist"
+	rule.IP = entry
+	rule.CreatedBy, rule.CreatedAt = current.CreatedBy, current.CreatedAt
+
+	changes := []ipEntryChange{{List: rule.Type, IP: entry, Entry: &rule.IPEntry}}
+	var diff VersionDiff
+	if rule.Type != current.Type {
+		removeIPEntry(current.IsAllowed, entry)
+		changes = append(changes, ipEntryChange{List: current.Type, IP: entry})
+		diff.ipList(current.Type).Removed = []IPEntry{current.IPEntry}
+		diff.ipList(rule.Type).Added = []IPEntry{rule.IPEntry}
+	} else {
+		diff.ipList(rule.Type).Changed = []IPEntry{rule.IPEntry}
+	}
+	putIPEntry(rule.IsAllowed, rule.IPEntry)
+	bumpVersion()
+	rulesMutex.Unlock()
+
+	// 释放锁后更新MongoDB中的IP控制规则
+	if err := saveIPEntryChanges(changes...); err != nil {
+		return IPControlRule{}, err
+	}
+	recordVersion(author, "update "+rule.Type+" "+entry, diff, nil)
+	return rule, nil
+}
+
+// findIPRuleForUpdate 查找要修改的名单条目并检查 ETag，调用方需持有锁
+func findIPRuleForUpdate(entry, ifMatch string) (IPControlRule, error) {
+	if err := checkWritable(); err != nil {
+		return IPControlRule{}, err
+	}
+	current := IPControlRule{Type: "blacklist"}
+	existing, found := findIPEntry(false, entry)
+	if !found {
+		current = IPControlRule{IsAllowed: true, Type: "whitelist"}
+		existing, found = findIPEntry(true, entry)
+	}
+	if !found {
+		return IPControlRule{}, fmt.Errorf("%w: %s", ErrRuleNotFound, entry)
+	}
+	current.IPEntry = existing
+	if !matchETag(ifMatch, current) {
+		return IPControlRule{}, ErrPreconditionFailed
+	}
+	return current, nil
+}
@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMatchETag(t *testing.T) {
+	rule := Pattern{Name: "a", Regex: "attack"}
+	tag := ETag(rule)
+	tests := []struct {
+		ifMatch string
+		match   bool
+	}{
+		{ifMatch: "", match: true},
+		{ifMatch: "*", match: true},
+		{ifMatch: tag, match: true},
+		{ifMatch: "W/" + tag, match: true},
+		{ifMatch: `"other", ` + tag, match: true},
+		{ifMatch: `"other"`},
+		{ifMatch: ETag(Pattern{Name: "a", Regex: "attack", Disabled: true})},
+	}
+	for _, tt := range tests {
+		if got := matchETag(tt.ifMatch, rule); got != tt.match {
+			t.Errorf("If-Match %q: 结果 %v，期望 %v", tt.ifMatch, got, tt.match)
+		}
+	}
+}
+
+func TestUpdateInterceptionRule(t *testing.T) {
+	defer resetRules()
+	original := Pattern{Name: "a", Regex: "attack"}
+
+	tests := []struct {
+		name    string
+		target  string
+		rule    Pattern
+		ifMatch string
+		err     error
+		want    []string // 修改后的规则顺序
+	}{
+		{name: "ETag一致", target: "a", rule: Pattern{Name: "a", Regex: "x"}, ifMatch: ETag(original), want: []string{"a", "b"}},
+		{name: "不带If-Match", target: "a", rule: Pattern{Name: "a", Regex: "x"}, want: []string{"a", "b"}},
+		{name: "改名后保持位置", target: "a", rule: Pattern{Name: "c", Regex: "x"}, ifMatch: ETag(original), want: []string{"c", "b"}},
+		{name: "读取后已被修改", target: "a", rule: Pattern{Name: "a", Regex: "x"}, ifMatch: ETag(Pattern{Name: "a", Regex: "old"}), err: ErrPreconditionFailed},
+		{name: "新名称与其他规则重复", target: "a", rule: Pattern{Name: "b", Regex: "x"}, err: ErrRuleExists},
+		{name: "规则不存在", target: "z", rule: Pattern{Regex: "x"}, err: ErrRuleNotFound},
+		{name: "无效的正则", target: "a", rule: Pattern{Regex: "("}, err: ErrInvalidRule},
+	}
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			SetMongoCollection(mt.Coll)
+			rulesMutex.Lock()
+			interceptionRules = InterceptionRules{Rules: []Pattern{original, {Name: "b", Regex: "b"}}}
+			swapRuleset()
+			rulesMutex.Unlock()
+			mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+			updated, err := UpdateInterceptionRule(tt.target, tt.rule, tt.ifMatch, "alice")
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					mt.Errorf("期望 %v，结果 %v", tt.err, err)
+				}
+				if rules := GetInterceptionRules().Rules; ETag(rules[0]) != ETag(original) {
+					mt.Errorf("修改失败时规则不应变化，实际 %+v", rules[0])
+				}
+				return
+			}
+			if err != nil {
+				mt.Fatalf("修改失败: %v", err)
+			}
+			if got := ruleOrder(GetInterceptionRules().Rules); len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				mt.Errorf("修改后的规则 %v，期望 %v", got, tt.want)
+			}
+			if current, _ := GetInterceptionRule(updated.Name); ETag(current) != ETag(updated) {
+				mt.Error("返回的规则应与生效的规则一致")
+			}
+		})
+	}
+}
+
+func TestUpdateIPRule(t *testing.T) {
+	defer resetRules()
+	original := IPEntry{IP: "10.0.0.1", Comment: "扫描器"}
+	current := IPControlRule{IPEntry: original, Type: "blacklist"}
+
+	tests := []struct {
+		name        string
+		ip          string
+		rule        IPControlRule
+		ifMatch     string
+		err         error
+		allowed     bool
+		inWhitelist bool
+	}{
+		{name: "修改备注", ip: "10.0.0.1", rule: IPControlRule{IPEntry: IPEntry{Comment: "误报"}}, ifMatch: ETag(current)},
+		{name: "移到白名单", ip: " 10.0.0.1 ", rule: IPControlRule{Type: "whitelist"}, ifMatch: ETag(current), allowed: true, inWhitelist: true},
+		{name: "停用条目", ip: "10.0.0.1", rule: IPControlRule{IPEntry: IPEntry{Disabled: true}}, allowed: true},
+		{name: "读取后已被修改", ip: "10.0.0.1", rule: IPControlRule{Type: "whitelist"}, ifMatch: ETag(IPControlRule{IPEntry: IPEntry{IP: "10.0.0.1"}, Type: "blacklist"}), err: ErrPreconditionFailed},
+		{name: "不能修改IP", ip: "10.0.0.1", rule: IPControlRule{IPEntry: IPEntry{IP: "10.0.0.2"}}, err: ErrInvalidIPEntry},
+		{name: "条目不存在", ip: "10.0.0.9", err: ErrRuleNotFound},
+	}
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			SetMongoCollection(mt.Coll)
+			rulesMutex.Lock()
+			ipControlRules = IPControlRules{Blacklist: []IPEntry{original}}
+			rebuildIPSets()
+			rulesMutex.Unlock()
+			mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+			_, err := UpdateIPRule(tt.ip, tt.rule, tt.ifMatch, "alice")
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					mt.Errorf("期望 %v，结果 %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				mt.Fatalf("修改失败: %v", err)
+			}
+			if allowed, inWhitelist := IsAllowed("10.0.0.1"); allowed != tt.allowed || inWhitelist != tt.inWhitelist {
+				mt.Errorf("allowed=%v inWhitelist=%v，期望 %v %v", allowed, inWhitelist, tt.allowed, tt.inWhitelist)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// pkg/rules/version.go
+
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RulesetVersion 当前生效规则的版本信息，用于确认多个实例的规则是否一致
+type RulesetVersion struct {
+	Version   uint64    `json:"version"` // 本实例启动以来规则被替换的次数
+	Hash      string    `json:"hash"`    // 拦截规则和IP控制规则内容的SHA-256摘要，内容相同的实例摘要相同
+	UpdatedAt time.Time `json:"updated_at"`
+	Source    string    `json:"source"`
+}
+
+var (
+	rulesVersion   uint64 // 由 rulesMutex 保护
+	rulesUpdatedAt time.Time
+
+	hashMutex         sync.Mutex
+	cachedHash        string
+	cachedHashVersion uint64
+)
+
+// bumpVersion 规则被替换后更新版本号，调用方需持有写锁
+func bumpVersion() {
+	rulesVersion++
+	rulesUpdatedAt = time.Now()
+}
+
+// CurrentVersion 返回当前规则的版本，摘要在版本变化后首次查询时计算
+func CurrentVersion() RulesetVersion {
+	rulesMutex.RLock()
+	defer rulesMutex.RUnlock()
+
+	hashMutex.Lock()
+	defer hashMutex.Unlock()
+	if cachedHash == "" || cachedHashVersion != rulesVersion {
+		// 名单中条目的顺序与添加和删除的先后有关，按条目排序后计算，内容相同的实例摘要相同
+		data, _ := json.Marshal(struct {
+			Interception []Pattern
+			IPControl    IPControlRules
+		}{interceptionRules.Rules, IPControlRules{
+			Whitelist: sortedIPEntries(ipControlRules.Whitelist),
+			Blacklist: sortedIPEntries(ipControlRules.Blacklist),
+		}})
+		sum := sha256.Sum256(data)
+		cachedHash, cachedHashVersion = hex.EncodeToString(sum[:]), rulesVersion
+	}
+
+	return RulesetVersion{
+		Version:   rulesVersion,
+		Hash:      cachedHash,
+		UpdatedAt: rulesUpdatedAt,
+		Source:    rulesSource,
+	}
+}
+
+// sortedIPEntries 返回按条目排序的名单副本
+func sortedIPEntries(entries []IPEntry) []IPEntry {
+	sorted := append([]IPEntry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].IP < sorted[j].IP })
+	return sorted
+}
@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCurrentVersion(t *testing.T) {
+	defer resetRules()
+
+	setLists := func(whitelist []IPEntry) RulesetVersion {
+		rulesMutex.Lock()
+		ipControlRules.Whitelist = whitelist
+		rebuildIPSets()
+		rulesMutex.Unlock()
+		return CurrentVersion()
+	}
+	first := setLists(ipEntries("10.0.0.1", "10.0.0.2"))
+	reordered := setLists(ipEntries("10.0.0.2", "10.0.0.1"))
+	changed := setLists(ipEntries("10.0.0.1", "10.0.0.3"))
+
+	if reordered.Version <= first.Version || changed.Version <= reordered.Version {
+		t.Errorf("每次替换规则后版本号应递增: %d %d %d", first.Version, reordered.Version, changed.Version)
+	}
+	if first.Hash != reordered.Hash {
+		t.Error("名单内容相同、顺序不同时摘要应当相同")
+	}
+	if changed.Hash == first.Hash {
+		t.Error("名单内容变化后摘要应当变化")
+	}
+	if again := CurrentVersion(); again != changed {
+		t.Errorf("规则未变化时版本信息应保持不变: %+v %+v", again, changed)
+	}
+}
+
+func TestReloadFromMongo(t *testing.T) {
+	defer resetRules()
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("重新加载替换当前规则", func(mt *mtest.T) {
+		SetMongoCollection(mt.Coll)
+		before := CurrentVersion()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, bson.D{
+				{Key: "type", Value: "interception"},
+				{Key: "rules", Value: bson.A{
+					bson.D{{Key: "name", Value: "有效"}, {Key: "regex", Value: "attack"}},
+					bson.D{{Key: "name", Value: "无效"}, {Key: "regex", Value: "("}},
+				}},
+			}),
+			mtest.CreateCursorResponse(0, "stone.rules", mtest.FirstBatch, bson.D{
+				{Key: "type", Value: "ip_control"},
+				{Key: "whitelist", Value: bson.A{"10.0.0.1", bson.D{{Key: "ip", Value: "10.1.0.0/16"}, {Key: "comment", Value: "办公网"}}}},
+				{Key: "blacklist", Value: bson.A{bson.D{{Key: "ip", Value: "192.168.0.1"}, {Key: "disabled", Value: true}}}},
+			}),
+		)
+		if _, err := LoadInterceptionRules(context.Background()); err != nil {
+			mt.Fatalf("重新加载拦截规则失败: %v", err)
+		}
+		if _, err := LoadIPControlRules(context.Background()); err != nil {
+			mt.Fatalf("重新加载IP控制规则失败: %v", err)
+		}
+
+		// 无效的规则被跳过，有效的规则生效
+		if ruleset := activeRuleset.Load(); len(ruleset.rules) != 1 {
+			mt.Errorf("应只编译有效的规则，实际 %d 条", len(ruleset.rules))
+		}
+		tests := []struct {
+			ip                   string
+			allowed, inWhitelist bool
+		}{
+			{ip: "10.0.0.1", allowed: true, inWhitelist: true}, // 旧版本以字符串保存的条目
+			{ip: "10.1.2.3", allowed: true, inWhitelist: true},
+			{ip: "192.168.0.1", allowed: true}, // 停用的条目
+		}
+		for _, tt := range tests {
+			if allowed, inWhitelist := IsAllowed(tt.ip); allowed != tt.allowed || inWhitelist != tt.inWhitelist {
+				mt.Errorf("%s: allowed=%v inWhitelist=%v，期望 %v %v", tt.ip, allowed, inWhitelist, tt.allowed, tt.inWhitelist)
+			}
+		}
+		if after := CurrentVersion(); after.Version <= before.Version || after.Hash == before.Hash {
+			mt.Errorf("重新加载后版本应变化: %+v -> %+v", before, after)
+		}
+
+		// 读取失败时保留当前规则
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}))
+		if _, err := LoadIPControlRules(context.Background()); err == nil {
+			mt.Error("读取失败时应返回错误")
+		}
+		if _, inWhitelist := IsAllowed("10.0.0.1"); !inWhitelist {
+			mt.Error("读取失败时应保留当前名单")
+		}
+	})
+}
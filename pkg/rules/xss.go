@@ -0,0 +1,182 @@
+// pkg/rules/xss.go
+
+package rules
+
+import (
+	"html"
+	"strings"
+)
+
+// XSS检测：按HTML语法解析输入中的标签和属性，而不是匹配固定字符串。
+// 输入可能出现在文本内容中，也可能被拼接进带引号的属性值，因此分别在这两种上下文中检查。
+
+// xssTags 出现即视为危险的标签
+var xssTags = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true,
+	"embed": true, "applet": true, "base": true, "link": true, "meta": true, "style": true,
+	"svg": true, "math": true, "xml": true, "import": true, "isindex": true, "vmlframe": true,
+}
+
+// xssURLAttributes 取值为URL的属性
+var xssURLAttributes = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "data": true,
+	"xlink:href": true, "background": true, "poster": true, "lowsrc": true, "dynsrc": true,
+	"codebase": true, "srcdoc": true,
+}
+
+// xssURLSchemes 危险的URL协议
+var xssURLSchemes = []string{"javascript:", "vbscript:", "livescript:", "data:text/html"}
+
+// IsXSS 判断输入是否为跨站脚本载荷
+func IsXSS(input string) bool {
+	// 文本上下文：输入中的标签
+	if hasDangerousTag(input) {
+		return true
+	}
+
+	// 属性值上下文：输入闭合引号后追加属性，例如 `" onmouseover="alert(1)`
+	for _, quote := range []byte{'"', '\'', '`'} {
+		if i := strings.IndexByte(input, quote); i >= 0 && hasDangerousAttribute(input[i+1:]) {
+			return true
+		}
+	}
+
+	// URL属性上下文：整个输入是一个脚本URL
+	return isDangerousURL(input)
+}
+
+// hasDangerousTag 查找危险标签，或带有危险属性的普通标签
+func hasDangerousTag(input string) bool {
+	for i := 0; i < len(input); i++ {
+		if input[i] != '<' || i+1 >= len(input) || !isLetter(input[i+1]) {
+			continue
+		}
+
+		start := i + 1
+		end := start
+		for end < len(input) && (isLetter(input[end]) || isDigit(input[end]) || input[end] == ':' || input[end] == '-') {
+			end++
+		}
+		if xssTags[strings.ToLower(input[start:end])] {
+			return true
+		}
+		if hasDangerousAttribute(input[end:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDangerousAttribute 解析标签内的属性列表直到 ">"，判断是否包含事件处理器或脚本URL
+func hasDangerousAttribute(input string) bool {
+	i := 0
+	for i < len(input) {
+		// 跳过属性间的空白和 "/"
+		for i < len(input) && (isHTMLSpace(input[i]) || input[i] == '/') {
+			i++
+		}
+		if i >= len(input) || input[i] == '>' {
+			return false
+		}
+
+		start := i
+		for i < len(input) && !isHTMLSpace(input[i]) && input[i] != '/' && input[i] != '>' && input[i] != '=' {
+			i++
+		}
+		name := strings.ToLower(input[start:i])
+		if i == start {
+			i++ // 孤立的 "="
+			continue
+		}
+
+		for i < len(input) && isHTMLSpace(input[i]) {
+			i++
+		}
+		if i >= len(input) || input[i] != '=' {
+			continue
+		}
+		i++
+		for i < len(input) && isHTMLSpace(input[i]) {
+			i++
+		}
+
+		var value string
+		if i < len(input) && (input[i] == '"' || input[i] == '\'' || input[i] == '`') {
+			quote := input[i]
+			end := strings.IndexByte(input[i+1:], quote)
+			if end < 0 {
+				end = len(input) - i - 1
+			}
+			value = input[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start := i
+			for i < len(input) && !isHTMLSpace(input[i]) && input[i] != '>' {
+				i++
+			}
+			value = input[start:i]
+		}
+
+		if isEventHandler(name) {
+			return true
+		}
+		if xssURLAttributes[name] && (isDangerousURL(value) || name == "srcdoc" && strings.Contains(html.UnescapeString(value), "<")) {
+			return true
+		}
+		if name == "style" && isDangerousStyle(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEventHandler 判断属性名是否为 onxxx 事件处理器
+func isEventHandler(name string) bool {
+	if len(name) <= 2 || !strings.HasPrefix(name, "on") {
+		return false
+	}
+	for i := 2; i < len(name); i++ {
+		if !isLetter(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isDangerousURL 解码HTML实体并去除浏览器会忽略的空白和控制字符后，判断是否为脚本URL
+func isDangerousURL(value string) bool {
+	value = html.UnescapeString(value)
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] > ' ' {
+			b.WriteByte(value[i])
+		}
+	}
+	normalized := strings.ToLower(b.String())
+	for _, scheme := range xssURLSchemes {
+		if strings.HasPrefix(normalized, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDangerousStyle 判断样式中是否包含可执行脚本的写法
+func isDangerousStyle(value string) bool {
+	value = strings.ToLower(html.UnescapeString(value))
+	value = strings.Join(strings.Fields(value), "")
+	for _, keyword := range []string{"expression(", "javascript:", "behavior:", "-moz-binding"} {
+		if strings.Contains(value, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -11,6 +11,11 @@ import (
 
 // LogTraffic 记录流量日志
 func LogTraffic(clientIP, targetIP, url, method string, headers http.Header, body, errorMsg string) {
+	LogTrafficWithDetails(clientIP, targetIP, url, method, headers, body, errorMsg, nil)
+}
+
+// LogTrafficWithDetails 记录流量日志，并附加额外字段（例如命中的规则）
+func LogTrafficWithDetails(clientIP, targetIP, url, method string, headers http.Header, body, errorMsg string, details map[string]interface{}) {
 	// 初始化日志数据
 	logData := map[string]interface{}{
 		"timestamp": time.Now(),
@@ -22,6 +27,10 @@ func LogTraffic(clientIP, targetIP, url, method string, headers http.Header, bod
 		"body":      body,
 	}
 
+	for key, value := range details {
+		logData[key] = value
+	}
+
 	// 设置状态和错误信息
 	if errorMsg != "" {
 		logData["status"] = "failed"